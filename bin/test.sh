@@ -5,6 +5,8 @@
 
 BASE_URL="http://localhost:8080"
 CONTENT_TYPE="Content-Type: application/json"
+# Create a key with: go run . api-keys create --name test-script --scope admin
+AUTHORIZATION="Authorization: Bearer ${API_KEY}"
 
 echo "=== Testing Messaging Service Endpoints ==="
 echo "Base URL: $BASE_URL"
@@ -13,6 +15,7 @@ echo
 # Test 1: Send SMS
 echo "1. Testing SMS send..."
 curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTHORIZATION" \
   -H "$CONTENT_TYPE" \
   -d '{
     "from": "+12016661234",
@@ -27,6 +30,7 @@ curl -X POST "$BASE_URL/api/messages/sms" \
 # Test 2: Send MMS
 echo "2. Testing MMS send..."
 curl -X POST "$BASE_URL/api/messages/sms" \
+  -H "$AUTHORIZATION" \
   -H "$CONTENT_TYPE" \
   -d '{
     "from": "+12016661234",
@@ -41,16 +45,21 @@ curl -X POST "$BASE_URL/api/messages/sms" \
 # Test 3: Send Email
 echo "3. Testing Email send..."
 curl -X POST "$BASE_URL/api/messages/email" \
+  -H "$AUTHORIZATION" \
   -H "$CONTENT_TYPE" \
   -d '{
     "from": "user@usehatchapp.com",
     "to": "contact@gmail.com",
+    "subject": "Test email",
     "body": "Hello! This is a test email message with <b>HTML</b> formatting.",
     "attachments": ["https://example.com/document.pdf"],
     "timestamp": "2024-11-01T14:00:00Z"
   }' \
   -w "\nStatus: %{http_code}\n\n"
 
+# The webhooks below are unsigned, so the server must run with VERIFY_WEBHOOKS=false
+# (the default for make server).
+
 # Test 4: Simulate incoming SMS webhook
 echo "4. Testing incoming SMS webhook..."
 curl -X POST "$BASE_URL/api/webhooks/sms" \
@@ -98,12 +107,14 @@ curl -X POST "$BASE_URL/api/webhooks/email" \
 # Test 7: Get conversations
 echo "7. Testing get conversations..."
 curl -X GET "$BASE_URL/api/conversations" \
+  -H "$AUTHORIZATION" \
   -H "$CONTENT_TYPE" \
   -w "\nStatus: %{http_code}\n\n"
 
 # Test 8: Get messages for a conversation (example conversation ID)
 echo "8. Testing get messages for conversation..."
 curl -X GET "$BASE_URL/api/conversations/1/messages" \
+  -H "$AUTHORIZATION" \
   -H "$CONTENT_TYPE" \
   -w "\nStatus: %{http_code}\n\n"
 
@@ -8,7 +8,10 @@ import (
 	"hatchapp/internal/app/server"
 	"hatchapp/internal/pkg/migration"
 	"hatchapp/internal/pkg/repository"
+	"hatchapp/internal/pkg/service"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/labstack/gommon/log"
 
@@ -67,9 +70,15 @@ var serviceFlags = []cli.Flag{
 	},
 	&cli.StringFlag{
 		Name:    "sendgrid-account-sid",
-		Value:   "your_twilio_account_sid",
-		Usage:   "Twilio Account SID",
-		Sources: cli.EnvVars("TWILIO_ACCOUNT_SID"),
+		Value:   "your_sendgrid_account_sid",
+		Usage:   "SendGrid Account SID",
+		Sources: cli.EnvVars("SENDGRID_ACCOUNT_SID"),
+	},
+	&cli.StringFlag{
+		Name:    "sendgrid-base-url",
+		Value:   service.DefaultSendGridBaseURL,
+		Usage:   "SendGrid API base URL",
+		Sources: cli.EnvVars("SENDGRID_BASE_URL"),
 	},
 	&cli.StringFlag{
 		Name:    "twilio-api-key",
@@ -83,6 +92,108 @@ var serviceFlags = []cli.Flag{
 		Usage:   "Twilio Account SID",
 		Sources: cli.EnvVars("TWILIO_ACCOUNT_SID"),
 	},
+	&cli.StringFlag{
+		Name:    "twilio-base-url",
+		Value:   service.DefaultTwilioBaseURL,
+		Usage:   "Twilio API base URL",
+		Sources: cli.EnvVars("TWILIO_BASE_URL"),
+	},
+	&cli.StringFlag{
+		Name:    "provider-timeout",
+		Value:   "10s",
+		Usage:   "timeout for requests to messaging providers",
+		Sources: cli.EnvVars("PROVIDER_TIMEOUT"),
+	},
+	&cli.StringFlag{
+		Name:    "retry-base-delay",
+		Value:   "100ms",
+		Usage:   "delay before the first retry of a failed provider request",
+		Sources: cli.EnvVars("RETRY_BASE_DELAY"),
+	},
+	&cli.StringFlag{
+		Name:    "retry-multiplier",
+		Value:   "2",
+		Usage:   "factor the retry delay grows by after each attempt",
+		Sources: cli.EnvVars("RETRY_MULTIPLIER"),
+	},
+	&cli.StringFlag{
+		Name:    "retry-max-delay",
+		Value:   "5s",
+		Usage:   "maximum delay between retries",
+		Sources: cli.EnvVars("RETRY_MAX_DELAY"),
+	},
+	&cli.StringFlag{
+		Name:    "retry-jitter",
+		Value:   "true",
+		Usage:   "apply full jitter to retry delays",
+		Sources: cli.EnvVars("RETRY_JITTER"),
+	},
+	&cli.StringFlag{
+		Name:    "retry-max-attempts",
+		Value:   "3",
+		Usage:   "maximum number of attempts for a provider request",
+		Sources: cli.EnvVars("RETRY_MAX_ATTEMPTS"),
+	},
+	&cli.StringFlag{
+		Name:    "retry-deadline",
+		Value:   "30s",
+		Usage:   "total time budget for retrying a provider request",
+		Sources: cli.EnvVars("RETRY_DEADLINE"),
+	},
+	&cli.StringFlag{
+		Name:    "outbox-workers",
+		Value:   "4",
+		Usage:   "number of workers delivering queued messages",
+		Sources: cli.EnvVars("OUTBOX_WORKERS"),
+	},
+	&cli.StringFlag{
+		Name:    "outbox-batch-size",
+		Value:   "10",
+		Usage:   "number of queued messages a worker claims at once",
+		Sources: cli.EnvVars("OUTBOX_BATCH_SIZE"),
+	},
+	&cli.StringFlag{
+		Name:    "outbox-poll-interval",
+		Value:   "1s",
+		Usage:   "how often idle workers check for queued messages",
+		Sources: cli.EnvVars("OUTBOX_POLL_INTERVAL"),
+	},
+	&cli.StringFlag{
+		Name:    "idempotency-ttl",
+		Value:   "24h",
+		Usage:   "how long an Idempotency-Key replays the original response",
+		Sources: cli.EnvVars("IDEMPOTENCY_TTL"),
+	},
+	&cli.StringFlag{
+		Name:    "require-api-keys",
+		Value:   "true",
+		Usage:   "require an API key on every route except provider webhooks",
+		Sources: cli.EnvVars("REQUIRE_API_KEYS"),
+	},
+	&cli.StringFlag{
+		Name:    "verify-webhooks",
+		Value:   "true",
+		Usage:   "reject provider webhooks without a valid signature",
+		Sources: cli.EnvVars("VERIFY_WEBHOOKS"),
+	},
+	&cli.StringFlag{
+		Name:    "sendgrid-webhook-public-key",
+		Value:   "",
+		Usage:   "public key of SendGrid's signed event webhook",
+		Sources: cli.EnvVars("SENDGRID_WEBHOOK_PUBLIC_KEY"),
+	},
+	&cli.StringFlag{
+		Name:    "webhook-public-url",
+		Value:   "",
+		Usage:   "scheme and host providers use to reach the webhooks, e.g. https://api.example.com",
+		Sources: cli.EnvVars("WEBHOOK_PUBLIC_URL"),
+	},
+	&cli.StringFlag{
+		Name:    "webhook-max-skew",
+		Value:   "5m",
+		Usage:   "maximum age of a signed webhook before it is rejected as a replay",
+		Sources: cli.EnvVars("WEBHOOK_MAX_SKEW"),
+	},
 }
 
 func Run() {
@@ -96,13 +207,7 @@ func Run() {
 				Action: func(ctx context.Context, cliCmd *cli.Command) error {
 					log.Info("Preparing migrations...")
 
-					host := cliCmd.String("db-host")
-					port := cliCmd.String("db-port")
-					username := cliCmd.String("db-username")
-					password := cliCmd.String("db-password")
-					dbName := cliCmd.String("db-name")
-
-					connectionString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, dbName)
+					connectionString := connectionStringFromFlags(cliCmd)
 					m, err := migration.Initialize(connectionString)
 					if err != nil {
 						return fmt.Errorf("failed to initialize migration: %w", err)
@@ -165,18 +270,15 @@ func Run() {
 				Action: func(ctx context.Context, cliCmd *cli.Command) error {
 					log.Info("Starting server...")
 
-					host := cliCmd.String("db-host")
-					port := cliCmd.String("db-port")
-					username := cliCmd.String("db-username")
-					password := cliCmd.String("db-password")
-					dbName := cliCmd.String("db-name")
-
-					connectionString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, dbName)
+					connectionString := connectionStringFromFlags(cliCmd)
 
 					twilioAccountSID := cliCmd.String("twilio-account-sid")
 					twilioAPIKey := cliCmd.String("twilio-api-key")
 					sendgridAPIKey := cliCmd.String("sendgrid-api-key")
 					sendgridAccountSID := cliCmd.String("sendgrid-account-sid")
+					sendgridBaseURL := cliCmd.String("sendgrid-base-url")
+					twilioBaseURL := cliCmd.String("twilio-base-url")
+					providerTimeout := cliCmd.String("provider-timeout")
 
 					appConfig := map[string]string{
 						"db_connection_string": connectionString,
@@ -184,6 +286,25 @@ func Run() {
 						"twilio_api_key":       twilioAPIKey,
 						"sendgrid_api_key":     sendgridAPIKey,
 						"sendgrid_account_sid": sendgridAccountSID,
+						"sendgrid_base_url":    sendgridBaseURL,
+						"twilio_base_url":      twilioBaseURL,
+						"provider_timeout":     providerTimeout,
+						"retry_base_delay":     cliCmd.String("retry-base-delay"),
+						"retry_multiplier":     cliCmd.String("retry-multiplier"),
+						"retry_max_delay":      cliCmd.String("retry-max-delay"),
+						"retry_jitter":         cliCmd.String("retry-jitter"),
+						"retry_max_attempts":   cliCmd.String("retry-max-attempts"),
+						"retry_deadline":       cliCmd.String("retry-deadline"),
+						"outbox_workers":       cliCmd.String("outbox-workers"),
+						"outbox_batch_size":    cliCmd.String("outbox-batch-size"),
+						"outbox_poll_interval": cliCmd.String("outbox-poll-interval"),
+						"idempotency_ttl":      cliCmd.String("idempotency-ttl"),
+						"require_api_keys":     cliCmd.String("require-api-keys"),
+						"verify_webhooks":      cliCmd.String("verify-webhooks"),
+						"webhook_public_url":   cliCmd.String("webhook-public-url"),
+						"webhook_max_skew":     cliCmd.String("webhook-max-skew"),
+
+						"sendgrid_webhook_public_key": cliCmd.String("sendgrid-webhook-public-key"),
 					}
 					ctx = config.SaveConfigToContext(ctx, appConfig)
 
@@ -201,6 +322,119 @@ func Run() {
 					return nil
 				},
 			},
+			{
+				Name:  "api-keys",
+				Usage: "Manage API keys",
+				Commands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "Create an API key and print it once",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "what the key is used for",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "user",
+								Usage: "inbox user the key acts for, needed to read conversations or write notes as that user",
+							},
+							&cli.StringFlag{
+								Name:  "tenant",
+								Usage: "tenant the key belongs to; keys of a tenant share its tags",
+							},
+							&cli.StringSliceFlag{
+								Name:     "scope",
+								Usage:    "scope to grant, one of " + strings.Join(server.Scopes, ", "),
+								Required: true,
+							},
+						}, dbFlags...),
+						Action: func(ctx context.Context, cliCmd *cli.Command) error {
+							scopes := cliCmd.StringSlice("scope")
+							for _, scope := range scopes {
+								if !server.ValidScope(scope) {
+									return fmt.Errorf("invalid scope %q, must be one of %s", scope, strings.Join(server.Scopes, ", "))
+								}
+							}
+
+							repo, err := repositoryFromFlags(ctx, cliCmd)
+							if err != nil {
+								return err
+							}
+							defer repo.Close()
+
+							key, prefix, hash, err := server.GenerateAPIKey()
+							if err != nil {
+								return err
+							}
+
+							created, err := repo.CreateAPIKey(ctx, repository.APIKey{
+								Name:     cliCmd.String("name"),
+								Prefix:   prefix,
+								Scopes:   scopes,
+								UserID:   cliCmd.String("user"),
+								TenantID: cliCmd.String("tenant"),
+							}, hash)
+							if err != nil {
+								return fmt.Errorf("failed to create API key: %w", err)
+							}
+
+							fmt.Printf("Created API key %d. It will not be shown again:\n%s\n", created.ID, key)
+							return nil
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List API keys",
+						Flags: dbFlags,
+						Action: func(ctx context.Context, cliCmd *cli.Command) error {
+							repo, err := repositoryFromFlags(ctx, cliCmd)
+							if err != nil {
+								return err
+							}
+							defer repo.Close()
+
+							keys, err := repo.GetAPIKeys(ctx)
+							if err != nil {
+								return fmt.Errorf("failed to list API keys: %w", err)
+							}
+
+							w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+							fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tUSER\tTENANT\tCREATED\tLAST USED\tREVOKED")
+							for _, key := range keys {
+								fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","),
+									orDash(key.UserID), orDash(key.TenantID), key.CreatedAt, orDash(key.LastUsedAt), orDash(key.RevokedAt))
+							}
+							return w.Flush()
+						},
+					},
+					{
+						Name:      "revoke",
+						Usage:     "Revoke an API key",
+						ArgsUsage: "<id>",
+						Flags:     dbFlags,
+						Action: func(ctx context.Context, cliCmd *cli.Command) error {
+							id := cliCmd.Args().First()
+							if id == "" {
+								return errors.New("the id of the API key to revoke is required")
+							}
+
+							repo, err := repositoryFromFlags(ctx, cliCmd)
+							if err != nil {
+								return err
+							}
+							defer repo.Close()
+
+							if err := repo.RevokeAPIKey(ctx, id); err != nil {
+								return fmt.Errorf("failed to revoke API key %s: %w", id, err)
+							}
+
+							fmt.Printf("Revoked API key %s\n", id)
+							return nil
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -208,3 +442,33 @@ func Run() {
 		log.Fatal(err)
 	}
 }
+
+// connectionStringFromFlags builds the Postgres connection string from the database flags.
+func connectionStringFromFlags(cliCmd *cli.Command) string {
+	host := cliCmd.String("db-host")
+	port := cliCmd.String("db-port")
+	username := cliCmd.String("db-username")
+	password := cliCmd.String("db-password")
+	dbName := cliCmd.String("db-name")
+
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, dbName)
+}
+
+// repositoryFromFlags connects to the database given by the database flags.
+func repositoryFromFlags(ctx context.Context, cliCmd *cli.Command) (repository.Repository, error) {
+	ctx = config.SaveConfigToContext(ctx, map[string]string{
+		"db_connection_string": connectionStringFromFlags(cliCmd),
+	})
+	if err := repository.Initialize(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize repository: %w", err)
+	}
+
+	return repository.GetRepository()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
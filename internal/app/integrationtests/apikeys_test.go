@@ -0,0 +1,346 @@
+package integrationtests_test
+
+import (
+	"context"
+	"fmt"
+	"hatchapp/internal/app/server"
+	"hatchapp/internal/pkg/repository"
+	"hatchapp/internal/pkg/testutils"
+	"net/http"
+	"strconv"
+	"testing"
+
+	oapi "github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/khaiql/dbcleaner.v2"
+	"gopkg.in/khaiql/dbcleaner.v2/engine"
+)
+
+func TestAPIKeys(t *testing.T) {
+	postgres := engine.NewPostgresEngine(testutils.ConnectionString)
+	cleaner := dbcleaner.New()
+	cleaner.SetEngine(postgres)
+
+	e := testutils.NewServerWithAPIKeys()
+
+	createTenantKey := func(t *testing.T, tenantID string, scopes ...string) (*repository.APIKey, string) {
+		repo, err := repository.GetRepository()
+		if err != nil {
+			t.Fatalf("Failed to get repository: %v", err)
+		}
+		key, prefix, hash, err := server.GenerateAPIKey()
+		if err != nil {
+			t.Fatalf("Failed to generate API key: %v", err)
+		}
+		created, err := repo.CreateAPIKey(context.Background(), repository.APIKey{Name: "test", Prefix: prefix, Scopes: scopes, TenantID: tenantID}, hash)
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		return created, key
+	}
+
+	createKey := func(t *testing.T, userID string, scopes ...string) (*repository.APIKey, string) {
+		repo, err := repository.GetRepository()
+		if err != nil {
+			t.Fatalf("Failed to get repository: %v", err)
+		}
+		key, prefix, hash, err := server.GenerateAPIKey()
+		if err != nil {
+			t.Fatalf("Failed to generate API key: %v", err)
+		}
+		created, err := repo.CreateAPIKey(context.Background(), repository.APIKey{Name: "test", Prefix: prefix, Scopes: scopes, UserID: userID}, hash)
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		return created, key
+	}
+
+	sendText := func(t *testing.T, key string) int {
+		request := oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is an authenticated message.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		})
+		if key != "" {
+			request = request.WithHeader("Authorization", "Bearer "+key)
+		}
+		return request.GoWithHTTPHandler(t, e).Code()
+	}
+
+	listConversations := func(t *testing.T, key string) int {
+		return oapi.NewRequest().Get("/api/conversations").
+			WithHeader("Authorization", "Bearer "+key).
+			GoWithHTTPHandler(t, e).Code()
+	}
+
+	t.Run("requests without a valid key are rejected", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		response := oapi.NewRequest().Get("/api/conversations").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnauthorized, response.Code(), "expected a request without a key to be rejected")
+		assert.Equal(t, "Bearer", response.Recorder.Header().Get("WWW-Authenticate"))
+
+		assert.Equal(t, http.StatusUnauthorized, listConversations(t, "hk_unknown"), "expected an unknown key to be rejected")
+		assert.Equal(t, http.StatusUnauthorized, sendText(t, ""), "expected sending without a key to be rejected")
+	})
+
+	t.Run("keys are limited to their scopes", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		_, readKey := createKey(t, "", server.ScopeConversationsRead)
+		_, sendKey := createKey(t, "", server.ScopeMessagesSend)
+		_, adminKey := createKey(t, "", server.ScopeAdmin)
+
+		assert.Equal(t, http.StatusOK, listConversations(t, readKey))
+		assert.Equal(t, http.StatusForbidden, sendText(t, readKey), "expected a read-only key not to send")
+
+		assert.Equal(t, http.StatusAccepted, sendText(t, sendKey))
+		assert.Equal(t, http.StatusForbidden, listConversations(t, sendKey), "expected a send-only key not to read")
+
+		assert.Equal(t, http.StatusAccepted, sendText(t, adminKey), "expected admin to grant every scope")
+		assert.Equal(t, http.StatusOK, listConversations(t, adminKey), "expected admin to grant every scope")
+
+		response := oapi.NewRequest().Post("/api/tags").
+			WithHeader("Authorization", "Bearer "+readKey).
+			WithJsonBody(server.TagInput{Name: "vip"}).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusForbidden, response.Code(), "expected managing tags to require admin")
+	})
+
+	t.Run("requests act for the key's user", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, is anyone there?",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", response.Code())
+		}
+
+		_, userKey := createKey(t, "agent-1", server.ScopeConversationsWrite)
+		_, serviceKey := createKey(t, "", server.ScopeConversationsWrite)
+		_, readKey := createKey(t, "agent-1", server.ScopeConversationsRead)
+
+		var page repository.ConversationPage
+		response = oapi.NewRequest().Get("/api/conversations").
+			WithHeader("Authorization", "Bearer "+userKey).
+			GoWithHTTPHandler(t, e)
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(page.Conversations) != 1 {
+			t.Fatalf("Expected one conversation, got %d", len(page.Conversations))
+		}
+		path := fmt.Sprintf("/api/conversations/%d/read", page.Conversations[0].ID)
+
+		markRead := func(key string) *oapi.CompletedRequest {
+			return oapi.NewRequest().Post(path).
+				WithHeader("Authorization", "Bearer "+key).
+				WithHeader(server.HeaderUserID, "agent-2").
+				GoWithHTTPHandler(t, e)
+		}
+
+		response = markRead(userKey)
+		assert.Equal(t, http.StatusOK, response.Code())
+		var receipt repository.ReadReceipt
+		if err := response.UnmarshalBodyToObject(&receipt); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, "agent-1", receipt.UserID, "expected the read state of the key's user, not the X-User-ID header's")
+
+		assert.Equal(t, http.StatusForbidden, markRead(serviceKey).Code(), "expected keys without a user not to mark conversations read")
+		assert.Equal(t, http.StatusForbidden, markRead(readKey).Code(), "expected marking read to need the write scope")
+
+		response = oapi.NewRequest().Post(fmt.Sprintf("/api/conversations/%d/notes", page.Conversations[0].ID)).
+			WithHeader("Authorization", "Bearer "+userKey).
+			WithHeader(server.HeaderUserID, "agent-2").
+			WithJsonBody(server.NoteInput{Body: "Called back"}).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusCreated, response.Code())
+		var note repository.Note
+		if err := response.UnmarshalBodyToObject(&note); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, "agent-1", note.Author, "expected notes to be written by the key's user")
+	})
+
+	t.Run("idempotency keys are scoped to the API key", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		send := func(key, body string) int {
+			return oapi.NewRequest().Post("/api/messages/sms").
+				WithHeader("Authorization", "Bearer "+key).
+				WithHeader(server.HeaderIdempotencyKey, "order-1").
+				WithJsonBody(server.TextMessage{
+					From:        "+1234567890",
+					To:          server.Recipients{"+0987654321"},
+					Type:        "sms",
+					Body:        body,
+					Attachments: []string{},
+					CreatedAt:   "2023-10-01T12:00:00Z",
+				}).GoWithHTTPHandler(t, e).Code()
+		}
+
+		_, firstKey := createKey(t, "", server.ScopeMessagesSend)
+		_, secondKey := createKey(t, "", server.ScopeMessagesSend)
+
+		assert.Equal(t, http.StatusAccepted, send(firstKey, "Your order has shipped."))
+		assert.Equal(t, http.StatusAccepted, send(secondKey, "Your table is ready."), "expected another key's Idempotency-Key not to collide")
+		assert.Equal(t, http.StatusUnprocessableEntity, send(firstKey, "Your table is ready."), "expected a key's own reuse to be rejected")
+	})
+
+	t.Run("tags are scoped to the key's tenant", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, is anyone there?",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", response.Code())
+		}
+
+		_, acmeKey := createTenantKey(t, "acme", server.ScopeAdmin)
+		_, globexKey := createTenantKey(t, "globex", server.ScopeAdmin)
+
+		createTag := func(key, name string) (*oapi.CompletedRequest, repository.Tag) {
+			response := oapi.NewRequest().Post("/api/tags").
+				WithHeader("Authorization", "Bearer "+key).
+				WithJsonBody(server.TagInput{Name: name}).
+				GoWithHTTPHandler(t, e)
+			var tag repository.Tag
+			if response.Code() == http.StatusCreated {
+				if err := response.UnmarshalBodyToObject(&tag); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+			}
+			return response, tag
+		}
+
+		response, acmeTag := createTag(acmeKey, "vip")
+		assert.Equal(t, http.StatusCreated, response.Code())
+		response, _ = createTag(globexKey, "VIP")
+		assert.Equal(t, http.StatusCreated, response.Code(), "expected another tenant to reuse the name")
+		response, _ = createTag(acmeKey, "Vip")
+		assert.Equal(t, http.StatusConflict, response.Code(), "expected names to be unique within a tenant")
+
+		getTags := func(key string) []repository.TagCount {
+			var tags []repository.TagCount
+			response := oapi.NewRequest().Get("/api/tags").
+				WithHeader("Authorization", "Bearer "+key).
+				GoWithHTTPHandler(t, e)
+			if err := response.UnmarshalBodyToObject(&tags); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return tags
+		}
+
+		acmeTags := getTags(acmeKey)
+		if assert.Len(t, acmeTags, 1) {
+			assert.Equal(t, "vip", acmeTags[0].Name)
+		}
+		globexTags := getTags(globexKey)
+		if assert.Len(t, globexTags, 1) {
+			assert.Equal(t, "VIP", globexTags[0].Name)
+		}
+
+		getConversations := func(key, tag string) repository.ConversationPage {
+			var page repository.ConversationPage
+			request := oapi.NewRequest().Get("/api/conversations")
+			if tag != "" {
+				request = oapi.NewRequest().Get("/api/conversations?tag=" + tag)
+			}
+			response := request.WithHeader("Authorization", "Bearer "+key).GoWithHTTPHandler(t, e)
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page
+		}
+
+		page := getConversations(acmeKey, "")
+		if len(page.Conversations) != 1 {
+			t.Fatalf("Expected one conversation, got %d", len(page.Conversations))
+		}
+		response = oapi.NewRequest().Post(fmt.Sprintf("/api/conversations/%d/tags", page.Conversations[0].ID)).
+			WithHeader("Authorization", "Bearer "+acmeKey).
+			WithJsonBody(server.TagInput{Name: "vip"}).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusOK, response.Code())
+
+		assert.Equal(t, []string{"vip"}, getConversations(acmeKey, "").Conversations[0].Tags)
+		assert.Len(t, getConversations(acmeKey, "vip").Conversations, 1)
+		assert.Empty(t, getConversations(globexKey, "").Conversations[0].Tags, "expected another tenant's tags to be hidden")
+		assert.Empty(t, getConversations(globexKey, "vip").Conversations, "expected the filter to use the tenant's own tag")
+
+		response = oapi.NewRequest().Delete(fmt.Sprintf("/api/tags/%d", acmeTag.ID)).
+			WithHeader("Authorization", "Bearer "+globexKey).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code(), "expected another tenant's tag not to be deleted")
+		assert.Len(t, getTags(acmeKey), 1)
+	})
+
+	t.Run("webhooks do not need a key", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a webhook.",
+			Attachments: []string{},
+			ProviderID:  "SMnokey",
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusCreated, response.Code())
+	})
+
+	t.Run("revoked keys are rejected and use is recorded", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		repo, err := repository.GetRepository()
+		if err != nil {
+			t.Fatalf("Failed to get repository: %v", err)
+		}
+
+		created, key := createKey(t, "", server.ScopeConversationsRead)
+		assert.Equal(t, http.StatusOK, listConversations(t, key))
+
+		keys, err := repo.GetAPIKeys(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to list API keys: %v", err)
+		}
+		assert.Len(t, keys, 1)
+		assert.NotEmpty(t, keys[0].LastUsedAt, "expected the key's use to be recorded")
+		assert.Empty(t, keys[0].RevokedAt)
+
+		if err := repo.RevokeAPIKey(context.Background(), strconv.FormatInt(created.ID, 10)); err != nil {
+			t.Fatalf("Failed to revoke API key: %v", err)
+		}
+		assert.Equal(t, http.StatusUnauthorized, listConversations(t, key), "expected a revoked key to be rejected")
+
+		keys, err = repo.GetAPIKeys(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to list API keys: %v", err)
+		}
+		assert.NotEmpty(t, keys[0].RevokedAt, "expected the key to be marked revoked")
+	})
+}
@@ -0,0 +1,218 @@
+package integrationtests_test
+
+import (
+	"fmt"
+	"hatchapp/internal/app/server"
+	"hatchapp/internal/pkg/repository"
+	"hatchapp/internal/pkg/testutils"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	oapi "github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/khaiql/dbcleaner.v2"
+	"gopkg.in/khaiql/dbcleaner.v2/engine"
+)
+
+func TestContacts(t *testing.T) {
+	postgres := engine.NewPostgresEngine(testutils.ConnectionString)
+	cleaner := dbcleaner.New()
+	cleaner.SetEngine(postgres)
+
+	e := testutils.NewServer()
+
+	t.Run("create, update and delete a contact", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		contact := createContact(t, e, server.ContactInput{
+			Name:        "Ada Lovelace",
+			Metadata:    map[string]any{"plan": "pro"},
+			Identifiers: []string{"+12345678901", "ada@example.com"},
+		})
+		assert.Equal(t, "Ada Lovelace", contact.Name)
+		assert.Equal(t, "pro", contact.Metadata["plan"])
+		assert.ElementsMatch(t, []string{"+12345678901", "ada@example.com"}, identifiers(contact))
+
+		// An identity belongs to a single contact.
+		response := oapi.NewRequest().Post("/api/contacts").
+			WithJsonBody(server.ContactInput{Name: "Impostor", Identifiers: []string{"ada@example.com"}}).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusConflict, response.Code())
+
+		path := fmt.Sprintf("/api/contacts/%d", contact.ID)
+		response = oapi.NewRequest().Put(path).
+			WithJsonBody(server.ContactInput{Name: "Ada King", Identifiers: []string{"ada@example.com"}}).
+			GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var updated repository.Contact
+		if err := response.UnmarshalBodyToObject(&updated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, "Ada King", updated.Name)
+		assert.Equal(t, []string{"ada@example.com"}, identifiers(updated))
+
+		response = oapi.NewRequest().Delete(path).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNoContent, response.Code())
+
+		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code())
+	})
+
+	t.Run("merge and unmerge contacts", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		phone := createContact(t, e, server.ContactInput{Name: "Grace", Identifiers: []string{"+12345678901"}})
+		email := createContact(t, e, server.ContactInput{Name: "Grace Hopper", Identifiers: []string{"grace@example.com"}})
+
+		mergePath := fmt.Sprintf("/api/contacts/%d/merge", phone.ID)
+		response := oapi.NewRequest().Post(mergePath).
+			WithJsonBody(server.MergeInput{ContactID: email.ID}).
+			GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var merged repository.Contact
+		if err := response.UnmarshalBodyToObject(&merged); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.ElementsMatch(t, []string{"+12345678901", "grace@example.com"}, identifiers(merged))
+
+		response = oapi.NewRequest().Get("/api/contacts").GoWithHTTPHandler(t, e)
+		var page repository.ContactPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, page.Contacts, 1, "expected merged contacts to be hidden from the list") {
+			assert.Equal(t, phone.ID, page.Contacts[0].ID)
+		}
+
+		// Merging twice is rejected.
+		response = oapi.NewRequest().Post(mergePath).
+			WithJsonBody(server.MergeInput{ContactID: email.ID}).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusConflict, response.Code())
+
+		response = oapi.NewRequest().Post(fmt.Sprintf("/api/contacts/%d/unmerge", email.ID)).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var unmerged repository.Contact
+		if err := response.UnmarshalBodyToObject(&unmerged); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Nil(t, unmerged.MergedIntoID)
+		assert.Equal(t, []string{"grace@example.com"}, identifiers(unmerged))
+
+		response = oapi.NewRequest().Get(fmt.Sprintf("/api/contacts/%d", phone.ID)).GoWithHTTPHandler(t, e)
+		var restored repository.Contact
+		if err := response.UnmarshalBodyToObject(&restored); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, []string{"+12345678901"}, identifiers(restored))
+	})
+
+	t.Run("timeline interleaves channels", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		sms := server.TextMessage{
+			From:      "+12345678901",
+			To:        server.Recipients{"+10987654321"},
+			Type:      "sms",
+			Body:      "Texting first",
+			CreatedAt: "2023-10-01T12:00:00Z",
+		}
+		email := server.EmailMessage{
+			From:      "linus@example.com",
+			To:        server.Recipients{"support@example.com"},
+			Body:      "Then emailing",
+			CreatedAt: "2023-10-01T12:05:00Z",
+		}
+		mms := server.TextMessage{
+			From:        "+12345678901",
+			To:          server.Recipients{"+10987654321"},
+			Type:        "mms",
+			Body:        "And a picture",
+			Attachments: []string{"http://example.com/image.jpg"},
+			CreatedAt:   "2023-10-01T12:10:00Z",
+		}
+		inbound := []struct {
+			path string
+			body any
+		}{
+			{"/api/webhooks/sms", sms},
+			{"/api/webhooks/email", email},
+			{"/api/webhooks/sms", mms},
+		}
+		for _, msg := range inbound {
+			response := oapi.NewRequest().Post(msg.path).WithJsonBody(msg.body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		contact := createContact(t, e, server.ContactInput{
+			Name:        "Linus",
+			Identifiers: []string{"+12345678901", "linus@example.com"},
+		})
+
+		path := fmt.Sprintf("/api/contacts/%d/timeline?limit=2", contact.ID)
+		response := oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var page repository.TimelinePage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, page.Messages, 2) {
+			assert.Equal(t, "And a picture", page.Messages[0].Body)
+			assert.Equal(t, "Then emailing", page.Messages[1].Body)
+			assert.NotEqual(t, page.Messages[0].ConversationID, page.Messages[1].ConversationID)
+		}
+		assert.NotEmpty(t, page.NextCursor)
+
+		response = oapi.NewRequest().Get(path+"&cursor="+page.NextCursor).GoWithHTTPHandler(t, e)
+		var next repository.TimelinePage
+		if err := response.UnmarshalBodyToObject(&next); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, next.Messages, 1) {
+			assert.Equal(t, "Texting first", next.Messages[0].Body)
+		}
+		assert.Empty(t, next.NextCursor)
+	})
+}
+
+func createContact(t *testing.T, e *echo.Echo, input server.ContactInput) repository.Contact {
+	t.Helper()
+
+	response := oapi.NewRequest().Post("/api/contacts").WithJsonBody(input).GoWithHTTPHandler(t, e)
+	if response.Code() != http.StatusCreated {
+		t.Fatalf("Expected status code 201, got %d", response.Code())
+	}
+
+	var contact repository.Contact
+	if err := response.UnmarshalBodyToObject(&contact); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	return contact
+}
+
+func identifiers(contact repository.Contact) []string {
+	values := make([]string, 0, len(contact.Identifiers))
+	for _, comm := range contact.Identifiers {
+		values = append(values, comm.Identifier)
+	}
+	return values
+}
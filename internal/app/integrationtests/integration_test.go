@@ -1,15 +1,25 @@
 package integrationtests_test
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"hatchapp/internal/app/server"
 	"hatchapp/internal/pkg/repository"
 	"hatchapp/internal/pkg/service"
 	"hatchapp/internal/pkg/testutils"
+	"hatchapp/internal/pkg/worker"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/labstack/echo/v4"
 	oapi "github.com/oapi-codegen/testutil"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/khaiql/dbcleaner.v2"
@@ -17,10 +27,23 @@ import (
 )
 
 var tables = []string{
+	"conversation_reads",
+	"conversation_assignments",
+	"conversation_notes",
+	"conversation_tags",
+	"message_tags",
+	"tags",
+	"idempotency_keys",
+	"message_recipients",
+	"message_status_history",
+	"outbox",
 	"messages",
 	"conversations",
 	"conversation_memberships",
 	"communications",
+	"contacts",
+	"agents",
+	"api_keys",
 }
 
 func TestMain(m *testing.M) {
@@ -37,7 +60,8 @@ func TestMessagesAndConversations(t *testing.T) {
 
 	emailService := service.NewEmailService("apiKey", "accountID")
 	textService := service.NewTextService("apiKey", "accountID")
-	e := testutils.NewServer(emailService, textService)
+	e := testutils.NewServer()
+	w := testutils.NewWorker(emailService, textService)
 
 	t.Run("send and save SMS message", func(t *testing.T) {
 		cleaner.Acquire(tables...)
@@ -46,7 +70,7 @@ func TestMessagesAndConversations(t *testing.T) {
 		path := "/api/messages/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
+			To:          server.Recipients{"+0987654321"},
 			Type:        "sms",
 			Body:        "Hello, this is a test message.",
 			Attachments: []string{},
@@ -54,8 +78,8 @@ func TestMessagesAndConversations(t *testing.T) {
 		}
 
 		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusCreated {
-			t.Fatalf("Expected status code 201, got %d", response.Code())
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
 		result := make(map[string]string)
@@ -63,8 +87,13 @@ func TestMessagesAndConversations(t *testing.T) {
 			t.Fatalf("Failed to unmarshal response: %v", err)
 		}
 
-		assert.NotEmpty(t, result["provider_id"], "expected provider_id to be present in response")
+		assert.Equal(t, repository.MessageStatusQueued, result["status"], "expected message to be queued for delivery")
 		assert.NotEmpty(t, result["message_id"], "expected message_id to be present in response")
+
+		messages := deliverAndGetMessages(t, e, w)
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.NotEmpty(t, messages[0].ProviderID, "expected provider_id to be set once the message is delivered")
+		assert.Equal(t, repository.MessageStatusSent, messages[0].Status, "expected message to be sent")
 	})
 
 	t.Run("send and save MMS message", func(t *testing.T) {
@@ -74,7 +103,7 @@ func TestMessagesAndConversations(t *testing.T) {
 		path := "/api/messages/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
+			To:          server.Recipients{"+0987654321"},
 			Type:        "mms",
 			Body:        "Hello, this is a test message.",
 			Attachments: []string{},
@@ -82,8 +111,8 @@ func TestMessagesAndConversations(t *testing.T) {
 		}
 
 		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusCreated {
-			t.Fatalf("Expected status code 201, got %d", response.Code())
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
 		result := make(map[string]string)
@@ -91,8 +120,13 @@ func TestMessagesAndConversations(t *testing.T) {
 			t.Fatalf("Failed to unmarshal response: %v", err)
 		}
 
-		assert.NotEmpty(t, result["provider_id"], "expected provider_id to be present in response")
+		assert.Equal(t, repository.MessageStatusQueued, result["status"], "expected message to be queued for delivery")
 		assert.NotEmpty(t, result["message_id"], "expected message_id to be present in response")
+
+		messages := deliverAndGetMessages(t, e, w)
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.NotEmpty(t, messages[0].ProviderID, "expected provider_id to be set once the message is delivered")
+		assert.Equal(t, repository.MessageStatusSent, messages[0].Status, "expected message to be sent")
 	})
 
 	t.Run("incoming SMS via webhook", func(t *testing.T) {
@@ -101,7 +135,7 @@ func TestMessagesAndConversations(t *testing.T) {
 		path := "/api/webhooks/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
+			To:          server.Recipients{"+0987654321"},
 			Type:        "sms",
 			Body:        "Hello, this is a test message via webhook.",
 			Attachments: []string{"http://example.com/image.jpg"},
@@ -118,6 +152,47 @@ func TestMessagesAndConversations(t *testing.T) {
 		}
 		assert.NotEmpty(t, result["provider_id"], "expected provider_id to be present in response")
 		assert.NotEmpty(t, result["message_id"], "expected message_id to be present in response")
+
+		messages := getMessages(t, e)
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.Equal(t, repository.MessageStatusReceived, messages[0].Status, "expected inbound messages to be received")
+	})
+
+	t.Run("redelivered SMS webhooks are deduplicated", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+		path := "/api/webhooks/sms"
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message via webhook.",
+			Attachments: []string{},
+			ProviderID:  "provider123",
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		first := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if first.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", first.Code())
+		}
+		redelivered := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if redelivered.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200 for a redelivery, got %d", redelivered.Code())
+		}
+
+		firstResult := make(map[string]string)
+		if err := first.UnmarshalBodyToObject(&firstResult); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		redeliveredResult := make(map[string]string)
+		if err := redelivered.UnmarshalBodyToObject(&redeliveredResult); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, firstResult["message_id"], redeliveredResult["message_id"], "expected the existing message ID for a redelivery")
+
+		messages := getMessages(t, e)
+		assert.Len(t, messages, 1, "expected a redelivered webhook not to be stored twice")
 	})
 
 	t.Run("incoming MMS via webhook", func(t *testing.T) {
@@ -126,7 +201,7 @@ func TestMessagesAndConversations(t *testing.T) {
 		path := "/api/webhooks/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
+			To:          server.Recipients{"+0987654321"},
 			Type:        "mms",
 			Body:        "Hello, this is a test message via webhook.",
 			Attachments: []string{"http://example.com/image.jpg"},
@@ -151,23 +226,28 @@ func TestMessagesAndConversations(t *testing.T) {
 		path := "/api/messages/email"
 		body := server.EmailMessage{
 			From:        "sender@example.com",
-			To:          "recipient@example.com",
+			To:          server.Recipients{"recipient@example.com"},
 			Body:        "Hello, this is a test email.",
 			Attachments: []string{},
 			CreatedAt:   "2023-10-01T12:00:00Z",
 		}
 
 		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusCreated {
-			t.Fatalf("Expected status code 201, got %d", response.Code())
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
 		result := make(map[string]string)
 		if err := response.UnmarshalBodyToObject(&result); err != nil {
 			t.Fatalf("Failed to unmarshal response: %v", err)
 		}
-		assert.NotEmpty(t, result["provider_id"], "expected provider_id to be present in response")
+		assert.Equal(t, repository.MessageStatusQueued, result["status"], "expected message to be queued for delivery")
 		assert.NotEmpty(t, result["message_id"], "expected message_id to be present in response")
+
+		messages := deliverAndGetMessages(t, e, w)
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.NotEmpty(t, messages[0].ProviderID, "expected provider_id to be set once the message is delivered")
+		assert.Equal(t, repository.MessageStatusSent, messages[0].Status, "expected message to be sent")
 	})
 
 	t.Run("incoming email via webhook", func(t *testing.T) {
@@ -177,7 +257,7 @@ func TestMessagesAndConversations(t *testing.T) {
 		path := "/api/webhooks/email"
 		body := server.EmailMessage{
 			From:        "sender@example.com",
-			To:          "recipient@example.com",
+			To:          server.Recipients{"recipient@example.com"},
 			Body:        "Hello, this is a test email.",
 			ProviderID:  "provider123",
 			Attachments: []string{},
@@ -202,13 +282,14 @@ func TestMessagesAndConversations(t *testing.T) {
 		defer cleaner.Clean(tables...)
 
 		emailService := service.NewEmailService("apiKey", "accountID")
-		textService := service.NewTextServiceWithError("apiKey", "accountID", http.StatusTooManyRequests, "Rate limit exceeded")
-		e := testutils.NewServer(emailService, textService)
+		transport := service.NewMockTransport("apiKey", "accountID", http.StatusTooManyRequests, "Rate limit exceeded")
+		textService := service.NewTwilioService(transport, "accountID", "apiKey")
+		w := testutils.NewWorker(emailService, textService)
 
 		path := "/api/messages/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
+			To:          server.Recipients{"+0987654321"},
 			Type:        "sms",
 			Body:        "Hello, this is a test message.",
 			Attachments: []string{},
@@ -216,109 +297,1891 @@ func TestMessagesAndConversations(t *testing.T) {
 		}
 
 		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusCreated {
-			// Even though the service request failed, we still expect the message to be saved to be retried later
-			t.Fatalf("Expected status code 201, got %d", response.Code())
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
-		assert.Equal(t, textService.RetryCount, 3, "expected multiple retries due to rate limiting")
-
 		result := make(map[string]string)
 		if err := response.UnmarshalBodyToObject(&result); err != nil {
 			t.Fatalf("Failed to unmarshal response: %v", err)
 		}
-
-		assert.Empty(t, result["provider_id"], "expected provider_id to be empty due to service failure")
 		assert.NotEmpty(t, result["message_id"], "expected message_id to be present in response")
+
+		processed, err := w.ProcessBatch(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to process outbox: %v", err)
+		}
+
+		// Even though the service request failed, the message stays queued to be retried later
+		assert.Equal(t, 1, processed, "expected the queued message to be claimed")
+		assert.Equal(t, int64(service.MaxRetries), transport.Requests(), "expected multiple retries due to rate limiting")
 	})
 
-	t.Run("get conversations", func(t *testing.T) {
+	t.Run("rows whose lease ran out during a slow batch are sent once", func(t *testing.T) {
 		cleaner.Acquire(tables...)
 		defer cleaner.Clean(tables...)
 
-		// Send a message to create a conversation
+		for i := 1; i <= 3; i++ {
+			body := server.TextMessage{
+				From:        "+1234567890",
+				To:          server.Recipients{"+0987654321"},
+				Type:        "sms",
+				Body:        fmt.Sprintf("Slow message %d", i),
+				Attachments: []string{},
+				CreatedAt:   fmt.Sprintf("2023-10-01T12:00:0%dZ", i),
+			}
+			response := oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusAccepted {
+				t.Fatalf("Expected status code 202, got %d", response.Code())
+			}
+		}
+
+		// Each send takes longer than half the lease, so the third row's lease runs out
+		// while the first worker is still sending the second.
+		provider := &slowProvider{delay: 400 * time.Millisecond}
+		first := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), provider)
+		first.BatchSize = 3
+		first.Lease = 500 * time.Millisecond
+		second := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), provider)
+		second.Lease = first.Lease
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processed, err := first.ProcessBatch(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 3, processed, "expected the first worker to claim every row")
+		}()
+
+		time.Sleep(600 * time.Millisecond)
+		processed, err := second.ProcessBatch(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, processed, "expected the second worker to claim the expired row")
+		wg.Wait()
+
+		for i := 1; i <= 3; i++ {
+			assert.Equal(t, 1, provider.sent(fmt.Sprintf("Slow message %d", i)), "expected message %d to be sent once", i)
+		}
+		messages := getMessages(t, e)
+		assert.Equal(t, 3, countStatus(messages, repository.MessageStatusSent), "expected every message to be recorded as sent")
+	})
+
+	t.Run("send SMS through HTTP provider", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		var requests int32
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			assert.Equal(t, "/2010-04-01/Accounts/accountSID/Messages.json", r.URL.Path)
+
+			username, password, ok := r.BasicAuth()
+			assert.True(t, ok, "expected basic auth credentials")
+			assert.Equal(t, "accountSID", username)
+			assert.Equal(t, "authToken", password)
+
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "+1234567890", r.PostForm.Get("From"))
+			assert.Equal(t, "+0987654321", r.PostForm.Get("To"))
+			assert.Equal(t, []string{"http://example.com/image.jpg"}, r.PostForm["MediaUrl"])
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"sid":"SM123","status":"queued"}`)
+		}))
+		defer provider.Close()
+
+		transport := service.NewHTTPTransport(provider.URL, time.Second, nil)
+		textService := service.NewTwilioService(transport, "accountSID", "authToken")
+		w := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), textService)
+
 		path := "/api/messages/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
-			Type:        "sms",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "mms",
 			Body:        "Hello, this is a test message.",
-			Attachments: []string{},
-			ProviderID:  "provider123",
+			Attachments: []string{"http://example.com/image.jpg"},
 			CreatedAt:   "2023-10-01T12:00:00Z",
 		}
 
 		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusCreated {
-			t.Fatalf("Expected status code 201, got %d", response.Code())
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
-		path = "/api/conversations"
-		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusOK {
-			t.Fatalf("Expected status code 200, got %d", response.Code())
+		messages := deliverAndGetMessages(t, e, w)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "expected exactly one request to reach the provider")
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.Equal(t, "SM123", messages[0].ProviderID, "expected the Twilio message sid as provider_id")
+	})
+
+	t.Run("send email through HTTP provider", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v3/mail/send", r.URL.Path)
+			assert.Equal(t, "Bearer sendgridKey", r.Header.Get("Authorization"))
+
+			var mail map[string]any
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&mail))
+			assert.Equal(t, map[string]any{"email": "sender@example.com"}, mail["from"])
+			assert.Equal(t, "Your order has shipped", mail["subject"], "expected the subject of the request")
+
+			w.Header().Set("X-Message-Id", "sendgrid-123")
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer provider.Close()
+
+		transport := service.NewHTTPTransport(provider.URL, time.Second, nil)
+		emailService := service.NewSendGridService(transport, "sendgridKey")
+		w := testutils.NewWorker(emailService, service.NewTextService("apiKey", "accountID"))
+
+		path := "/api/messages/email"
+		body := server.EmailMessage{
+			From:        "sender@example.com",
+			To:          server.Recipients{"recipient@example.com"},
+			Subject:     "Your order has shipped",
+			Body:        "Hello, this is a <b>test</b> email.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
 		}
 
-		var conversations []repository.Conversation
-		if err := response.UnmarshalBodyToObject(&conversations); err != nil {
-			t.Fatalf("Failed to unmarshal response: %v", err)
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
-		assert.Len(t, conversations, 1, "Expected one conversation to be returned")
+		messages := deliverAndGetMessages(t, e, w)
+
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.Equal(t, "sendgrid-123", messages[0].ProviderID, "expected the X-Message-Id header as provider_id")
 	})
 
-	t.Run("get conversation by ID", func(t *testing.T) {
+	t.Run("provider error codes are not retried", func(t *testing.T) {
 		cleaner.Acquire(tables...)
 		defer cleaner.Clean(tables...)
 
-		// Send a message to create a conversation
+		var requests int32
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":21211,"message":"The 'To' number is not a valid phone number.","status":400}`)
+		}))
+		defer provider.Close()
+
+		transport := service.NewHTTPTransport(provider.URL, time.Second, nil)
+		textService := service.NewTwilioService(transport, "accountSID", "authToken")
+		w := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), textService)
+
 		path := "/api/messages/sms"
 		body := server.TextMessage{
 			From:        "+1234567890",
-			To:          "+0987654321",
+			To:          server.Recipients{"+0987654321"},
 			Type:        "sms",
 			Body:        "Hello, this is a test message.",
 			Attachments: []string{},
-			ProviderID:  "provider123",
 			CreatedAt:   "2023-10-01T12:00:00Z",
 		}
 
 		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusCreated {
-			t.Fatalf("Expected status code 201, got %d", response.Code())
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
-		// Get the conversation ID from the response
-		path = "/api/conversations"
-		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
-		if response.Code() != http.StatusOK {
-			t.Fatalf("Expected status code 200, got %d", response.Code())
+		messages := deliverAndGetMessages(t, e, w)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "expected a rejected message not to be retried")
+		assert.Len(t, messages, 1, "expected failed messages to remain visible in the conversation")
+		assert.Equal(t, repository.MessageStatusFailed, messages[0].Status)
+		assert.Equal(t, "21211", messages[0].ErrorCode, "expected the Twilio error code to be recorded")
+	})
+
+	t.Run("retry failed message", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		failing := testutils.NewWorker(emailService, service.NewTextServiceWithError("apiKey", "accountID", http.StatusBadRequest, `{"code":21610}`))
+
+		body := server.TextMessage{
+			From:      "+1234567890",
+			To:        server.Recipients{"+0987654321"},
+			Type:      "sms",
+			Body:      "Hello, this is a test message.",
+			CreatedAt: "2023-10-01T12:00:00Z",
+		}
+
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
 		}
 
-		var conversations []repository.Conversation
-		if err := response.UnmarshalBodyToObject(&conversations); err != nil {
+		messages := deliverAndGetMessages(t, e, failing)
+		assert.Len(t, messages, 1, "Expected one message in the conversation")
+		assert.Equal(t, repository.MessageStatusFailed, messages[0].Status)
+
+		path := fmt.Sprintf("/api/messages/%d/retry", messages[0].ID)
+		response = oapi.NewRequest().Post(path).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		messages = deliverAndGetMessages(t, e, w)
+		assert.Len(t, messages, 1, "expected the retry to reuse the original message")
+		assert.Equal(t, repository.MessageStatusSent, messages[0].Status)
+		assert.Empty(t, messages[0].ErrorCode, "expected the error code to be cleared after a successful retry")
+
+		response = oapi.NewRequest().Post(path).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusConflict, response.Code(), "expected sent messages not to be retryable")
+		var conflict map[string]string
+		if err := response.UnmarshalBodyToObject(&conflict); err != nil {
 			t.Fatalf("Failed to unmarshal response: %v", err)
 		}
-		if response.Code() != http.StatusOK {
-			t.Fatalf("Expected status code 200, got %d", response.Code())
+		assert.Equal(t, "only failed or undelivered outbound messages can be retried", conflict["error"])
+	})
+
+	t.Run("group SMS fan-out tracks each recipient", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		var (
+			requests     int32
+			unreachable  atomic.Bool
+			failingPhone = "+15550000002"
+		)
+		unreachable.Store(true)
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			assert.NoError(t, r.ParseForm())
+
+			w.Header().Set("Content-Type", "application/json")
+			if to := r.PostForm.Get("To"); to == failingPhone && unreachable.Load() {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"code":21211,"message":"The 'To' number is not a valid phone number.","status":400}`)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"sid":"SM%s","status":"queued"}`, strings.TrimPrefix(r.PostForm.Get("To"), "+"))
+		}))
+		defer provider.Close()
+
+		transport := service.NewHTTPTransport(provider.URL, time.Second, nil)
+		textService := service.NewTwilioService(transport, "accountSID", "authToken")
+		w := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), textService)
+
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+15550000001", failingPhone},
+			Type:        "sms",
+			Body:        "Hello, everyone.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
 		}
 
-		assert.Len(t, conversations, 1, "Expected one conversation to be returned")
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
 
-		// Get the conversation by ID
-		conversationID := conversations[0].ID
-		path = fmt.Sprintf("/api/conversations/%d/messages", conversationID)
-		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		messages := deliverAndGetMessages(t, e, w)
+		if len(messages) != 1 {
+			t.Fatalf("Expected one message, got %d", len(messages))
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "expected one provider request per recipient")
+		assert.Equal(t, repository.MessageStatusFailed, messages[0].Status, "expected a partial failure to make the message retryable")
+		assert.Equal(t, []repository.Recipient{
+			{To: "+15550000001", Status: repository.MessageStatusSent, ProviderID: "SM15550000001"},
+			{To: failingPhone, Status: repository.MessageStatusFailed, ErrorCode: "21211"},
+		}, messages[0].Recipients)
+
+		unreachable.Store(false)
+		path := fmt.Sprintf("/api/messages/%d/retry", messages[0].ID)
+		response = oapi.NewRequest().Post(path).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		messages = deliverAndGetMessages(t, e, w)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "expected only the failed recipient to be retried")
+		assert.Equal(t, repository.MessageStatusSent, messages[0].Status)
+		for _, recipient := range messages[0].Recipients {
+			assert.Equal(t, repository.MessageStatusSent, recipient.Status, "expected %s to be sent", recipient.To)
+		}
+	})
+
+	t.Run("conversations match the exact participant set", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		recipients := []server.Recipients{
+			{"+0987654321"},
+			{"+0987654321", "+15550000001"},
+			{"+0987654321"},
+			{"+15550000001", "+0987654321"},
+		}
+		for _, to := range recipients {
+			body := server.TextMessage{
+				From:        "+1234567890",
+				To:          to,
+				Type:        "sms",
+				Body:        "Hello, this is a test message via webhook.",
+				Attachments: []string{},
+				CreatedAt:   "2023-10-01T12:00:00Z",
+			}
+			response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/webhooks/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		response := oapi.NewRequest().Get("/api/conversations").GoWithHTTPHandler(t, e)
 		if response.Code() != http.StatusOK {
 			t.Fatalf("Expected status code 200, got %d", response.Code())
 		}
+		var page repository.ConversationPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
 
-		var conversation repository.Conversation
-		if err := response.UnmarshalBodyToObject(&conversation); err != nil {
+		if assert.Len(t, page.Conversations, 2, "expected the group and the one-to-one thread to stay apart") {
+			participants := map[int]int{}
+			for _, conversation := range page.Conversations {
+				participants[len(conversation.Participants)] = conversation.MessageCount
+			}
+			assert.Equal(t, map[int]int{2: 2, 3: 2}, participants)
+		}
+	})
+
+	t.Run("the sender cannot be a recipient", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		text := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321", "+1234567890"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/sms").WithJsonBody(text).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code(), "expected a text to the sender to be rejected")
+
+		email := server.EmailMessage{
+			From:        "sender@example.com",
+			To:          server.Recipients{"sender@example.com"},
+			Body:        "Hello, this is a test email.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+		response = oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/email").WithJsonBody(email).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code(), "expected an email to the sender to be rejected")
+
+		response = oapi.NewRequest().Get("/api/conversations").GoWithHTTPHandler(t, e)
+		var page repository.ConversationPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
 			t.Fatalf("Failed to unmarshal response: %v", err)
 		}
+		assert.Empty(t, page.Conversations, "expected nothing to be stored")
+	})
 
-		assert.NotEmpty(t, conversation.ID, "expected conversation ID to be present")
-		assert.Len(t, conversation.Messages, 1, "Expected one message in the conversation")
+	t.Run("failed sends do not affect later sends", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("Body") == "fail" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"sid":"SM123","status":"queued"}`)
+		}))
+		defer provider.Close()
+
+		transport := service.NewHTTPTransport(provider.URL, time.Second, nil)
+		textService := service.NewTwilioService(transport, "accountSID", "authToken")
+		w := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), textService)
+		w.MaxAttempts = 1
+
+		for _, text := range []string{"fail", "fail", "hello"} {
+			body := server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      text,
+				CreatedAt: "2023-10-01T12:00:00Z",
+			}
+
+			response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusAccepted {
+				t.Fatalf("Expected status code 202, got %d", response.Code())
+			}
+		}
+
+		messages := deliverAndGetMessages(t, e, w)
+
+		assert.Len(t, messages, 3, "Expected three messages in the conversation")
+		assert.Equal(t, repository.MessageStatusFailed, messages[0].Status)
+		assert.Equal(t, repository.MessageStatusFailed, messages[1].Status)
+		assert.Equal(t, repository.MessageStatusSent, messages[2].Status, "expected send to succeed after earlier failures")
+		assert.Equal(t, "SM123", messages[2].ProviderID, "expected send to succeed after earlier failures")
+	})
+
+	t.Run("idempotent SMS sends", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		path := "/api/messages/sms"
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		send := func(body server.TextMessage) *oapi.CompletedRequest {
+			return oapi.NewRequest().
+				WithHeader("Content-Type", "application/json").
+				WithHeader(server.HeaderIdempotencyKey, "send-once").
+				Post(path).
+				WithJsonBody(body).
+				GoWithHTTPHandler(t, e)
+		}
+
+		first := send(body)
+		if first.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", first.Code())
+		}
+
+		retried := send(body)
+		assert.Equal(t, http.StatusAccepted, retried.Code())
+		assert.Equal(t, "true", retried.Recorder.Header().Get(server.HeaderIdempotentReplayed))
+		assert.JSONEq(t, first.Recorder.Body.String(), retried.Recorder.Body.String(), "expected the original response to be replayed")
+
+		body.Body = "A different message."
+		assert.Equal(t, http.StatusUnprocessableEntity, send(body).Code(), "expected a reused key with a different body to be rejected")
+
+		messages := deliverAndGetMessages(t, e, w)
+		assert.Len(t, messages, 1, "expected retries not to create another message")
+	})
+
+	t.Run("abandoned idempotency keys are taken over", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		repo, err := repository.GetRepository()
+		if err != nil {
+			t.Fatalf("Failed to get repository: %v", err)
+		}
+
+		key := repository.IdempotencyKey{
+			Key:         "crashed",
+			Endpoint:    "/api/messages/sms",
+			Fingerprint: "fingerprint",
+			ExpiresAt:   time.Now().Add(time.Hour),
+			LockedUntil: time.Now().Add(time.Minute),
+		}
+		if _, reserved, err := repo.ReserveIdempotencyKey(context.Background(), key); err != nil || !reserved {
+			t.Fatalf("Failed to reserve idempotency key: %v", err)
+		}
+
+		stored, reserved, err := repo.ReserveIdempotencyKey(context.Background(), key)
+		assert.NoError(t, err)
+		assert.False(t, reserved, "expected a key in flight not to be taken over")
+		assert.Equal(t, 0, stored.StatusCode)
+
+		// A request that crashed holding the key left it locked until now.
+		abandoned := key
+		abandoned.Key = "abandoned"
+		abandoned.LockedUntil = time.Now().Add(-time.Second)
+		if _, reserved, err := repo.ReserveIdempotencyKey(context.Background(), abandoned); err != nil || !reserved {
+			t.Fatalf("Failed to reserve idempotency key: %v", err)
+		}
+
+		retry := abandoned
+		retry.LockedUntil = time.Now().Add(time.Minute)
+		_, reserved, err = repo.ReserveIdempotencyKey(context.Background(), retry)
+		assert.NoError(t, err)
+		assert.True(t, reserved, "expected a retry to take over a reservation past its lock")
+
+		other := abandoned
+		other.Owner = "another-key"
+		_, reserved, err = repo.ReserveIdempotencyKey(context.Background(), other)
+		assert.NoError(t, err)
+		assert.True(t, reserved, "expected keys to be scoped to their owner")
+	})
+
+	t.Run("twilio status callbacks update delivery status", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		messages := deliverAndGetMessages(t, e, w)
+		if len(messages) != 1 {
+			t.Fatalf("Expected one message, got %d", len(messages))
+		}
+		providerID := messages[0].ProviderID
+
+		statusCallback := func(status string) int {
+			form := url.Values{"MessageSid": {providerID}, "MessageStatus": {status}}
+			return oapi.NewRequest().
+				WithContentType("application/x-www-form-urlencoded").
+				Post("/api/webhooks/sms/status").
+				WithBody([]byte(form.Encode())).
+				GoWithHTTPHandler(t, e).Code()
+		}
+
+		assert.Equal(t, http.StatusOK, statusCallback("delivered"))
+		assert.Equal(t, http.StatusOK, statusCallback("delivered"), "expected a redelivered callback to be accepted")
+		assert.Equal(t, http.StatusConflict, statusCallback("sent"), "expected a late sent callback to be rejected")
+
+		messages = getMessages(t, e)
+		assert.Equal(t, repository.MessageStatusDelivered, messages[0].Status, "expected delivered not to regress to sent")
+
+		form := url.Values{"MessageSid": {"SMunknown"}, "MessageStatus": {"delivered"}}
+		response = oapi.NewRequest().
+			WithContentType("application/x-www-form-urlencoded").
+			Post("/api/webhooks/sms/status").
+			WithBody([]byte(form.Encode())).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code(), "expected unknown provider IDs to be rejected")
 	})
+
+	t.Run("undelivered messages cannot be read", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		messages := deliverAndGetMessages(t, e, w)
+		if len(messages) != 1 {
+			t.Fatalf("Expected one message, got %d", len(messages))
+		}
+
+		statusCallback := func(status string) int {
+			form := url.Values{"MessageSid": {messages[0].ProviderID}, "MessageStatus": {status}}
+			return oapi.NewRequest().
+				WithContentType("application/x-www-form-urlencoded").
+				Post("/api/webhooks/sms/status").
+				WithBody([]byte(form.Encode())).
+				GoWithHTTPHandler(t, e).Code()
+		}
+
+		assert.Equal(t, http.StatusOK, statusCallback("undelivered"))
+		assert.Equal(t, http.StatusConflict, statusCallback("read"), "expected a read callback to be rejected")
+
+		messages = getMessages(t, e)
+		assert.Equal(t, repository.MessageStatusUndelivered, messages[0].Status, "expected the message to stay undelivered")
+	})
+
+	t.Run("sendgrid events update delivery status", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		body := server.EmailMessage{
+			From:        "sender@example.com",
+			To:          server.Recipients{"recipient@example.com"},
+			Body:        "Hello, this is a test email.",
+			Attachments: []string{},
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/messages/email").WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		messages := deliverAndGetMessages(t, e, w)
+		if len(messages) != 1 {
+			t.Fatalf("Expected one message, got %d", len(messages))
+		}
+		sgMessageID := messages[0].ProviderID + ".filter0001.16648.5515E0B88.0"
+
+		events := []server.SendGridEvent{
+			{Event: "delivered", SGMessageID: sgMessageID},
+			{Event: "processed", SGMessageID: sgMessageID},
+			{Event: "open", SGMessageID: sgMessageID},
+			{Event: "unsubscribe", SGMessageID: sgMessageID},
+		}
+
+		response = oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/webhooks/email/events").WithJsonBody(events).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		result := make(map[string]int)
+		if err := response.UnmarshalBodyToObject(&result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+
+		assert.Equal(t, map[string]int{"applied": 2, "ignored": 1, "rejected": 1}, result)
+
+		messages = getMessages(t, e)
+		assert.Equal(t, repository.MessageStatusRead, messages[0].Status, "expected the open event to mark the email as read")
+	})
+
+	t.Run("concurrent SMS sends", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		const senders = 20
+		var wg sync.WaitGroup
+		codes := make([]int, senders)
+		results := make([]map[string]string, senders)
+
+		for i := 0; i < senders; i++ {
+			payload := mustJSON(t, server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      fmt.Sprintf("Concurrent message %d", i),
+				CreatedAt: "2023-10-01T12:00:00Z",
+			})
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				// t.Fatal must not be called from other goroutines, so results are checked below.
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/api/messages/sms", strings.NewReader(payload))
+				req.Header.Set("Content-Type", "application/json")
+				e.ServeHTTP(rec, req)
+
+				codes[i] = rec.Code
+				results[i] = make(map[string]string)
+				_ = json.Unmarshal(rec.Body.Bytes(), &results[i])
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 0; i < senders; i++ {
+			assert.Equal(t, http.StatusAccepted, codes[i], "unexpected status code for request %d", i)
+			assert.NotEmpty(t, results[i]["message_id"], "expected message_id for request %d", i)
+		}
+
+		// Deliver with several workers racing for the same outbox rows.
+		ctx, cancel := context.WithCancel(context.Background())
+		workers := testutils.NewWorker(emailService, textService)
+		workers.Concurrency = 4
+		workers.PollInterval = 10 * time.Millisecond
+		done := make(chan struct{})
+		go func() {
+			workers.Run(ctx)
+			close(done)
+		}()
+
+		var messages []repository.Message
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			messages = getMessages(t, e)
+			if countStatus(messages, repository.MessageStatusSent) == senders {
+				break
+			}
+		}
+		cancel()
+		<-done
+
+		assert.Len(t, messages, senders, "expected every concurrent send to be stored in a single conversation")
+		assert.Equal(t, senders, countStatus(messages, repository.MessageStatusSent), "expected every concurrent send to be delivered")
+		for _, msg := range messages {
+			assert.NotEmpty(t, msg.ProviderID, "expected provider_id for message %d", msg.ID)
+		}
+	})
+
+	t.Run("get conversations", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		// Send a message to create a conversation
+		path := "/api/messages/sms"
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message.",
+			Attachments: []string{},
+			ProviderID:  "provider123",
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		deliverQueued(t, w)
+
+		path = "/api/conversations"
+		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var page repository.ConversationPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		conversations := page.Conversations
+
+		assert.Len(t, conversations, 1, "Expected one conversation to be returned")
+	})
+
+	t.Run("paginate conversations", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		// Two conversations share a timestamp to check ties are broken by ID.
+		timestamps := map[string]string{
+			"+10000000001": "2023-10-01T12:00:00Z",
+			"+10000000002": "2023-10-02T12:00:00Z",
+			"+10000000003": "2023-10-02T12:00:00Z",
+		}
+		for from, timestamp := range timestamps {
+			body := server.TextMessage{
+				From:        from,
+				To:          server.Recipients{"+0987654321"},
+				Type:        "sms",
+				Body:        "Hello, this is a test message via webhook.",
+				Attachments: []string{},
+				CreatedAt:   timestamp,
+			}
+			response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/webhooks/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		getPage := func(path string) repository.ConversationPage {
+			response := oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.ConversationPage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page
+		}
+
+		first := getPage("/api/conversations?limit=2")
+		assert.Len(t, first.Conversations, 2)
+		assert.NotEmpty(t, first.NextCursor, "expected a cursor for the next page")
+
+		second := getPage("/api/conversations?limit=2&cursor=" + first.NextCursor)
+		assert.Len(t, second.Conversations, 1)
+		assert.Empty(t, second.NextCursor, "expected no cursor on the last page")
+
+		conversations := append(first.Conversations, second.Conversations...)
+		assert.Equal(t, "2023-10-02T12:00:00Z", conversations[0].LastActivityAt)
+		assert.Greater(t, conversations[0].ID, conversations[1].ID, "expected ties to be ordered by ID")
+		assert.Equal(t, "2023-10-01T12:00:00Z", conversations[2].LastActivityAt, "expected the least recently active conversation last")
+
+		response := oapi.NewRequest().Get("/api/conversations?cursor=not-a-cursor").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("conversation list previews the last message", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		messages := []server.TextMessage{
+			{From: "+1234567890", Body: "Latest reply", CreatedAt: "2023-10-03T12:00:00Z"},
+			{From: "+1234567890", Body: "Delivered late", CreatedAt: "2023-10-02T12:00:00Z"},
+			{From: "+1555555555", Body: "Older thread", CreatedAt: "2023-10-01T12:00:00Z"},
+		}
+		for _, body := range messages {
+			body.To = server.Recipients{"+0987654321"}
+			body.Type = "sms"
+			body.Attachments = []string{}
+			response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/webhooks/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		response := oapi.NewRequest().Get("/api/conversations").GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+		var page repository.ConversationPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(page.Conversations) != 2 {
+			t.Fatalf("Expected two conversations, got %d", len(page.Conversations))
+		}
+
+		latest := page.Conversations[0]
+		assert.Equal(t, 2, latest.MessageCount)
+		assert.Equal(t, "2023-10-03T12:00:00Z", latest.LastActivityAt)
+		if assert.NotNil(t, latest.LastMessage) {
+			assert.Equal(t, "Latest reply", latest.LastMessage.Snippet, "expected a late delivery not to replace the last message")
+			assert.Equal(t, "+1234567890", latest.LastMessage.From)
+			assert.Equal(t, "sms", latest.LastMessage.Type)
+			assert.Equal(t, repository.MessageStatusReceived, latest.LastMessage.Status)
+		}
+
+		assert.Equal(t, 1, page.Conversations[1].MessageCount)
+	})
+
+	t.Run("paginate conversation messages", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		timestamps := []string{
+			"2023-10-01T12:00:00Z",
+			"2023-10-01T12:01:00Z",
+			"2023-10-01T12:02:00Z",
+			"2023-10-01T12:02:00Z",
+			"2023-10-01T12:03:00Z",
+		}
+		for i, timestamp := range timestamps {
+			body := server.TextMessage{
+				From:        "+1234567890",
+				To:          server.Recipients{"+0987654321"},
+				Type:        "sms",
+				Body:        fmt.Sprintf("Message %d", i),
+				Attachments: []string{},
+				CreatedAt:   timestamp,
+			}
+			response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post("/api/webhooks/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		all := getMessages(t, e)
+		if len(all) != len(timestamps) {
+			t.Fatalf("Expected %d messages, got %d", len(timestamps), len(all))
+		}
+
+		conversationPath := fmt.Sprintf("/api/conversations/%d/messages", getConversationID(t, e))
+		getPage := func(query string) repository.MessagePage {
+			response := oapi.NewRequest().Get(conversationPath+"?"+query).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.MessagePage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page
+		}
+		bodies := func(page repository.MessagePage) []string {
+			result := make([]string, 0, len(page.Messages))
+			for _, msg := range page.Messages {
+				result = append(result, msg.Body)
+			}
+			return result
+		}
+
+		newest := getPage("order=desc&limit=2")
+		assert.Equal(t, []string{"Message 4", "Message 3"}, bodies(newest))
+		assert.True(t, newest.HasMore)
+
+		older := getPage(fmt.Sprintf("order=desc&limit=2&before=%d", newest.Messages[1].ID))
+		assert.Equal(t, []string{"Message 2", "Message 1"}, bodies(older), "expected ties to be paged by message ID")
+		assert.True(t, older.HasMore)
+
+		oldest := getPage(fmt.Sprintf("order=desc&limit=2&before=%d", older.Messages[1].ID))
+		assert.Equal(t, []string{"Message 0"}, bodies(oldest))
+		assert.False(t, oldest.HasMore)
+
+		newer := getPage(fmt.Sprintf("limit=3&after=%d", all[0].ID))
+		assert.Equal(t, []string{"Message 1", "Message 2", "Message 3"}, bodies(newer))
+		assert.True(t, newer.HasMore)
+
+		response := oapi.NewRequest().Get(conversationPath+"?before=1&after=2").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("filter conversation messages by direction", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		inbound := server.TextMessage{
+			From:      "+1234567890",
+			To:        server.Recipients{"+0987654321"},
+			Type:      "sms",
+			Body:      "Is my order on its way?",
+			CreatedAt: "2023-10-01T12:00:00Z",
+		}
+		response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(inbound).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", response.Code())
+		}
+
+		reply := server.TextMessage{
+			From:      "+0987654321",
+			To:        server.Recipients{"+1234567890"},
+			Type:      "sms",
+			Body:      "It ships tomorrow.",
+			CreatedAt: "2023-10-01T12:05:00Z",
+		}
+		response = oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(reply).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		messages := getMessages(t, e)
+		if assert.Len(t, messages, 2) {
+			assert.Equal(t, repository.DirectionInbound, messages[0].Direction)
+			assert.Equal(t, repository.DirectionOutbound, messages[1].Direction)
+		}
+
+		conversationPath := fmt.Sprintf("/api/conversations/%d/messages", getConversationID(t, e))
+		for direction, body := range map[string]string{
+			repository.DirectionInbound:  inbound.Body,
+			repository.DirectionOutbound: reply.Body,
+		} {
+			response = oapi.NewRequest().Get(conversationPath+"?direction="+direction).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+
+			var page repository.MessagePage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if assert.Len(t, page.Messages, 1) {
+				assert.Equal(t, body, page.Messages[0].Body)
+			}
+		}
+
+		response = oapi.NewRequest().Get(conversationPath+"?direction=sideways").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("read receipts and unread counts", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		for i := range 3 {
+			body := server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      fmt.Sprintf("Question %d", i),
+				CreatedAt: fmt.Sprintf("2023-10-01T12:0%d:00Z", i),
+			}
+			response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+		reply := server.TextMessage{
+			From:      "+0987654321",
+			To:        server.Recipients{"+1234567890"},
+			Type:      "sms",
+			Body:      "Answer",
+			CreatedAt: "2023-10-01T12:05:00Z",
+		}
+		response := oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(reply).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		unreadCount := func(userID string) int {
+			response := oapi.NewRequest().Get("/api/conversations").WithHeader(server.HeaderUserID, userID).GoWithHTTPHandler(t, e)
+			var page repository.ConversationPage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if len(page.Conversations) != 1 || page.Conversations[0].UnreadCount == nil {
+				t.Fatalf("Expected one conversation with an unread count, got %+v", page.Conversations)
+			}
+			return *page.Conversations[0].UnreadCount
+		}
+		markRead := func(body string) repository.ReadReceipt {
+			path := fmt.Sprintf("/api/conversations/%d/read", getConversationID(t, e))
+			response := oapi.NewRequest().Post(path).WithHeader(server.HeaderUserID, "agent-1").
+				WithJsonContentType().WithBody([]byte(body)).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var receipt repository.ReadReceipt
+			if err := response.UnmarshalBodyToObject(&receipt); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return receipt
+		}
+
+		assert.Equal(t, 3, unreadCount("agent-1"), "expected only inbound messages to be unread")
+
+		messages := getMessages(t, e)
+		receipt := markRead(fmt.Sprintf(`{"message_id": %d}`, messages[1].ID))
+		assert.Equal(t, 1, receipt.UnreadCount)
+		assert.Equal(t, 1, unreadCount("agent-1"))
+		assert.Equal(t, 3, unreadCount("agent-2"), "expected read state to be per user")
+
+		response = oapi.NewRequest().Get("/api/conversations/unread").WithHeader(server.HeaderUserID, "agent-1").GoWithHTTPHandler(t, e)
+		var summary repository.UnreadSummary
+		if err := response.UnmarshalBodyToObject(&summary); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, repository.UnreadSummary{UnreadCount: 1, UnreadConversations: 1}, summary)
+
+		response = oapi.NewRequest().Post(fmt.Sprintf("/api/conversations/%d/close", getConversationID(t, e))).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusOK, response.Code())
+		response = oapi.NewRequest().Get("/api/conversations/unread").WithHeader(server.HeaderUserID, "agent-2").GoWithHTTPHandler(t, e)
+		summary = repository.UnreadSummary{}
+		if err := response.UnmarshalBodyToObject(&summary); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, repository.UnreadSummary{}, summary, "expected closed conversations not to count")
+
+		receipt = markRead("")
+		assert.Equal(t, 0, receipt.UnreadCount)
+		receipt = markRead(fmt.Sprintf(`{"message_id": %d}`, messages[0].ID))
+		assert.Equal(t, 0, receipt.UnreadCount, "expected the read cursor not to move backwards")
+		receipt = markRead(fmt.Sprintf(`{"message_id": %d}`, messages[2].ID))
+		if assert.NotNil(t, receipt.LastReadMessageID) {
+			assert.Equal(t, messages[3].ID, *receipt.LastReadMessageID,
+				"expected the read cursor not to move back to an earlier message with the same inbound count")
+		}
+
+		response = oapi.NewRequest().Get("/api/conversations/unread").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("filter and sort conversations", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		failing := testutils.NewWorker(emailService, service.NewTextServiceWithError("apiKey", "accountID", http.StatusBadRequest, `{"code":21610}`))
+
+		inbound := []struct {
+			path string
+			body any
+		}{
+			{"/api/webhooks/sms", server.TextMessage{
+				From: "+15550001111", To: server.Recipients{"+15559999999"},
+				Type: "sms", Body: "First", CreatedAt: "2023-10-01T12:10:00Z",
+			}},
+			{"/api/webhooks/email", server.EmailMessage{
+				From: "customer@example.com", To: server.Recipients{"support@example.com"},
+				Body: "Second", CreatedAt: "2023-10-01T12:00:00Z",
+			}},
+		}
+		for _, msg := range inbound {
+			response := oapi.NewRequest().Post(msg.path).WithJsonBody(msg.body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+		outbound := server.TextMessage{
+			From: "+15559999999", To: server.Recipients{"+16660002222"},
+			Type: "sms", Body: "Third", CreatedAt: "2023-10-01T12:05:00Z",
+		}
+		response := oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(outbound).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+		deliverQueued(t, failing)
+
+		list := func(query string) []string {
+			response := oapi.NewRequest().Get("/api/conversations?"+query).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.ConversationPage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			bodies := make([]string, 0, len(page.Conversations))
+			for _, conv := range page.Conversations {
+				bodies = append(bodies, conv.LastMessage.Snippet)
+			}
+			return bodies
+		}
+
+		assert.Equal(t, []string{"First", "Third", "Second"}, list(""))
+		assert.Equal(t, []string{"Second", "Third", "First"}, list("order=asc"))
+		assert.Equal(t, []string{"First", "Second", "Third"}, list("sort=created_at&order=asc"))
+		assert.Equal(t, []string{"First"}, list("participant=%2B15550001111"))
+		assert.Equal(t, []string{"First", "Third"}, list("participant_prefix=%2B1555"))
+		assert.Equal(t, []string{"Second"}, list("communication_type=email"))
+		assert.Equal(t, []string{"Third", "Second"}, list("to=2023-10-01T12:10:00Z"))
+		assert.Equal(t, []string{"Third"}, list("has_failed=true"))
+		assert.Equal(t, []string{"First", "Second"}, list("has_failed=false"))
+
+		page := oapi.NewRequest().Get("/api/conversations?communication_type=email").GoWithHTTPHandler(t, e)
+		var emails repository.ConversationPage
+		if err := page.UnmarshalBodyToObject(&emails); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		response = oapi.NewRequest().Post(fmt.Sprintf("/api/conversations/%d/archive", emails.Conversations[0].ID)).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusOK, response.Code())
+		assert.Equal(t, []string{"Second"}, list("archived=true"))
+		assert.Equal(t, []string{"First", "Third"}, list("archived=false"))
+
+		response = oapi.NewRequest().Get("/api/conversations?sort=subject").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("conversation status workflow", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		inbound := func(body, createdAt string) {
+			msg := server.TextMessage{
+				From: "+15550001111", To: server.Recipients{"+15559999999"},
+				Type: "sms", Body: body, CreatedAt: createdAt,
+			}
+			response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(msg).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+		list := func(query string) []repository.Conversation {
+			response := oapi.NewRequest().Get("/api/conversations?"+query).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.ConversationPage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page.Conversations
+		}
+
+		inbound("Hello", "2023-10-01T12:00:00Z")
+		open := list("")
+		if !assert.Len(t, open, 1) {
+			return
+		}
+		assert.Equal(t, repository.ConversationStatusOpen, open[0].Status)
+		path := fmt.Sprintf("/api/conversations/%d", open[0].ID)
+
+		transition := func(action string, body any, expectedCode int) repository.Conversation {
+			request := oapi.NewRequest().Post(path + "/" + action)
+			if body != nil {
+				request = request.WithJsonBody(body)
+			}
+			response := request.GoWithHTTPHandler(t, e)
+			assert.Equal(t, expectedCode, response.Code(), "unexpected status code for %s", action)
+			var conversation repository.Conversation
+			if expectedCode == http.StatusOK {
+				if err := response.UnmarshalBodyToObject(&conversation); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+			}
+			return conversation
+		}
+
+		closed := transition("close", nil, http.StatusOK)
+		assert.Equal(t, repository.ConversationStatusClosed, closed.Status)
+		assert.Empty(t, list(""), "expected the list to default to open conversations")
+		assert.Len(t, list("status=closed"), 1)
+		assert.Len(t, list("status=all"), 1)
+
+		transition("archive", nil, http.StatusOK)
+		transition("close", nil, http.StatusConflict)
+		assert.Len(t, list("archived=true"), 1)
+		transition("reopen", nil, http.StatusOK)
+
+		transition("snooze", server.SnoozeInput{Until: "2023-10-01T12:00:00Z"}, http.StatusUnprocessableEntity)
+		until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		snoozed := transition("snooze", server.SnoozeInput{Until: until}, http.StatusOK)
+		assert.Equal(t, repository.ConversationStatusSnoozed, snoozed.Status)
+		assert.Equal(t, until, snoozed.SnoozedUntil)
+		assert.Empty(t, list(""))
+		assert.Len(t, list("status=open,snoozed"), 1)
+
+		inbound("Are you there?", "2023-10-01T12:05:00Z")
+		open = list("")
+		if assert.Len(t, open, 1, "expected an inbound message to reopen the conversation") {
+			assert.Equal(t, repository.ConversationStatusOpen, open[0].Status)
+			assert.Empty(t, open[0].SnoozedUntil)
+		}
+
+		response := oapi.NewRequest().Post("/api/conversations/999999/close").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code())
+		response = oapi.NewRequest().Get("/api/conversations?status=pending").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("assign conversations to agents", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		createAgent := func(input server.AgentInput, expectedCode int) repository.Agent {
+			response := oapi.NewRequest().Post("/api/agents").WithJsonBody(input).GoWithHTTPHandler(t, e)
+			if response.Code() != expectedCode {
+				t.Fatalf("Expected status code %d, got %d", expectedCode, response.Code())
+			}
+			var agent repository.Agent
+			if expectedCode == http.StatusCreated {
+				if err := response.UnmarshalBodyToObject(&agent); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+			}
+			return agent
+		}
+		alice := createAgent(server.AgentInput{Name: "Alice", Email: "alice@example.com", Available: true}, http.StatusCreated)
+		bob := createAgent(server.AgentInput{Name: "Bob", Email: "bob@example.com", Available: true}, http.StatusCreated)
+		carol := createAgent(server.AgentInput{Name: "Carol", Email: "carol@example.com"}, http.StatusCreated)
+		createAgent(server.AgentInput{Name: "Alice Again", Email: "alice@example.com"}, http.StatusConflict)
+
+		for i, from := range []string{"+15550000001", "+15550000002", "+15550000003"} {
+			msg := server.TextMessage{
+				From: from, To: server.Recipients{"+15559999999"},
+				Type: "sms", Body: "Hello", CreatedAt: fmt.Sprintf("2023-10-01T12:0%d:00Z", i),
+			}
+			response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(msg).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		list := func(query string) []repository.Conversation {
+			response := oapi.NewRequest().Get("/api/conversations?"+query).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.ConversationPage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page.Conversations
+		}
+
+		assigned := list("sort=created_at&order=asc")
+		if !assert.Len(t, assigned, 3) {
+			return
+		}
+		for i, agent := range []repository.Agent{alice, bob, alice} {
+			if assert.NotNil(t, assigned[i].AssigneeID, "expected new inbound conversations to be assigned") {
+				assert.Equal(t, agent.ID, *assigned[i].AssigneeID, "expected round-robin among available agents")
+			}
+		}
+		assert.Len(t, list(fmt.Sprintf("assignee=%d", alice.ID)), 2)
+		assert.Empty(t, list(fmt.Sprintf("assignee=%d", carol.ID)))
+		assert.Empty(t, list("assignee=none"))
+
+		path := fmt.Sprintf("/api/conversations/%d/assignee", assigned[0].ID)
+		response := oapi.NewRequest().Put(path).WithJsonBody(map[string]any{"agent_id": nil}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusOK, response.Code())
+		assert.Len(t, list("assignee=none"), 1)
+
+		response = oapi.NewRequest().Put(path).
+			WithHeader(server.HeaderUserID, "lead-1").
+			WithJsonBody(server.AssigneeInput{AgentID: &carol.ID}).
+			GoWithHTTPHandler(t, e)
+		var conversation repository.Conversation
+		if err := response.UnmarshalBodyToObject(&conversation); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.NotNil(t, conversation.AssigneeID) {
+			assert.Equal(t, carol.ID, *conversation.AssigneeID, "expected unavailable agents to be assignable by hand")
+		}
+
+		missing := int64(999999)
+		response = oapi.NewRequest().Put(path).WithJsonBody(server.AssigneeInput{AgentID: &missing}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code())
+
+		response = oapi.NewRequest().Get(fmt.Sprintf("/api/conversations/%d/assignments", assigned[0].ID)).GoWithHTTPHandler(t, e)
+		var history []repository.Assignment
+		if err := response.UnmarshalBodyToObject(&history); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, history, 3) {
+			assert.Equal(t, &alice.ID, history[0].AgentID)
+			assert.Empty(t, history[0].AssignedBy, "expected automatic assignments to have no user")
+			assert.Nil(t, history[1].AgentID)
+			assert.Equal(t, &carol.ID, history[2].AgentID)
+			assert.Equal(t, "lead-1", history[2].AssignedBy)
+		}
+
+		update := server.AgentInput{Name: "Bob", Email: "bob@example.com", Available: false}
+		response = oapi.NewRequest().Put(fmt.Sprintf("/api/agents/%d", bob.ID)).WithJsonBody(update).GoWithHTTPHandler(t, e)
+		var updated repository.Agent
+		if err := response.UnmarshalBodyToObject(&updated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.False(t, updated.Available)
+
+		response = oapi.NewRequest().Get("/api/conversations?assignee=someone").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("internal notes on conversations", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		inbound := func(body string, createdAt time.Time) {
+			msg := server.TextMessage{
+				From: "+1234567890", To: server.Recipients{"+0987654321"},
+				Type: "sms", Body: body, CreatedAt: createdAt.UTC().Format("2006-01-02T15:04:05Z"),
+			}
+			response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(msg).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		inbound("I'd like a refund", time.Now().Add(-time.Hour))
+		conversationID := getConversationID(t, e)
+		notesPath := fmt.Sprintf("/api/conversations/%d/notes", conversationID)
+
+		response := oapi.NewRequest().Post(notesPath).
+			WithHeader(server.HeaderUserID, "agent-1").
+			WithJsonBody(server.NoteInput{Body: "Customer asked for refund, waiting on finance"}).
+			GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", response.Code())
+		}
+		var note repository.Note
+		if err := response.UnmarshalBodyToObject(&note); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, "agent-1", note.Author)
+
+		inbound("Any news?", time.Now().Add(time.Minute))
+
+		getPage := func(query string) repository.MessagePage {
+			path := fmt.Sprintf("/api/conversations/%d/messages?%s", conversationID, query)
+			response := oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.MessagePage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page
+		}
+		kinds := func(page repository.MessagePage) []string {
+			result := make([]string, 0, len(page.Items))
+			for _, item := range page.Items {
+				result = append(result, item.Kind)
+			}
+			return result
+		}
+
+		page := getPage("")
+		assert.Len(t, page.Messages, 2, "expected notes not to be listed as messages")
+		assert.Equal(t, []string{repository.ItemKindMessage, repository.ItemKindNote, repository.ItemKindMessage}, kinds(page))
+		if assert.Len(t, page.Items, 3) && assert.NotNil(t, page.Items[1].Note) {
+			assert.Equal(t, note.Body, page.Items[1].Note.Body)
+			assert.Nil(t, page.Items[1].Message)
+		}
+
+		newest := getPage("order=desc&limit=1")
+		assert.Equal(t, []string{repository.ItemKindMessage}, kinds(newest))
+		older := getPage(fmt.Sprintf("order=desc&limit=1&before=%d", newest.Messages[0].ID))
+		assert.Equal(t, []string{repository.ItemKindNote, repository.ItemKindMessage}, kinds(older), "expected each note on exactly one page")
+
+		assert.Equal(t, []string{repository.ItemKindMessage, repository.ItemKindMessage}, kinds(getPage("direction=inbound")))
+
+		notePath := fmt.Sprintf("/api/notes/%d", note.ID)
+		response = oapi.NewRequest().Put(notePath).WithJsonBody(server.NoteInput{Body: "Refund approved"}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusOK, response.Code())
+
+		response = oapi.NewRequest().Get(notesPath).GoWithHTTPHandler(t, e)
+		var notes []repository.Note
+		if err := response.UnmarshalBodyToObject(&notes); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, notes, 1) {
+			assert.Equal(t, "Refund approved", notes[0].Body)
+		}
+
+		response = oapi.NewRequest().Delete(notePath).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNoContent, response.Code())
+		assert.Len(t, getPage("").Items, 2)
+
+		response = oapi.NewRequest().Post(notesPath).WithJsonBody(server.NoteInput{Body: "Anonymous"}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+		response = oapi.NewRequest().Post(notesPath).WithHeader(server.HeaderUserID, "agent-1").WithJsonBody(server.NoteInput{}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code())
+		response = oapi.NewRequest().Post("/api/conversations/999999/notes").
+			WithHeader(server.HeaderUserID, "agent-1").
+			WithJsonBody(server.NoteInput{Body: "Lost"}).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code())
+	})
+
+	t.Run("tag conversations and messages", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		inbound := []struct {
+			path string
+			body any
+		}{
+			{"/api/webhooks/sms", server.TextMessage{
+				From: "+15550001111", To: server.Recipients{"+15559999999"},
+				Type: "sms", Body: "I was charged twice, refund please", CreatedAt: "2023-10-01T12:00:00Z",
+			}},
+			{"/api/webhooks/email", server.EmailMessage{
+				From: "customer@example.com", To: server.Recipients{"support@example.com"},
+				Body: "My parcel is damaged, refund please", CreatedAt: "2023-10-01T12:05:00Z",
+			}},
+		}
+		for _, msg := range inbound {
+			response := oapi.NewRequest().Post(msg.path).WithJsonBody(msg.body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+		list := func(query string) []repository.Conversation {
+			response := oapi.NewRequest().Get("/api/conversations?"+query).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var page repository.ConversationPage
+			if err := response.UnmarshalBodyToObject(&page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return page.Conversations
+		}
+		conversations := list("sort=created_at&order=asc")
+		if len(conversations) != 2 {
+			t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+		}
+		sms, email := conversations[0], conversations[1]
+
+		response := oapi.NewRequest().Post("/api/tags").WithJsonBody(server.TagInput{Name: "Billing"}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusCreated, response.Code())
+		response = oapi.NewRequest().Post("/api/tags").WithJsonBody(server.TagInput{Name: "billing"}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusConflict, response.Code(), "expected tag names to be unique regardless of case")
+
+		tag := func(path, name string) []repository.Tag {
+			response := oapi.NewRequest().Post(path).WithJsonBody(server.TagInput{Name: name}).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var tags []repository.Tag
+			if err := response.UnmarshalBodyToObject(&tags); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return tags
+		}
+		smsTagsPath := fmt.Sprintf("/api/conversations/%d/tags", sms.ID)
+		tag(smsTagsPath, "billing")
+		tags := tag(smsTagsPath, "Urgent")
+		if assert.Len(t, tags, 2) {
+			assert.Equal(t, "Billing", tags[0].Name, "expected an existing tag to be reused")
+			assert.Equal(t, "Urgent", tags[1].Name, "expected a new tag to be created")
+		}
+		assert.Len(t, tag(smsTagsPath, "urgent"), 2, "expected tagging twice to be a no-op")
+		tag(fmt.Sprintf("/api/messages/%d/tags", email.LastMessage.ID), "Damaged")
+
+		tagged := list("tag=billing")
+		if assert.Len(t, tagged, 1) {
+			assert.Equal(t, []string{"Billing", "Urgent"}, tagged[0].Tags)
+		}
+		assert.Len(t, list("tag=billing&tag=urgent"), 1)
+		assert.Empty(t, list("tag=billing&tag=damaged"))
+
+		page := searchMessages(t, e, url.Values{"q": {"refund"}, "tag": {"Billing"}})
+		if assert.Len(t, page.Results, 1, "expected conversation tags to apply to their messages") {
+			assert.Equal(t, sms.LastMessage.ID, page.Results[0].ID)
+		}
+		page = searchMessages(t, e, url.Values{"q": {"refund"}, "tag": {"damaged"}})
+		if assert.Len(t, page.Results, 1) {
+			assert.Equal(t, email.LastMessage.ID, page.Results[0].ID)
+		}
+
+		response = oapi.NewRequest().Get("/api/tags").GoWithHTTPHandler(t, e)
+		var counts []repository.TagCount
+		if err := response.UnmarshalBodyToObject(&counts); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, counts, 3) {
+			assert.Equal(t, "Billing", counts[0].Name)
+			assert.Equal(t, 1, counts[0].ConversationCount)
+			assert.Equal(t, "Damaged", counts[1].Name)
+			assert.Equal(t, 1, counts[1].MessageCount)
+		}
+
+		untagPath := fmt.Sprintf("%s/%d", smsTagsPath, counts[0].ID)
+		response = oapi.NewRequest().Delete(untagPath).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNoContent, response.Code())
+		response = oapi.NewRequest().Delete(untagPath).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code())
+		assert.Empty(t, list("tag=billing"))
+
+		response = oapi.NewRequest().Post("/api/conversations/999999/tags").WithJsonBody(server.TagInput{Name: "Lost"}).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code())
+	})
+
+	t.Run("get, update and delete a message", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		for i, timestamp := range []string{"2023-10-01T12:00:00Z", "2023-10-01T12:05:00Z"} {
+			body := server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      fmt.Sprintf("Message %d", i),
+				CreatedAt: timestamp,
+			}
+			response := oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusAccepted {
+				t.Fatalf("Expected status code 202, got %d", response.Code())
+			}
+		}
+		messages := deliverAndGetMessages(t, e, w)
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(messages))
+		}
+
+		path := fmt.Sprintf("/api/messages/%d", messages[1].ID)
+		getMessage := func() repository.MessageDetail {
+			response := oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+			var detail repository.MessageDetail
+			if err := response.UnmarshalBodyToObject(&detail); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return detail
+		}
+
+		detail := getMessage()
+		assert.Equal(t, "Message 1", detail.Body)
+		statuses := make([]string, 0, len(detail.History))
+		for _, change := range detail.History {
+			statuses = append(statuses, change.Status)
+		}
+		assert.Equal(t, []string{repository.MessageStatusQueued, repository.MessageStatusSending, repository.MessageStatusSent}, statuses)
+
+		for _, patch := range []string{
+			`{"metadata": {"tags": ["vip"], "note": "call back", "crm": {"id": 7, "owner": null}}}`,
+			`{"metadata": {"note": null}}`,
+		} {
+			response := oapi.NewRequest().Patch(path).WithJsonContentType().WithBody([]byte(patch)).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", response.Code())
+			}
+		}
+		assert.Equal(t, map[string]any{"tags": []any{"vip"}, "crm": map[string]any{"id": float64(7), "owner": nil}}, getMessage().Metadata,
+			"expected only top-level nulls to remove keys")
+
+		response := oapi.NewRequest().Delete(path).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNoContent, response.Code())
+
+		remaining := getMessages(t, e)
+		if assert.Len(t, remaining, 1, "expected deleted messages to be hidden from the conversation") {
+			assert.Equal(t, "Message 0", remaining[0].Body)
+		}
+
+		response = oapi.NewRequest().Get("/api/conversations").GoWithHTTPHandler(t, e)
+		var page repository.ConversationPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if assert.Len(t, page.Conversations, 1) {
+			assert.Equal(t, 1, page.Conversations[0].MessageCount)
+			assert.Equal(t, "Message 0", page.Conversations[0].LastMessage.Snippet)
+		}
+
+		assert.NotEmpty(t, getMessage().DeletedAt, "expected deleted messages to be kept for audit")
+	})
+
+	t.Run("deleted queued messages are not sent", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		body := server.TextMessage{
+			From:      "+1234567890",
+			To:        server.Recipients{"+0987654321"},
+			Type:      "sms",
+			Body:      "Sent by mistake",
+			CreatedAt: "2023-10-01T12:00:00Z",
+		}
+		response := oapi.NewRequest().Post("/api/messages/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+		var queued map[string]string
+		if err := response.UnmarshalBodyToObject(&queued); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		path := "/api/messages/" + queued["message_id"]
+
+		response = oapi.NewRequest().Delete(path).GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNoContent, response.Code())
+
+		provider := &slowProvider{}
+		processed, err := testutils.NewWorker(service.NewEmailService("apiKey", "accountID"), provider).ProcessBatch(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, processed, "expected no deliveries to be left")
+		assert.Equal(t, 0, provider.sent("Sent by mistake"), "expected the deleted message not to be sent")
+
+		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		var detail repository.MessageDetail
+		if err := response.UnmarshalBodyToObject(&detail); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		assert.Equal(t, repository.MessageStatusFailed, detail.Status)
+
+		response = oapi.NewRequest().Post(path+"/retry").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusConflict, response.Code(), "expected deleted messages not to be retried")
+	})
+
+	t.Run("deleted inbound messages are not unread", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		receive := func(i int) {
+			body := server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      fmt.Sprintf("Question %d", i),
+				CreatedAt: fmt.Sprintf("2023-10-01T12:0%d:00Z", i),
+			}
+			response := oapi.NewRequest().Post("/api/webhooks/sms").WithJsonBody(body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+		unreadCount := func(userID string) int {
+			response := oapi.NewRequest().Get("/api/conversations/unread").WithHeader(server.HeaderUserID, userID).GoWithHTTPHandler(t, e)
+			var summary repository.UnreadSummary
+			if err := response.UnmarshalBodyToObject(&summary); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return summary.UnreadCount
+		}
+		deleteMessage := func(id int64) {
+			response := oapi.NewRequest().Delete(fmt.Sprintf("/api/messages/%d", id)).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusNoContent {
+				t.Fatalf("Expected status code 204, got %d", response.Code())
+			}
+		}
+
+		for i := range 3 {
+			receive(i)
+		}
+		messages := getMessages(t, e)
+		path := fmt.Sprintf("/api/conversations/%d/read", getConversationID(t, e))
+		response := oapi.NewRequest().Post(path).WithHeader(server.HeaderUserID, "agent-1").
+			WithJsonBody(map[string]int64{"message_id": messages[1].ID}).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+		assert.Equal(t, 1, unreadCount("agent-1"))
+
+		deleteMessage(messages[2].ID)
+		assert.Equal(t, 0, unreadCount("agent-1"), "expected a deleted unread message not to be unread")
+		assert.Equal(t, 2, unreadCount("agent-2"))
+
+		deleteMessage(messages[0].ID)
+		assert.Equal(t, 0, unreadCount("agent-1"), "expected deleting a read message not to change the unread count")
+		assert.Equal(t, 1, unreadCount("agent-2"))
+
+		receive(5)
+		assert.Equal(t, 1, unreadCount("agent-1"), "expected new messages to be unread after a read message was deleted")
+	})
+
+	t.Run("get conversation by ID", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		// Send a message to create a conversation
+		path := "/api/messages/sms"
+		body := server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a test message.",
+			Attachments: []string{},
+			ProviderID:  "provider123",
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}
+
+		response := oapi.NewRequest().WithHeader("Content-Type", "application/json").Post(path).WithJsonBody(body).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusAccepted {
+			t.Fatalf("Expected status code 202, got %d", response.Code())
+		}
+
+		deliverQueued(t, w)
+
+		// Get the conversation ID from the response
+		path = "/api/conversations"
+		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var page repository.ConversationPage
+		if err := response.UnmarshalBodyToObject(&page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		conversations := page.Conversations
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		assert.Len(t, conversations, 1, "Expected one conversation to be returned")
+
+		// Get the conversation by ID
+		conversationID := conversations[0].ID
+		path = fmt.Sprintf("/api/conversations/%d/messages", conversationID)
+		response = oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", response.Code())
+		}
+
+		var conversation repository.Conversation
+		if err := response.UnmarshalBodyToObject(&conversation); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+
+		assert.NotEmpty(t, conversation.ID, "expected conversation ID to be present")
+		assert.Len(t, conversation.Messages, 1, "Expected one message in the conversation")
+	})
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal request body: %v", err)
+	}
+
+	return string(payload)
+}
+
+// deliverQueued runs the worker until the outbox has nothing left to claim.
+func deliverQueued(t *testing.T, w *worker.Worker) {
+	t.Helper()
+
+	for {
+		processed, err := w.ProcessBatch(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to process outbox: %v", err)
+		}
+		if processed == 0 {
+			return
+		}
+	}
+}
+
+// getConversationID returns the ID of the only conversation, or 0 when there is none.
+func getConversationID(t *testing.T, e *echo.Echo) int64 {
+	t.Helper()
+
+	response := oapi.NewRequest().Get("/api/conversations").GoWithHTTPHandler(t, e)
+	if response.Code() != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", response.Code())
+	}
+
+	var page repository.ConversationPage
+	if err := response.UnmarshalBodyToObject(&page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	conversations := page.Conversations
+	if len(conversations) == 0 {
+		return 0
+	}
+	if len(conversations) > 1 {
+		t.Fatalf("Expected at most one conversation, got %d", len(conversations))
+	}
+
+	return conversations[0].ID
+}
+
+// getMessages returns the messages of the only conversation, or nil if there is none.
+func getMessages(t *testing.T, e *echo.Echo) []repository.Message {
+	t.Helper()
+
+	conversationID := getConversationID(t, e)
+	if conversationID == 0 {
+		return nil
+	}
+
+	path := fmt.Sprintf("/api/conversations/%d/messages", conversationID)
+	response := oapi.NewRequest().Get(path).GoWithHTTPHandler(t, e)
+	if response.Code() != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", response.Code())
+	}
+
+	var conversation repository.Conversation
+	if err := response.UnmarshalBodyToObject(&conversation); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	return conversation.Messages
+}
+
+// deliverAndGetMessages delivers every queued message and returns the messages of the only conversation.
+func deliverAndGetMessages(t *testing.T, e *echo.Echo, w *worker.Worker) []repository.Message {
+	t.Helper()
+
+	deliverQueued(t, w)
+	return getMessages(t, e)
+}
+
+func countStatus(messages []repository.Message, status string) int {
+	count := 0
+	for _, msg := range messages {
+		if msg.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// slowProvider takes delay to send each message and counts the sends of each body.
+type slowProvider struct {
+	delay time.Duration
+	mu    sync.Mutex
+	sends map[string]int
+}
+
+func (p *slowProvider) SendMessage(ctx context.Context, msg service.OutboundMessage) (string, error) {
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sends == nil {
+		p.sends = map[string]int{}
+	}
+	p.sends[msg.Body]++
+	return fmt.Sprintf("SMslow%d", len(p.sends)), nil
+}
+
+func (p *slowProvider) sent(body string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sends[body]
 }
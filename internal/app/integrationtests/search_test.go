@@ -0,0 +1,156 @@
+package integrationtests_test
+
+import (
+	"hatchapp/internal/app/server"
+	"hatchapp/internal/pkg/repository"
+	"hatchapp/internal/pkg/testutils"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	oapi "github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/khaiql/dbcleaner.v2"
+	"gopkg.in/khaiql/dbcleaner.v2/engine"
+)
+
+func TestMessageSearch(t *testing.T) {
+	postgres := engine.NewPostgresEngine(testutils.ConnectionString)
+	cleaner := dbcleaner.New()
+	cleaner.SetEngine(postgres)
+
+	e := testutils.NewServer()
+
+	t.Run("search message bodies", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		inbound := []struct {
+			path string
+			body any
+		}{
+			{"/api/webhooks/sms", server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      "My refund has not arrived yet",
+				CreatedAt: "2023-10-01T12:00:00Z",
+			}},
+			{"/api/webhooks/email", server.EmailMessage{
+				From:      "customer@example.com",
+				To:        server.Recipients{"support@example.com"},
+				Body:      `<html><style>.refund { color: red }</style><body><p>Where is my <b>refund</b>? Refund please!</p></body></html>`,
+				CreatedAt: "2023-10-02T12:00:00Z",
+			}},
+			{"/api/webhooks/sms", server.TextMessage{
+				From:      "+1234567890",
+				To:        server.Recipients{"+0987654321"},
+				Type:      "sms",
+				Body:      "Never mind, it showed up",
+				CreatedAt: "2023-10-03T12:00:00Z",
+			}},
+		}
+		for _, msg := range inbound {
+			response := oapi.NewRequest().Post(msg.path).WithJsonBody(msg.body).GoWithHTTPHandler(t, e)
+			if response.Code() != http.StatusCreated {
+				t.Fatalf("Expected status code 201, got %d", response.Code())
+			}
+		}
+
+		page := searchMessages(t, e, url.Values{"q": {"refund"}})
+		if assert.Len(t, page.Results, 2) {
+			email := page.Results[0]
+			assert.Equal(t, repository.CommunicationTypeEmail, email.CommunicationType, "expected the email mentioning refund twice to rank first")
+			assert.Contains(t, email.Highlight, "<mark>refund</mark>")
+			assert.NotContains(t, email.Highlight, "<b>", "expected markup to be stripped from highlights")
+			assert.NotContains(t, email.Highlight, "color", "expected style sheets not to be indexed")
+		}
+
+		page = searchMessages(t, e, url.Values{"q": {"refund"}, "channel": {"phone"}})
+		if assert.Len(t, page.Results, 1) {
+			assert.Equal(t, "My refund has not arrived yet", page.Results[0].Body)
+		}
+
+		page = searchMessages(t, e, url.Values{"q": {"refund"}, "participant": {"support@example.com"}})
+		assert.Len(t, page.Results, 1)
+
+		page = searchMessages(t, e, url.Values{"q": {"refund"}, "from": {"2023-10-02T00:00:00Z"}})
+		assert.Len(t, page.Results, 1)
+
+		page = searchMessages(t, e, url.Values{"q": {"refund"}, "direction": {"outbound"}})
+		assert.Empty(t, page.Results)
+
+		first := searchMessages(t, e, url.Values{"q": {"refund"}, "limit": {"1"}})
+		if assert.Len(t, first.Results, 1) && assert.NotEmpty(t, first.NextCursor) {
+			second := searchMessages(t, e, url.Values{"q": {"refund"}, "limit": {"1"}, "cursor": {first.NextCursor}})
+			if assert.Len(t, second.Results, 1) {
+				assert.NotEqual(t, first.Results[0].ID, second.Results[0].ID)
+			}
+			assert.Empty(t, second.NextCursor)
+		}
+
+		response := oapi.NewRequest().Get("/api/messages/search").GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+
+	t.Run("highlights are escaped", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		response := oapi.NewRequest().Post("/api/webhooks/email").WithJsonBody(server.EmailMessage{
+			From:      "customer@example.com",
+			To:        server.Recipients{"support@example.com"},
+			Body:      `Where is my refund? <img src=x onerror=alert(1)`,
+			CreatedAt: "2023-10-02T12:00:00Z",
+		}).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", response.Code())
+		}
+
+		page := searchMessages(t, e, url.Values{"q": {"refund"}})
+		if assert.Len(t, page.Results, 1) {
+			highlight := page.Results[0].Highlight
+			assert.Contains(t, highlight, "<mark>refund</mark>")
+			assert.NotContains(t, highlight, "<img", "expected an unclosed tag not to reach the highlight as markup")
+			assert.Contains(t, highlight, "&lt;img")
+		}
+	})
+
+	t.Run("text between style blocks is searchable", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		response := oapi.NewRequest().Post("/api/webhooks/email").WithJsonBody(server.EmailMessage{
+			From:      "customer@example.com",
+			To:        server.Recipients{"support@example.com"},
+			Body:      `<style>p { color: red }</style><p>Where is my refund?</p><style>p { margin: 0 }</style>`,
+			CreatedAt: "2023-10-02T12:00:00Z",
+		}).GoWithHTTPHandler(t, e)
+		if response.Code() != http.StatusCreated {
+			t.Fatalf("Expected status code 201, got %d", response.Code())
+		}
+
+		page := searchMessages(t, e, url.Values{"q": {"refund"}})
+		if assert.Len(t, page.Results, 1, "expected the text between the style blocks to be indexed") {
+			assert.Contains(t, page.Results[0].Highlight, "<mark>refund</mark>")
+			assert.NotContains(t, page.Results[0].Highlight, "margin", "expected style sheets not to be indexed")
+		}
+	})
+}
+
+func searchMessages(t *testing.T, e *echo.Echo, params url.Values) repository.SearchPage {
+	t.Helper()
+
+	response := oapi.NewRequest().Get("/api/messages/search?"+params.Encode()).GoWithHTTPHandler(t, e)
+	if response.Code() != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", response.Code())
+	}
+
+	var page repository.SearchPage
+	if err := response.UnmarshalBodyToObject(&page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	return page
+}
@@ -0,0 +1,140 @@
+package integrationtests_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"hatchapp/internal/app/server"
+	"hatchapp/internal/pkg/testutils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	oapi "github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/khaiql/dbcleaner.v2"
+	"gopkg.in/khaiql/dbcleaner.v2/engine"
+)
+
+const (
+	twilioAuthToken  = "authToken"
+	webhookPublicURL = "https://hatch.example.com"
+)
+
+func TestWebhookSignatures(t *testing.T) {
+	postgres := engine.NewPostgresEngine(testutils.ConnectionString)
+	cleaner := dbcleaner.New()
+	cleaner.SetEngine(postgres)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	verifier, err := server.NewWebhookVerifier(twilioAuthToken, base64.StdEncoding.EncodeToString(publicKey), webhookPublicURL, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create webhook verifier: %v", err)
+	}
+	e := testutils.NewServerWithWebhookVerifier(verifier)
+
+	signSendGrid := func(timestamp string, body []byte) string {
+		digest := sha256.Sum256(append([]byte(timestamp), body...))
+		signature, err := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign payload: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+
+	t.Run("twilio webhook with valid signature", func(t *testing.T) {
+		cleaner.Acquire(tables...)
+		defer cleaner.Clean(tables...)
+
+		body := []byte(mustJSON(t, server.TextMessage{
+			From:        "+1234567890",
+			To:          server.Recipients{"+0987654321"},
+			Type:        "sms",
+			Body:        "Hello, this is a signed message.",
+			Attachments: []string{},
+			ProviderID:  "SMsigned",
+			CreatedAt:   "2023-10-01T12:00:00Z",
+		}))
+		bodyHash := sha256.Sum256(body)
+		path := "/api/webhooks/sms?bodySHA256=" + hex.EncodeToString(bodyHash[:])
+		signature := server.TwilioSignature(twilioAuthToken, webhookPublicURL+path, nil)
+
+		response := oapi.NewRequest().Post(path).
+			WithJsonContentType().
+			WithHeader(server.HeaderTwilioSignature, signature).
+			WithBody(body).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusCreated, response.Code())
+
+		tampered := append([]byte(nil), body...)
+		tampered[len(tampered)-2] = ' '
+		response = oapi.NewRequest().Post(path).
+			WithJsonContentType().
+			WithHeader(server.HeaderTwilioSignature, signature).
+			WithBody(tampered).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnauthorized, response.Code(), "expected a modified body to be rejected")
+	})
+
+	t.Run("twilio form webhook signatures", func(t *testing.T) {
+		form := url.Values{"MessageSid": {"SMunknown"}, "MessageStatus": {"delivered"}}
+		path := "/api/webhooks/sms/status"
+
+		response := oapi.NewRequest().Post(path).
+			WithContentType("application/x-www-form-urlencoded").
+			WithHeader(server.HeaderTwilioSignature, server.TwilioSignature("wrongToken", webhookPublicURL+path, form)).
+			WithBody([]byte(form.Encode())).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnauthorized, response.Code(), "expected a signature from another account to be rejected")
+
+		response = oapi.NewRequest().Post(path).
+			WithContentType("application/x-www-form-urlencoded").
+			WithBody([]byte(form.Encode())).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusUnauthorized, response.Code(), "expected an unsigned request to be rejected")
+
+		response = oapi.NewRequest().Post(path).
+			WithContentType("application/x-www-form-urlencoded").
+			WithHeader(server.HeaderTwilioSignature, server.TwilioSignature(twilioAuthToken, webhookPublicURL+path, form)).
+			WithBody([]byte(form.Encode())).
+			GoWithHTTPHandler(t, e)
+		assert.Equal(t, http.StatusNotFound, response.Code(), "expected a signed request to reach the handler")
+	})
+
+	t.Run("sendgrid webhook signatures", func(t *testing.T) {
+		body := []byte(`[{"event":"delivered","sg_message_id":"unknown.filter0001"}]`)
+		path := "/api/webhooks/email/events"
+
+		send := func(timestamp, signature string, body []byte) int {
+			return oapi.NewRequest().Post(path).
+				WithJsonContentType().
+				WithHeader(server.HeaderSendGridTimestamp, timestamp).
+				WithHeader(server.HeaderSendGridSignature, signature).
+				WithBody(body).
+				GoWithHTTPHandler(t, e).Code()
+		}
+
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		signature := signSendGrid(now, body)
+		assert.Equal(t, http.StatusOK, send(now, signature, body))
+		assert.Equal(t, http.StatusUnauthorized, send(now, signature, body), "expected a replayed request to be rejected")
+		assert.Equal(t, http.StatusUnauthorized, send(now, signSendGrid(now, body), []byte(`[]`)), "expected a modified body to be rejected")
+
+		stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		assert.Equal(t, http.StatusUnauthorized, send(stale, signSendGrid(stale, body), body), "expected an old timestamp to be rejected")
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"hatchapp/internal/pkg/apperrors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateAgent creates an agent conversations can be assigned to.
+func (s *Server) CreateAgent(c echo.Context) error {
+	input, err := s.bindAgent(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	agent, err := s.Repo.CreateAgent(c.Request().Context(), input.ToRepositoryAgent())
+	if err != nil {
+		err = conflictError(err, "an agent with this email already exists")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to create agent")
+	}
+
+	return c.JSON(http.StatusCreated, agent)
+}
+
+// GetAgents returns all agents.
+func (s *Server) GetAgents(c echo.Context) error {
+	agents, err := s.Repo.GetAgents(c.Request().Context())
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get agents")
+	}
+
+	return c.JSON(http.StatusOK, agents)
+}
+
+// GetAgentByID returns an agent.
+func (s *Server) GetAgentByID(c echo.Context) error {
+	agent, err := s.Repo.GetAgentByID(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get agent")
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
+
+// UpdateAgent replaces an agent's name, email and availability. Only available agents are
+// assigned new inbound conversations automatically.
+func (s *Server) UpdateAgent(c echo.Context) error {
+	input, err := s.bindAgent(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	agent, err := s.Repo.UpdateAgent(c.Request().Context(), c.Param("id"), input.ToRepositoryAgent())
+	if err != nil {
+		err = conflictError(err, "an agent with this email already exists")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to update agent")
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
+
+// AssignConversation assigns a conversation to the agent in the body, or unassigns it.
+// The user the request acts for, if any, is recorded as making the change.
+func (s *Server) AssignConversation(c echo.Context) error {
+	var input AssigneeInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	conversation, err := s.Repo.AssignConversation(c.Request().Context(), c.Param("id"), input.AgentID, s.userID(c))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to assign conversation")
+	}
+
+	return c.JSON(http.StatusOK, conversation)
+}
+
+// GetAssignmentHistory returns the changes of a conversation's assignee, oldest first.
+func (s *Server) GetAssignmentHistory(c echo.Context) error {
+	history, err := s.Repo.GetAssignmentHistory(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get assignment history")
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+func (s *Server) bindAgent(c echo.Context) (*AgentInput, error) {
+	var input AgentInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return nil, apperrors.NewHTTPError(err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return nil, err
+	}
+
+	return &input, nil
+}
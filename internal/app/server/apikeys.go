@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// API key scopes. ScopeAdmin grants every other scope.
+const (
+	ScopeMessagesSend       = "messages:send"
+	ScopeConversationsRead  = "conversations:read"
+	ScopeConversationsWrite = "conversations:write"
+	ScopeAdmin              = "admin"
+)
+
+// Scopes lists the scopes an API key can be given.
+var Scopes = []string{ScopeMessagesSend, ScopeConversationsRead, ScopeConversationsWrite, ScopeAdmin}
+
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to recognize.
+	apiKeyPrefix = "hk_"
+	// apiKeyDisplayLength is how much of a key is kept to tell keys apart.
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	// apiKeyContextKey is where RequireScope stores the authenticated *repository.APIKey.
+	apiKeyContextKey = "api_key"
+)
+
+// GenerateAPIKey returns a new random API key, its displayable prefix and the hash to
+// store. The key itself must not be stored.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, key[:apiKeyDisplayLength], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the SHA-256 of an API key. Keys are long and random, so a fast hash
+// is enough to make stored hashes useless to an attacker.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidScope reports whether scope can be given to an API key.
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// RequireScope returns Echo middleware that authenticates requests with an API key sent
+// as "Authorization: Bearer <key>" and rejects keys without the given scope. Missing,
+// unknown or revoked keys get 401, keys lacking the scope 403. It does nothing unless
+// RequireAPIKeys is set.
+func (s *Server) RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !s.RequireAPIKeys {
+				return next(c)
+			}
+
+			token, found := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !found || token == "" {
+				return unauthorized(c, errors.New("missing bearer token"))
+			}
+
+			key, err := s.Repo.AuthenticateAPIKey(c.Request().Context(), HashAPIKey(token))
+			if err != nil {
+				if errors.Is(err, apperrors.DBErrorNotFound) {
+					return unauthorized(c, errors.New("unknown or revoked API key"))
+				}
+				return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to authenticate API key")
+			}
+
+			if !slices.Contains(key.Scopes, scope) && !slices.Contains(key.Scopes, ScopeAdmin) {
+				err := fmt.Errorf("API key %d lacks scope %s", key.ID, scope)
+				err = apperrors.NewHTTPError(err, http.StatusForbidden, "API key lacks the "+scope+" scope")
+				return apperrors.ApiErrorResponse(c, err, http.StatusForbidden, "forbidden")
+			}
+
+			c.Set(apiKeyContextKey, key)
+			return next(c)
+		}
+	}
+}
+
+func unauthorized(c echo.Context, err error) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	err = apperrors.NewHTTPError(err, http.StatusUnauthorized, "a valid API key is required")
+	return apperrors.ApiErrorResponse(c, err, http.StatusUnauthorized, "unauthorized")
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateContact creates a contact owning the given phone and email identities.
+func (s *Server) CreateContact(c echo.Context) error {
+	input, err := s.bindContact(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	contact, err := s.Repo.CreateContact(c.Request().Context(), input.ToRepositoryContact())
+	if err != nil {
+		err = conflictError(err, "an identifier already belongs to another contact")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to create contact")
+	}
+
+	return c.JSON(http.StatusCreated, contact)
+}
+
+// GetContacts returns a page of contacts, most recently created first. Merged contacts
+// are listed as part of the contact they were merged into.
+func (s *Server) GetContacts(c echo.Context) error {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid limit")
+	}
+
+	query := repository.ContactQuery{Limit: limit}
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		if query.After, err = repository.DecodeCursor(cursor); err != nil {
+			err = apperrors.NewHTTPError(err, http.StatusBadRequest, "invalid cursor")
+			return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid cursor")
+		}
+	}
+
+	page, err := s.Repo.GetContacts(c.Request().Context(), query)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get contacts")
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetContactByID returns a contact with its identities.
+func (s *Server) GetContactByID(c echo.Context) error {
+	contact, err := s.Repo.GetContactByID(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get contact")
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+// UpdateContact replaces a contact's name, metadata and identities.
+func (s *Server) UpdateContact(c echo.Context) error {
+	input, err := s.bindContact(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	contact, err := s.Repo.UpdateContact(c.Request().Context(), c.Param("id"), input.ToRepositoryContact())
+	if err != nil {
+		err = conflictError(err, "an identifier already belongs to another contact")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to update contact")
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+// DeleteContact deletes a contact. Its identities and conversations are kept.
+func (s *Server) DeleteContact(c echo.Context) error {
+	if err := s.Repo.DeleteContact(c.Request().Context(), c.Param("id")); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to delete contact")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// MergeContacts merges the contact in the body into the contact in the URL.
+func (s *Server) MergeContacts(c echo.Context) error {
+	var input MergeInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	sourceID := fmt.Sprintf("%d", input.ContactID)
+	contact, err := s.Repo.MergeContacts(c.Request().Context(), c.Param("id"), sourceID)
+	if err != nil {
+		err = conflictError(err, "a contact cannot be merged into itself or with an already merged contact")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to merge contacts")
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+// UnmergeContact splits a merged contact off the contact it was merged into.
+func (s *Server) UnmergeContact(c echo.Context) error {
+	contact, err := s.Repo.UnmergeContact(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		err = conflictError(err, "contact is not merged")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to unmerge contact")
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+// GetContactTimeline returns the messages of all the contact's conversations, across
+// channels, newest first. Pass the returned next_cursor as cursor to get older messages.
+func (s *Server) GetContactTimeline(c echo.Context) error {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid limit")
+	}
+
+	query := repository.TimelineQuery{Limit: limit}
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		if query.Before, err = repository.DecodeCursor(cursor); err != nil {
+			err = apperrors.NewHTTPError(err, http.StatusBadRequest, "invalid cursor")
+			return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid cursor")
+		}
+	}
+
+	page, err := s.Repo.GetContactTimeline(c.Request().Context(), c.Param("id"), query)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get contact timeline")
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// bindContact decodes and validates a contact request body.
+func (s *Server) bindContact(c echo.Context) (*ContactInput, error) {
+	var input ContactInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return nil, apperrors.NewHTTPError(err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return nil, err
+	}
+
+	return &input, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// conversationStatuses are the values accepted by the status query parameter.
+var conversationStatuses = []string{
+	repository.ConversationStatusOpen,
+	repository.ConversationStatusSnoozed,
+	repository.ConversationStatusClosed,
+	repository.ConversationStatusArchived,
+}
+
+// ReopenConversation moves a snoozed, closed or archived conversation back to open.
+func (s *Server) ReopenConversation(c echo.Context) error {
+	return s.transitionConversation(c, repository.ConversationStatusOpen, time.Time{})
+}
+
+// SnoozeConversation hides a conversation from the open list until the time in the body.
+func (s *Server) SnoozeConversation(c echo.Context) error {
+	var input SnoozeInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	until, _ := time.Parse(time.RFC3339, input.Until)
+	if !until.After(time.Now()) {
+		err := errors.New("until must be in the future")
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, err.Error())
+	}
+
+	return s.transitionConversation(c, repository.ConversationStatusSnoozed, until)
+}
+
+// CloseConversation marks a conversation as handled. A new inbound message reopens it.
+func (s *Server) CloseConversation(c echo.Context) error {
+	return s.transitionConversation(c, repository.ConversationStatusClosed, time.Time{})
+}
+
+// ArchiveConversation removes a conversation from the work queue. A new inbound message
+// reopens it.
+func (s *Server) ArchiveConversation(c echo.Context) error {
+	return s.transitionConversation(c, repository.ConversationStatusArchived, time.Time{})
+}
+
+// transitionConversation moves the conversation in the path to status and responds with
+// its new state.
+func (s *Server) transitionConversation(c echo.Context, status string, snoozedUntil time.Time) error {
+	conversation, err := s.Repo.TransitionConversation(c.Request().Context(), c.Param("id"), status, snoozedUntil)
+	if err != nil {
+		err = conflictError(err, "conversation cannot move to "+status+" from its current status")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to move conversation to "+status)
+	}
+
+	return c.JSON(http.StatusOK, conversation)
+}
+
+// parseStatuses reads the statuses to list from the comma separated status parameter, or
+// "all". The archived flag is kept as a shorthand: true lists archived conversations and
+// false all others. Without either only open conversations are listed.
+func parseStatuses(c echo.Context) ([]string, error) {
+	status, archived := c.QueryParam("status"), c.QueryParam("archived")
+	if status != "" && archived != "" {
+		err := errors.New("status and archived cannot be combined")
+		return nil, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	if archived != "" {
+		flag, err := strconv.ParseBool(archived)
+		if err != nil {
+			err := errors.New("archived must be true or false")
+			return nil, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+		if flag {
+			return []string{repository.ConversationStatusArchived}, nil
+		}
+		return conversationStatuses[:3], nil
+	}
+
+	switch status {
+	case "":
+		return []string{repository.ConversationStatusOpen}, nil
+	case "all":
+		return nil, nil
+	}
+
+	statuses := strings.Split(status, ",")
+	for _, s := range statuses {
+		if !slices.Contains(conversationStatuses, s) {
+			err := errors.New("status must be all or a comma separated list of " + strings.Join(conversationStatuses, ", "))
+			return nil, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+	}
+
+	return statuses, nil
+}
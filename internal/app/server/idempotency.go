@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotentReplayed  = "Idempotent-Replayed"
+	DefaultIdempotencyTTL     = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+	idempotencyReleaseTimeout = 5 * time.Second
+	// idempotencyLockTimeout bounds how long a request may hold its key in flight. A retry
+	// of the same request after it takes the key over, so a crash mid-request does not
+	// block the key until it expires.
+	idempotencyLockTimeout = time.Minute
+)
+
+// responseRecorder copies everything written to the response so it can be stored.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// requestFingerprint identifies a request by its method, route and raw body.
+func requestFingerprint(c echo.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request().Method + " " + c.Path() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotent is Echo middleware for endpoints clients may safely retry. The first
+// request with a given Idempotency-Key is processed and its response stored; repeats
+// within IdempotencyTTL get the stored response back instead of being processed again.
+// Reusing a key for a different request is rejected with 422. Keys are scoped to the API
+// key a request is made with.
+func (s *Server) Idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		keyValue := c.Request().Header.Get(HeaderIdempotencyKey)
+		if keyValue == "" {
+			return next(c)
+		}
+
+		if len(keyValue) > maxIdempotencyKeyLength {
+			err := apperrors.NewHTTPError(errors.New("idempotency key too long"),
+				http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid Idempotency-Key")
+		}
+
+		body, err := readBody(c)
+		if err != nil {
+			return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to read request body")
+		}
+
+		now := time.Now()
+		key := repository.IdempotencyKey{
+			Key:         keyValue,
+			Endpoint:    c.Path(),
+			Owner:       idempotencyOwner(c),
+			Fingerprint: requestFingerprint(c, body),
+			ExpiresAt:   now.Add(s.IdempotencyTTL),
+			LockedUntil: now.Add(idempotencyLockTimeout),
+		}
+
+		stored, reserved, err := s.Repo.ReserveIdempotencyKey(c.Request().Context(), key)
+		if err != nil {
+			err = conflictError(err, "a request with this Idempotency-Key is still being processed")
+			return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to reserve Idempotency-Key")
+		}
+
+		if !reserved {
+			return replayIdempotentResponse(c, key, stored)
+		}
+		key = *stored
+
+		recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = recorder
+
+		handlerErr := next(c)
+
+		// Only successful and client error responses are final; anything else lets the
+		// client retry with the same key.
+		status := c.Response().Status
+		if handlerErr != nil || status >= http.StatusInternalServerError {
+			releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(c.Request().Context()), idempotencyReleaseTimeout)
+			defer cancel()
+			if err := s.Repo.ReleaseIdempotencyKey(releaseCtx, key); err != nil {
+				log.Errorf("failed to release idempotency key %s: %v", key.Key, err)
+			}
+			return handlerErr
+		}
+
+		key.StatusCode = status
+		key.Response = recorder.body.Bytes()
+		if err := s.Repo.SaveIdempotentResponse(c.Request().Context(), key); err != nil {
+			log.Errorf("failed to save response for idempotency key %s: %v", key.Key, err)
+		}
+
+		return nil
+	}
+}
+
+// idempotencyOwner returns the ID of the API key a request was made with, or "" when the
+// server does not require API keys.
+func idempotencyOwner(c echo.Context) string {
+	if key, ok := c.Get(apiKeyContextKey).(*repository.APIKey); ok {
+		return strconv.FormatInt(key.ID, 10)
+	}
+	return ""
+}
+
+func replayIdempotentResponse(c echo.Context, key repository.IdempotencyKey, existing *repository.IdempotencyKey) error {
+	if existing.Fingerprint != key.Fingerprint {
+		err := apperrors.NewHTTPError(errors.New("idempotency key fingerprint mismatch"),
+			http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "Idempotency-Key reused")
+	}
+
+	if existing.StatusCode == 0 {
+		err := apperrors.NewHTTPError(errors.New("idempotency key in flight"),
+			http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+		return apperrors.ApiErrorResponse(c, err, http.StatusConflict, "Idempotency-Key in flight")
+	}
+
+	c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+	return c.JSONBlob(existing.StatusCode, existing.Response)
+}
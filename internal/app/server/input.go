@@ -1,18 +1,39 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"hatchapp/internal/pkg/repository"
+	"strings"
 )
 
+// Recipients is the "to" field of a message. It accepts a single address or a list of
+// addresses for group conversations.
+type Recipients []string
+
+func (r *Recipients) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*r = Recipients{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("to must be an address or a list of addresses: %w", err)
+	}
+	*r = list
+	return nil
+}
+
 type TextMessage struct {
-	From        string   `json:"from" validate:"required,e164"`                  // E.164 phone number format
-	To          string   `json:"to" validate:"required,e164"`                    // E.164 phone number format
-	Type        string   `json:"type" validate:"required,oneof=sms mms"`         // Restrict to known types
-	Body        string   `json:"body" validate:"required"`                       // Must be non-empty
-	Attachments []string `json:"attachments" validate:"omitempty,dive,required"` // Each attachment must be a valid URL if present
-	ProviderID  string   `json:"messaging_provider_id"`
-	CreatedAt   string   `json:"timestamp" validate:"required,datetime=2006-01-02T15:04:05Z"`
+	From        string     `json:"from" validate:"required,e164"`                              // E.164 phone number format
+	To          Recipients `json:"to" validate:"required,min=1,unique,dive,e164,nefield=From"` // E.164 phone numbers other than the sender; several for group messages
+	Type        string     `json:"type" validate:"required,oneof=sms mms"`                     // Restrict to known types
+	Body        string     `json:"body" validate:"required"`                                   // Must be non-empty
+	Attachments []string   `json:"attachments" validate:"omitempty,dive,required"`             // Each attachment must be a valid URL if present
+	ProviderID  string     `json:"messaging_provider_id"`
+	CreatedAt   string     `json:"timestamp" validate:"required,datetime=2006-01-02T15:04:05Z"`
 }
 
 func (m *TextMessage) ToRepositoryMessage(status string) (repository.Message, error) {
@@ -41,12 +62,13 @@ func (m *TextMessage) ToRepositoryMessage(status string) (repository.Message, er
 }
 
 type EmailMessage struct {
-	From        string   `json:"from" validate:"required,email"`                 // Valid email
-	To          string   `json:"to" validate:"required,email"`                   // Valid email
-	Body        string   `json:"body" validate:"required"`                       // Non-empty body
-	Attachments []string `json:"attachments" validate:"omitempty,dive,required"` // Each attachment must be a valid URL if present
-	ProviderID  string   `json:"xillio_id"`
-	CreatedAt   string   `json:"timestamp" validate:"required,datetime=2006-01-02T15:04:05Z"`
+	From        string     `json:"from" validate:"required,email"`                              // Valid email
+	To          Recipients `json:"to" validate:"required,min=1,unique,dive,email,nefield=From"` // Valid emails other than the sender; several for group messages
+	Subject     string     `json:"subject" validate:"omitempty,max=998"`                        // Optional, at most one header line
+	Body        string     `json:"body" validate:"required"`                                    // Non-empty body
+	Attachments []string   `json:"attachments" validate:"omitempty,dive,required"`              // Each attachment must be a valid URL if present
+	ProviderID  string     `json:"xillio_id"`
+	CreatedAt   string     `json:"timestamp" validate:"required,datetime=2006-01-02T15:04:05Z"`
 }
 
 func (m *EmailMessage) ToRepositoryMessage(status string) (repository.Message, error) {
@@ -56,6 +78,7 @@ func (m *EmailMessage) ToRepositoryMessage(status string) (repository.Message, e
 		Type:              repository.CommunicationTypeEmail,
 		CommunicationType: repository.CommunicationTypeEmail,
 		Body:              m.Body,
+		Subject:           m.Subject,
 		Attachments:       m.Attachments,
 		ProviderID:        m.ProviderID,
 		CreatedAt:         m.CreatedAt,
@@ -64,3 +87,84 @@ func (m *EmailMessage) ToRepositoryMessage(status string) (repository.Message, e
 
 	return msg, nil
 }
+
+// ContactInput creates or replaces a contact. Identifiers are phone numbers in E.164
+// format or email addresses.
+type ContactInput struct {
+	Name        string         `json:"name" validate:"required"`
+	Metadata    map[string]any `json:"metadata"`
+	Identifiers []string       `json:"identifiers" validate:"omitempty,unique,dive,e164|email"`
+}
+
+func (c *ContactInput) ToRepositoryContact() repository.Contact {
+	contact := repository.Contact{
+		Name:        c.Name,
+		Metadata:    c.Metadata,
+		Identifiers: make([]repository.Communication, 0, len(c.Identifiers)),
+	}
+
+	for _, identifier := range c.Identifiers {
+		commType := repository.CommunicationTypePhone
+		if strings.Contains(identifier, "@") {
+			commType = repository.CommunicationTypeEmail
+		}
+		contact.Identifiers = append(contact.Identifiers, repository.Communication{
+			Identifier: identifier,
+			Type:       commType,
+		})
+	}
+
+	return contact
+}
+
+// MergeInput names the contact to merge into the one in the URL.
+type MergeInput struct {
+	ContactID int64 `json:"contact_id" validate:"required,min=1"`
+}
+
+// ReadInput marks a conversation read up to a message. Without a message ID the whole
+// conversation is marked read.
+type ReadInput struct {
+	MessageID int64 `json:"message_id" validate:"omitempty,min=1"`
+}
+
+// MessagePatch updates a message's internal metadata. Its keys are merged into the
+// existing metadata; keys set to null are removed.
+type MessagePatch struct {
+	Metadata map[string]any `json:"metadata" validate:"required"`
+}
+
+// AgentInput creates or replaces an agent.
+type AgentInput struct {
+	Name      string `json:"name" validate:"required"`
+	Email     string `json:"email" validate:"required,email"`
+	Available bool   `json:"available"`
+}
+
+func (a *AgentInput) ToRepositoryAgent() repository.Agent {
+	return repository.Agent{
+		Name:      a.Name,
+		Email:     a.Email,
+		Available: a.Available,
+	}
+}
+
+// AssigneeInput names the agent to assign a conversation to; a null agent_id unassigns it.
+type AssigneeInput struct {
+	AgentID *int64 `json:"agent_id" validate:"omitempty,min=1"`
+}
+
+// TagInput names a tag to create, or to put on a conversation or message.
+type TagInput struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+// NoteInput creates or edits an internal note.
+type NoteInput struct {
+	Body string `json:"body" validate:"required"`
+}
+
+// SnoozeInput is the body of a snooze request.
+type SnoozeInput struct {
+	Until string `json:"until" validate:"required,datetime=2006-01-02T15:04:05Z07:00"`
+}
@@ -8,12 +8,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"hatchapp/config"
 	"hatchapp/internal/pkg/repository"
 	"hatchapp/internal/pkg/service"
+	"hatchapp/internal/pkg/worker"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -29,13 +31,57 @@ func Initialize(server *Server) *echo.Echo {
 	e.Use(middleware.Recover())
 	e.Use(middleware.Gzip())
 
-	// Routes
-	e.POST("/api/messages/sms", server.CreateTextMesssage)
-	e.POST("/api/webhooks/sms", server.CreateTextMesssage)
-	e.POST("/api/messages/email", server.CreateEmailMessage)
-	e.POST("/api/webhooks/email", server.CreateEmailMessage)
-	e.GET("/api/conversations", server.GetConversations)
-	e.GET("/api/conversations/:id/messages", server.GetConversationByID)
+	// Routes. Provider webhooks are authenticated by their signatures, every other route
+	// by an API key with the scope it requires.
+	read := server.RequireScope(ScopeConversationsRead)
+	write := server.RequireScope(ScopeConversationsWrite)
+	send := server.RequireScope(ScopeMessagesSend)
+	admin := server.RequireScope(ScopeAdmin)
+
+	e.POST("/api/messages/sms", server.CreateTextMesssage, send, server.Idempotent)
+	e.POST("/api/webhooks/sms", server.CreateTextMesssage, server.Webhooks.Twilio)
+	e.POST("/api/messages/email", server.CreateEmailMessage, send, server.Idempotent)
+	e.POST("/api/webhooks/email", server.CreateEmailMessage, server.Webhooks.SendGrid)
+	e.POST("/api/webhooks/sms/status", server.TwilioStatusCallback, server.Webhooks.Twilio)
+	e.POST("/api/webhooks/email/events", server.SendGridEventWebhook, server.Webhooks.SendGrid)
+	e.POST("/api/messages/:id/retry", server.RetryMessage, send)
+	e.GET("/api/messages/search", server.SearchMessages, read)
+	e.GET("/api/messages/:id", server.GetMessageByID, read)
+	e.PATCH("/api/messages/:id", server.UpdateMessage, write)
+	e.DELETE("/api/messages/:id", server.DeleteMessage, write)
+	e.POST("/api/messages/:id/tags", server.TagMessage, write)
+	e.DELETE("/api/messages/:id/tags/:tagID", server.UntagMessage, write)
+	e.GET("/api/conversations", server.GetConversations, read)
+	e.GET("/api/conversations/unread", server.GetUnreadSummary, read)
+	e.POST("/api/conversations/:id/read", server.MarkConversationRead, write)
+	e.POST("/api/conversations/:id/archive", server.ArchiveConversation, write)
+	e.POST("/api/conversations/:id/close", server.CloseConversation, write)
+	e.POST("/api/conversations/:id/snooze", server.SnoozeConversation, write)
+	e.POST("/api/conversations/:id/reopen", server.ReopenConversation, write)
+	e.PUT("/api/conversations/:id/assignee", server.AssignConversation, write)
+	e.GET("/api/conversations/:id/assignments", server.GetAssignmentHistory, read)
+	e.POST("/api/conversations/:id/tags", server.TagConversation, write)
+	e.DELETE("/api/conversations/:id/tags/:tagID", server.UntagConversation, write)
+	e.POST("/api/conversations/:id/notes", server.CreateNote, write)
+	e.GET("/api/conversations/:id/notes", server.GetNotes, read)
+	e.PUT("/api/notes/:id", server.UpdateNote, write)
+	e.DELETE("/api/notes/:id", server.DeleteNote, write)
+	e.GET("/api/conversations/:id/messages", server.GetConversationByID, read)
+	e.POST("/api/tags", server.CreateTag, admin)
+	e.GET("/api/tags", server.GetTags, read)
+	e.DELETE("/api/tags/:id", server.DeleteTag, admin)
+	e.POST("/api/agents", server.CreateAgent, admin)
+	e.GET("/api/agents", server.GetAgents, read)
+	e.GET("/api/agents/:id", server.GetAgentByID, read)
+	e.PUT("/api/agents/:id", server.UpdateAgent, admin)
+	e.POST("/api/contacts", server.CreateContact, admin)
+	e.GET("/api/contacts", server.GetContacts, read)
+	e.GET("/api/contacts/:id", server.GetContactByID, read)
+	e.PUT("/api/contacts/:id", server.UpdateContact, admin)
+	e.DELETE("/api/contacts/:id", server.DeleteContact, admin)
+	e.POST("/api/contacts/:id/merge", server.MergeContacts, admin)
+	e.POST("/api/contacts/:id/unmerge", server.UnmergeContact, admin)
+	e.GET("/api/contacts/:id/timeline", server.GetContactTimeline, read)
 
 	return e
 }
@@ -47,11 +93,6 @@ func Run(ctx context.Context) error {
 		return fmt.Errorf("repository not initialized: %w", err)
 	}
 
-	sendGridAccountID, found := config.GetValueFromConfig(ctx, "sendgrid_account_sid")
-	if !found {
-		return errors.New("sendgrid_account_id not found in config")
-	}
-
 	sendGridAPIKey, found := config.GetValueFromConfig(ctx, "sendgrid_api_key")
 	if !found {
 		return errors.New("sendgrid_api_key not found in config")
@@ -67,11 +108,66 @@ func Run(ctx context.Context) error {
 		return errors.New("twilio_api_key not found in config")
 	}
 
-	emailService := service.NewEmailService(sendGridAccountID, sendGridAPIKey)
-	textService := service.NewTextService(twilioAccountSID, twilioAPIKey)
-	server := NewServer(repo, emailService, textService)
+	sendGridBaseURL, found := config.GetValueFromConfig(ctx, "sendgrid_base_url")
+	if !found {
+		return errors.New("sendgrid_base_url not found in config")
+	}
+
+	twilioBaseURL, found := config.GetValueFromConfig(ctx, "twilio_base_url")
+	if !found {
+		return errors.New("twilio_base_url not found in config")
+	}
+
+	providerTimeout, found := config.GetValueFromConfig(ctx, "provider_timeout")
+	if !found {
+		return errors.New("provider_timeout not found in config")
+	}
+
+	timeout, err := time.ParseDuration(providerTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid provider_timeout: %w", err)
+	}
+
+	// The adapters authenticate each request with the provider's own scheme.
+	emailTransport := service.NewHTTPTransport(sendGridBaseURL, timeout, nil)
+	textTransport := service.NewHTTPTransport(twilioBaseURL, timeout, nil)
+	backoff, err := backoffPolicyFromConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	emailService := service.NewSendGridService(emailTransport, sendGridAPIKey)
+	emailService.Backoff = backoff
+	textService := service.NewTwilioService(textTransport, twilioAccountSID, twilioAPIKey)
+	textService.Backoff = backoff
+	outboxWorker, err := workerFromConfig(ctx, repo, emailService, textService)
+	if err != nil {
+		return err
+	}
+
+	server := NewServer(repo)
+	server.Webhooks, err = webhookVerifierFromConfig(ctx, twilioAPIKey)
+	if err != nil {
+		return err
+	}
+	server.RequireAPIKeys, err = requireAPIKeysFromConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if value, found := config.GetValueFromConfig(ctx, "idempotency_ttl"); found {
+		if server.IdempotencyTTL, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid idempotency_ttl: %w", err)
+		}
+	}
 	e := Initialize(server)
 
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	workerDone := make(chan struct{})
+	go func() {
+		outboxWorker.Run(workerCtx)
+		close(workerDone)
+	}()
+
 	go func() {
 		err := e.Start(":8080")
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -92,6 +188,140 @@ func Run(ctx context.Context) error {
 		log.Fatalf("Server shutdown failed: %v\n", err)
 	}
 
+	// Stop claiming outbox rows and let in-flight deliveries finish or be released.
+	stopWorker()
+	<-workerDone
+
 	log.Println("Server exited gracefully")
 	return nil
 }
+
+// backoffPolicyFromConfig builds the provider retry policy from the app config,
+// falling back to the default policy for any value that is not set.
+func backoffPolicyFromConfig(ctx context.Context) (service.BackoffPolicy, error) {
+	policy := service.DefaultBackoffPolicy()
+
+	durations := map[string]*time.Duration{
+		"retry_base_delay": &policy.BaseDelay,
+		"retry_max_delay":  &policy.MaxDelay,
+		"retry_deadline":   &policy.Deadline,
+	}
+	for key, target := range durations {
+		if value, found := config.GetValueFromConfig(ctx, key); found {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return policy, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*target = d
+		}
+	}
+
+	if value, found := config.GetValueFromConfig(ctx, "retry_multiplier"); found {
+		multiplier, err := strconv.ParseFloat(value, 64)
+		if err != nil || multiplier < 1 {
+			return policy, fmt.Errorf("invalid retry_multiplier: %q", value)
+		}
+		policy.Multiplier = multiplier
+	}
+
+	if value, found := config.GetValueFromConfig(ctx, "retry_jitter"); found {
+		jitter, err := strconv.ParseBool(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retry_jitter: %w", err)
+		}
+		policy.FullJitter = jitter
+	}
+
+	if value, found := config.GetValueFromConfig(ctx, "retry_max_attempts"); found {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			return policy, fmt.Errorf("invalid retry_max_attempts: %q", value)
+		}
+		policy.MaxAttempts = attempts
+	}
+
+	return policy, nil
+}
+
+// webhookVerifierFromConfig builds the provider webhook verifier from the app config.
+// Verification can only be turned off explicitly with verify_webhooks=false.
+func webhookVerifierFromConfig(ctx context.Context, twilioAuthToken string) (*WebhookVerifier, error) {
+	if value, found := config.GetValueFromConfig(ctx, "verify_webhooks"); found {
+		verify, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verify_webhooks: %w", err)
+		}
+		if !verify {
+			log.Println("WARNING: provider webhook signatures are not verified")
+			return nil, nil
+		}
+	}
+
+	publicKey, _ := config.GetValueFromConfig(ctx, "sendgrid_webhook_public_key")
+	if publicKey == "" {
+		return nil, errors.New("sendgrid_webhook_public_key is required to verify webhooks")
+	}
+
+	maxSkew := DefaultWebhookMaxSkew
+	if value, found := config.GetValueFromConfig(ctx, "webhook_max_skew"); found {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook_max_skew: %w", err)
+		}
+		maxSkew = d
+	}
+
+	publicURL, _ := config.GetValueFromConfig(ctx, "webhook_public_url")
+	return NewWebhookVerifier(twilioAuthToken, publicKey, publicURL, maxSkew)
+}
+
+// requireAPIKeysFromConfig reads whether /api routes require an API key. Like webhook
+// verification, it can only be turned off explicitly with require_api_keys=false.
+func requireAPIKeysFromConfig(ctx context.Context) (bool, error) {
+	value, found := config.GetValueFromConfig(ctx, "require_api_keys")
+	if !found {
+		return true, nil
+	}
+
+	require, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid require_api_keys: %w", err)
+	}
+	if !require {
+		log.Println("WARNING: API routes do not require an API key")
+	}
+
+	return require, nil
+}
+
+// workerFromConfig builds the outbox delivery worker from the app config, falling
+// back to the worker defaults for any value that is not set.
+func workerFromConfig(ctx context.Context, repo repository.Repository, emailService, textService service.Provider) (*worker.Worker, error) {
+	w := worker.New(repo, emailService, textService)
+
+	if value, found := config.GetValueFromConfig(ctx, "outbox_workers"); found {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			return nil, fmt.Errorf("invalid outbox_workers: %q", value)
+		}
+		w.Concurrency = concurrency
+	}
+
+	if value, found := config.GetValueFromConfig(ctx, "outbox_batch_size"); found {
+		batchSize, err := strconv.Atoi(value)
+		if err != nil || batchSize < 1 {
+			return nil, fmt.Errorf("invalid outbox_batch_size: %q", value)
+		}
+		w.BatchSize = batchSize
+	}
+
+	if value, found := config.GetValueFromConfig(ctx, "outbox_poll_interval"); found {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbox_poll_interval: %w", err)
+		}
+		w.PollInterval = interval
+	}
+
+	return w, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"hatchapp/internal/pkg/apperrors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetMessageByID returns a message with its recipients, metadata and status history,
+// including messages deleted from their conversation.
+func (s *Server) GetMessageByID(c echo.Context) error {
+	message, err := s.Repo.GetMessageByID(c.Request().Context(), s.tenantID(c), c.Param("id"))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get message")
+	}
+
+	return c.JSON(http.StatusOK, message)
+}
+
+// UpdateMessage merges the metadata in the body into the message's internal metadata.
+func (s *Server) UpdateMessage(c echo.Context) error {
+	var patch MessagePatch
+	if err := json.NewDecoder(c.Request().Body).Decode(&patch); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&patch); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	message, err := s.Repo.UpdateMessageMetadata(c.Request().Context(), s.tenantID(c), c.Param("id"), patch.Metadata)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to update message")
+	}
+
+	return c.JSON(http.StatusOK, message)
+}
+
+// DeleteMessage hides a message from its conversation. It is kept for audit.
+func (s *Server) DeleteMessage(c echo.Context) error {
+	if err := s.Repo.DeleteMessage(c.Request().Context(), c.Param("id")); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to delete message")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
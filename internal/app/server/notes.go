@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateNote adds an internal note to a conversation, written by the user the request
+// acts for. Notes are shown to agents only and are never sent.
+func (s *Server) CreateNote(c echo.Context) error {
+	author, err := s.requireUserID(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "missing user")
+	}
+
+	input, err := s.bindNote(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	note, err := s.Repo.CreateNote(c.Request().Context(), c.Param("id"), repository.Note{Author: author, Body: input.Body})
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to create note")
+	}
+
+	return c.JSON(http.StatusCreated, note)
+}
+
+// GetNotes returns the notes of a conversation, oldest first.
+func (s *Server) GetNotes(c echo.Context) error {
+	notes, err := s.Repo.GetNotes(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get notes")
+	}
+
+	return c.JSON(http.StatusOK, notes)
+}
+
+// UpdateNote replaces the body of a note.
+func (s *Server) UpdateNote(c echo.Context) error {
+	input, err := s.bindNote(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	note, err := s.Repo.UpdateNote(c.Request().Context(), c.Param("id"), input.Body)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to update note")
+	}
+
+	return c.JSON(http.StatusOK, note)
+}
+
+// DeleteNote deletes a note.
+func (s *Server) DeleteNote(c echo.Context) error {
+	if err := s.Repo.DeleteNote(c.Request().Context(), c.Param("id")); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to delete note")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) bindNote(c echo.Context) (*NoteInput, error) {
+	var input NoteInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return nil, apperrors.NewHTTPError(err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return nil, err
+	}
+
+	return &input, nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderUserID identifies the inbox user a request acts for when API keys are not
+// required, i.e. in local development.
+const HeaderUserID = "X-User-ID"
+
+// userID returns the user a request acts for, or "" for none. When API keys are required
+// it is the user the key was issued to, so clients cannot act for other users. Otherwise
+// it is the X-User-ID header.
+func (s *Server) userID(c echo.Context) string {
+	if !s.RequireAPIKeys {
+		return c.Request().Header.Get(HeaderUserID)
+	}
+
+	if key, ok := c.Get(apiKeyContextKey).(*repository.APIKey); ok {
+		return key.UserID
+	}
+	return ""
+}
+
+// tenantID returns the tenant a request acts for: the tenant of its API key, or the
+// default tenant "" when the server does not require API keys.
+func (s *Server) tenantID(c echo.Context) string {
+	if key, ok := c.Get(apiKeyContextKey).(*repository.APIKey); ok {
+		return key.TenantID
+	}
+	return ""
+}
+
+// requireUserID returns the user a request acts for, or an HTTP error when there is none:
+// 403 for API keys not issued to a user and 400 for a missing X-User-ID header.
+func (s *Server) requireUserID(c echo.Context) (string, error) {
+	userID := s.userID(c)
+	if userID != "" {
+		return userID, nil
+	}
+
+	if s.RequireAPIKeys {
+		err := errors.New("API key is not issued to a user")
+		return "", apperrors.NewHTTPError(err, http.StatusForbidden, "this API key is not issued to a user")
+	}
+	err := errors.New("missing user ID")
+	return "", apperrors.NewHTTPError(err, http.StatusBadRequest, HeaderUserID+" header is required")
+}
+
+// MarkConversationRead moves the user's read cursor of a conversation forward, to the
+// message_id in the body or to the conversation's last message.
+func (s *Server) MarkConversationRead(c echo.Context) error {
+	userID, err := s.requireUserID(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "missing user")
+	}
+
+	var input ReadInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	receipt, err := s.Repo.MarkConversationRead(c.Request().Context(), c.Param("id"), userID, input.MessageID)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to mark conversation read")
+	}
+
+	return c.JSON(http.StatusOK, receipt)
+}
+
+// GetUnreadSummary returns the user's unread message total across open and snoozed
+// conversations.
+func (s *Server) GetUnreadSummary(c echo.Context) error {
+	userID, err := s.requireUserID(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "missing user")
+	}
+
+	summary, err := s.Repo.GetUnreadSummary(c.Request().Context(), userID)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to count unread messages")
+	}
+
+	return c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SearchMessages returns the messages whose body matches q, best match first. Results
+// can be narrowed by channel, participant, direction, tag and a from/to timestamp range.
+// Pass the returned next_cursor as cursor to get the following page.
+func (s *Server) SearchMessages(c echo.Context) error {
+	query, err := parseSearchQuery(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid search parameters")
+	}
+	query.TenantID = s.tenantID(c)
+
+	page, err := s.Repo.SearchMessages(c.Request().Context(), query)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to search messages")
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// parseSearchQuery reads the query parameters of the message search endpoint.
+func parseSearchQuery(c echo.Context) (repository.SearchQuery, error) {
+	query := repository.SearchQuery{
+		Text:        strings.TrimSpace(c.QueryParam("q")),
+		Participant: c.QueryParam("participant"),
+		Tags:        c.QueryParams()["tag"],
+	}
+	if query.Text == "" {
+		err := errors.New("q is required")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return query, err
+	}
+	query.Limit = limit
+
+	switch channel := c.QueryParam("channel"); channel {
+	case "", repository.CommunicationTypePhone, repository.CommunicationTypeEmail:
+		query.Channel = channel
+	default:
+		err := errors.New("channel must be phone or email")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	switch direction := c.QueryParam("direction"); direction {
+	case "", repository.DirectionInbound, repository.DirectionOutbound:
+		query.Direction = direction
+	default:
+		err := errors.New("direction must be inbound or outbound")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	bounds := map[string]*time.Time{"from": &query.From, "to": &query.To}
+	for name, target := range bounds {
+		value := c.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			err := fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+		*target = t
+	}
+
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		if query.After, err = repository.DecodeSearchCursor(cursor); err != nil {
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, "invalid cursor")
+		}
+	}
+
+	return query, nil
+}
@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"hatchapp/internal/pkg/apperrors"
 	"hatchapp/internal/pkg/repository"
-	"hatchapp/internal/pkg/service"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
@@ -16,19 +17,24 @@ import (
 )
 
 type Server struct {
-	Repo           repository.Repository
-	Validator      *validator.Validate
-	MessageService *service.ExternalService
-	EmailService   *service.ExternalService
+	Repo      repository.Repository
+	Validator *validator.Validate
+	// Webhooks authenticates provider webhook calls. When nil, webhooks are not verified.
+	Webhooks *WebhookVerifier
+	// IdempotencyTTL is how long an Idempotency-Key replays its original response.
+	IdempotencyTTL time.Duration
+	// RequireAPIKeys makes every non-webhook route require an API key. When false, the
+	// routes are open.
+	RequireAPIKeys bool
 }
 
 // NewServer creates a new instance of the Server with the provided repository.
-func NewServer(repo repository.Repository, emailService, textService *service.ExternalService) *Server {
+// Outbound messages are only queued here; a worker.Worker delivers them.
+func NewServer(repo repository.Repository) *Server {
 	return &Server{
 		Repo:           repo,
 		Validator:      validator.New(),
-		MessageService: textService,
-		EmailService:   emailService,
+		IdempotencyTTL: DefaultIdempotencyTTL,
 	}
 }
 
@@ -48,29 +54,26 @@ func (s *Server) CreateTextMesssage(c echo.Context) error {
 		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
 	}
 
-	// If the request is for the SMS endpoint, send the message via the external service.
-	// If the request is for the webhook endpoint, we assume the message has already been sent by the provider.
-	status := repository.MessageStatusSuccess
-	if path := c.Path(); path == "/api/messages/sms" {
-		msg.ProviderID, err = s.MessageService.SendMessageWithRetries(msg.From, msg.To, msg.Body, msg.Attachments)
-		if err != nil {
-			log.Errorf("failed to send sms/mms message via provider: %v", err)
-			status = repository.MessageStatusFailed
-		}
-	}
-
 	// Convert to repository message
-	repoMsg, err := msg.ToRepositoryMessage(status)
+	repoMsg, err := msg.ToRepositoryMessage(repository.MessageStatusReceived)
 	if err != nil {
 		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "failed to convert message")
 	}
 
-	msgID, err := s.Repo.CreateMessage(c.Request().Context(), repoMsg)
+	// If the request is for the SMS endpoint, queue the message for delivery by the outbox worker.
+	// If the request is for the webhook endpoint, we assume the message has already been sent by the provider.
+	if path := c.Path(); path == "/api/messages/sms" {
+		return s.enqueueMessage(c, repoMsg)
+	}
+
+	repoMsg.Direction = repository.DirectionInbound
+
+	msgID, created, err := s.Repo.CreateMessage(c.Request().Context(), repoMsg)
 	if err != nil {
 		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to store text message")
 	}
 
-	return c.JSON(http.StatusCreated, map[string]string{
+	return c.JSON(webhookStatus(created), map[string]string{
 		"provider_id": msg.ProviderID,
 		"message_id":  fmt.Sprintf("%d", *msgID),
 	})
@@ -88,42 +91,218 @@ func (s *Server) CreateEmailMessage(c echo.Context) error {
 		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
 	}
 
-	// If the request is for the email endpoint, send the message via the external service.
-	status := repository.MessageStatusSuccess
-	if path := c.Path(); path == "/api/messages/email" {
-		emailMsg.ProviderID, err = s.EmailService.SendMessageWithRetries(emailMsg.From, emailMsg.To, emailMsg.Body, emailMsg.Attachments)
-		if err != nil {
-			log.Errorf("failed to send email via provider: %v", err)
-			status = repository.MessageStatusFailed
-		}
-	}
-
 	// Convert to repository message
-	repoEmailMsg, err := emailMsg.ToRepositoryMessage(status)
+	repoEmailMsg, err := emailMsg.ToRepositoryMessage(repository.MessageStatusReceived)
 	if err != nil {
 		log.Errorf("failed to convert email message: %v", err)
 		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "failed to convert email message")
 	}
 
-	msgID, err := s.Repo.CreateMessage(c.Request().Context(), repoEmailMsg)
+	// If the request is for the email endpoint, queue the message for delivery by the outbox worker.
+	if path := c.Path(); path == "/api/messages/email" {
+		return s.enqueueMessage(c, repoEmailMsg)
+	}
+
+	repoEmailMsg.Direction = repository.DirectionInbound
+
+	msgID, created, err := s.Repo.CreateMessage(c.Request().Context(), repoEmailMsg)
 	if err != nil {
 		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to store email message")
 	}
 
-	return c.JSON(http.StatusCreated, map[string]string{
+	return c.JSON(webhookStatus(created), map[string]string{
 		"provider_id": emailMsg.ProviderID,
 		"message_id":  fmt.Sprintf("%d", *msgID)})
 }
 
+// webhookStatus answers 201 for a newly stored inbound message and 200 for a provider
+// redelivery of one we already have, which tells the provider to stop retrying.
+func webhookStatus(created bool) int {
+	if created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// enqueueMessage stores an outbound message for asynchronous delivery and answers 202.
+func (s *Server) enqueueMessage(c echo.Context, msg repository.Message) error {
+	msgID, err := s.Repo.EnqueueMessage(c.Request().Context(), msg)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to queue message")
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message_id": fmt.Sprintf("%d", *msgID),
+		"status":     repository.MessageStatusQueued,
+	})
+}
+
+// RetryMessage queues a failed outbound message for another delivery attempt.
+func (s *Server) RetryMessage(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		err := errors.New("message ID is required")
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "message ID is required")
+	}
+
+	if err := s.Repo.RetryMessage(c.Request().Context(), id); err != nil {
+		err = conflictError(err, "only failed or undelivered outbound messages can be retried")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to retry message")
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message_id": id,
+		"status":     repository.MessageStatusQueued,
+	})
+}
+
+// GetConversations returns a page of open conversations, most recently active first unless
+// status, sort (created_at or last_activity_at) and order say otherwise. Pass the
+// returned next_cursor as cursor to get the following page. When the request acts for a
+// user each conversation carries that user's unread_count.
 func (s *Server) GetConversations(c echo.Context) error {
-	conversations, err := s.Repo.GetConversations(c.Request().Context())
+	query, err := parseConversationQuery(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid list parameters")
+	}
+	query.UserID = s.userID(c)
+	query.TenantID = s.tenantID(c)
+
+	page, err := s.Repo.GetConversations(c.Request().Context(), query)
 	if err != nil {
 		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get conversations")
 	}
 
-	return c.JSON(http.StatusOK, conversations)
+	return c.JSON(http.StatusOK, page)
+}
+
+// parseConversationQuery reads the pagination, filter and sort query parameters of the
+// conversation list.
+func parseConversationQuery(c echo.Context) (repository.ConversationQuery, error) {
+	var query repository.ConversationQuery
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return query, err
+	}
+	query.Limit = limit
+
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		if query.After, err = repository.DecodeCursor(cursor); err != nil {
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, "invalid cursor")
+		}
+	}
+
+	exact, prefix := c.QueryParam("participant"), c.QueryParam("participant_prefix")
+	if exact != "" && prefix != "" {
+		err := errors.New("participant and participant_prefix cannot be combined")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+	query.Participant = exact
+	if prefix != "" {
+		query.Participant, query.ParticipantPrefix = prefix, true
+	}
+
+	switch commType := c.QueryParam("communication_type"); commType {
+	case "", repository.CommunicationTypePhone, repository.CommunicationTypeEmail:
+		query.CommunicationType = commType
+	default:
+		err := errors.New("communication_type must be phone or email")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	bounds := map[string]*time.Time{"from": &query.From, "to": &query.To}
+	for name, target := range bounds {
+		value := c.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			err := fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+		*target = t
+	}
+
+	if value := c.QueryParam("has_failed"); value != "" {
+		hasFailed, err := strconv.ParseBool(value)
+		if err != nil {
+			err := errors.New("has_failed must be true or false")
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+		query.HasFailed = &hasFailed
+	}
+
+	if query.Statuses, err = parseStatuses(c); err != nil {
+		return query, err
+	}
+
+	query.Tags = c.QueryParams()["tag"]
+
+	switch assignee := c.QueryParam("assignee"); assignee {
+	case "":
+	case "none":
+		query.Unassigned = true
+	default:
+		agentID, err := strconv.ParseInt(assignee, 10, 64)
+		if err != nil || agentID < 1 {
+			err := errors.New("assignee must be an agent ID or none")
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+		query.AssigneeID = agentID
+	}
+
+	switch sort := c.QueryParam("sort"); sort {
+	case "", repository.SortByLastActivity, repository.SortByCreatedAt:
+		query.SortBy = sort
+	default:
+		err := errors.New("sort must be created_at or last_activity_at")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	switch order := c.QueryParam("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		err := errors.New("order must be asc or desc")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	return query, nil
+}
+
+// conflictError turns apperrors.DBErrorConflict into a 409 telling the client message.
+// Other errors are returned unchanged.
+func conflictError(err error, message string) error {
+	if errors.Is(err, apperrors.DBErrorConflict) {
+		return apperrors.NewHTTPError(err, http.StatusConflict, message)
+	}
+	return err
 }
 
+// parseLimit reads the optional limit query parameter, defaulting to
+// repository.DefaultPageSize.
+func parseLimit(c echo.Context) (int, error) {
+	value := c.QueryParam("limit")
+	if value == "" {
+		return repository.DefaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > repository.MaxPageSize {
+		err := fmt.Errorf("limit must be between 1 and %d", repository.MaxPageSize)
+		return 0, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	return limit, nil
+}
+
+// GetConversationByID returns a conversation with a page of its messages. Pages are
+// chronological unless order=desc; before/after take a message ID to page from, and
+// direction=inbound|outbound keeps only customer replies or our own sends. Items
+// interleaves the messages with the conversation's internal notes.
 func (s *Server) GetConversationByID(c echo.Context) error {
 	id := c.Param("id")
 	if id == "" {
@@ -131,10 +310,65 @@ func (s *Server) GetConversationByID(c echo.Context) error {
 		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "conversation ID is required")
 	}
 
-	conversation, err := s.Repo.GetConversationByID(c.Request().Context(), id)
+	query, err := parseMessageQuery(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusBadRequest, "invalid pagination parameters")
+	}
+
+	conversation, err := s.Repo.GetConversationByID(c.Request().Context(), id, query)
 	if err != nil {
 		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get conversation")
 	}
 
 	return c.JSON(http.StatusOK, conversation)
 }
+
+// parseMessageQuery reads the limit, before, after, order and direction query parameters
+// of the conversation messages endpoint.
+func parseMessageQuery(c echo.Context) (repository.MessageQuery, error) {
+	var query repository.MessageQuery
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return query, err
+	}
+	query.Limit = limit
+
+	cursors := map[string]*int64{"before": &query.Before, "after": &query.After}
+	for name, target := range cursors {
+		value := c.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 1 {
+			err := fmt.Errorf("%s must be a message ID", name)
+			return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+		}
+		*target = id
+	}
+
+	if query.Before != 0 && query.After != 0 {
+		err := errors.New("before and after cannot be combined")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	switch order := c.QueryParam("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		err := errors.New("order must be asc or desc")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	switch direction := c.QueryParam("direction"); direction {
+	case "", repository.DirectionInbound, repository.DirectionOutbound:
+		query.Direction = direction
+	default:
+		err := errors.New("direction must be inbound or outbound")
+		return query, apperrors.NewHTTPError(err, http.StatusBadRequest, err.Error())
+	}
+
+	return query, nil
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	HeaderTwilioSignature    = "X-Twilio-Signature"
+	HeaderSendGridSignature  = "X-Twilio-Email-Event-Webhook-Signature"
+	HeaderSendGridTimestamp  = "X-Twilio-Email-Event-Webhook-Timestamp"
+	DefaultWebhookMaxSkew    = 5 * time.Minute
+	twilioBodyHashQueryParam = "bodySHA256"
+)
+
+// WebhookVerifier authenticates provider webhook calls before they reach the handlers.
+// A nil *WebhookVerifier lets every call through, which is only meant for tests.
+type WebhookVerifier struct {
+	// TwilioAuthToken is the account auth token Twilio signs requests with.
+	TwilioAuthToken string
+	// SendGridPublicKey verifies SendGrid's signed event webhook.
+	SendGridPublicKey *ecdsa.PublicKey
+	// PublicURL is the scheme and host providers call, e.g. https://api.example.com.
+	// Twilio signs the URL it called, which differs from the request URL behind a proxy.
+	// When empty the URL is rebuilt from the request.
+	PublicURL string
+	// MaxSkew bounds how far a signed timestamp may be from now, and how long seen
+	// signatures are remembered to reject replays.
+	MaxSkew time.Duration
+	Now     func() time.Time
+
+	// Seen signatures are kept in memory, so replays are only caught by the instance
+	// that saw the original call. Deployments running several instances behind a load
+	// balancer rely on the timestamp window and on handlers being idempotent.
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// expiries holds the seen signatures in the order they were remembered.
+	expiries []seenSignature
+}
+
+type seenSignature struct {
+	signature string
+	expiresAt time.Time
+}
+
+// NewWebhookVerifier creates a verifier from the Twilio auth token and the base64 (or
+// PEM) encoded public key shown in SendGrid's signed event webhook settings.
+func NewWebhookVerifier(twilioAuthToken, sendGridPublicKey, publicURL string, maxSkew time.Duration) (*WebhookVerifier, error) {
+	key, err := ParseSendGridPublicKey(sendGridPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookVerifier{
+		TwilioAuthToken:   twilioAuthToken,
+		SendGridPublicKey: key,
+		PublicURL:         strings.TrimSuffix(publicURL, "/"),
+		MaxSkew:           maxSkew,
+		Now:               time.Now,
+		seen:              make(map[string]time.Time),
+	}, nil
+}
+
+// ParseSendGridPublicKey parses an ECDSA public key in PKIX form, either PEM encoded or
+// as the bare base64 string SendGrid displays.
+func ParseSendGridPublicKey(value string) (*ecdsa.PublicKey, error) {
+	value = strings.TrimSpace(value)
+
+	var der []byte
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sendgrid public key encoding: %w", err)
+		}
+		der = decoded
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sendgrid public key: %w", err)
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sendgrid public key is not an ECDSA key")
+	}
+
+	return ecdsaKey, nil
+}
+
+// TwilioSignature computes the X-Twilio-Signature for a request to fullURL carrying the
+// given POST parameters: base64(HMAC-SHA1(authToken, URL + sorted key/value pairs)).
+func TwilioSignature(authToken, fullURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payload strings.Builder
+	payload.WriteString(fullURL)
+	for _, key := range keys {
+		values := append([]string(nil), params[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			payload.WriteString(key)
+			payload.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(payload.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Twilio is Echo middleware rejecting requests without a valid X-Twilio-Signature.
+// Form posts are signed over the URL and their parameters; other bodies are signed
+// over the URL only and must match the bodySHA256 query parameter Twilio adds to it.
+func (v *WebhookVerifier) Twilio(next echo.HandlerFunc) echo.HandlerFunc {
+	if v == nil {
+		return next
+	}
+
+	return func(c echo.Context) error {
+		body, err := readBody(c)
+		if err != nil {
+			return v.reject(c, "twilio", "failed to read request body")
+		}
+
+		signature := c.Request().Header.Get(HeaderTwilioSignature)
+		if signature == "" {
+			return v.reject(c, "twilio", "missing signature header")
+		}
+
+		params := url.Values{}
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+			if params, err = url.ParseQuery(string(body)); err != nil {
+				return v.reject(c, "twilio", "malformed form body")
+			}
+		} else {
+			bodyHash := sha256.Sum256(body)
+			expected := c.Request().URL.Query().Get(twilioBodyHashQueryParam)
+			if !hmac.Equal([]byte(expected), []byte(hex.EncodeToString(bodyHash[:]))) {
+				return v.reject(c, "twilio", "body does not match bodySHA256")
+			}
+		}
+
+		expected := TwilioSignature(v.TwilioAuthToken, v.requestURL(c), params)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+			return v.reject(c, "twilio", "signature mismatch")
+		}
+
+		return next(c)
+	}
+}
+
+// SendGrid is Echo middleware verifying SendGrid's signed event webhook: an ECDSA
+// signature over the timestamp header followed by the raw body. Timestamps outside
+// MaxSkew and signatures already seen within it are rejected as replays.
+func (v *WebhookVerifier) SendGrid(next echo.HandlerFunc) echo.HandlerFunc {
+	if v == nil {
+		return next
+	}
+
+	return func(c echo.Context) error {
+		body, err := readBody(c)
+		if err != nil {
+			return v.reject(c, "sendgrid", "failed to read request body")
+		}
+
+		signature := c.Request().Header.Get(HeaderSendGridSignature)
+		timestamp := c.Request().Header.Get(HeaderSendGridTimestamp)
+		if signature == "" || timestamp == "" {
+			return v.reject(c, "sendgrid", "missing signature headers")
+		}
+
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return v.reject(c, "sendgrid", "malformed timestamp")
+		}
+
+		signedAt := time.Unix(seconds, 0)
+		if skew := v.Now().Sub(signedAt); skew > v.MaxSkew || skew < -v.MaxSkew {
+			return v.reject(c, "sendgrid", "timestamp outside the allowed window")
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return v.reject(c, "sendgrid", "malformed signature")
+		}
+
+		digest := sha256.Sum256(append([]byte(timestamp), body...))
+		if v.SendGridPublicKey == nil || !ecdsa.VerifyASN1(v.SendGridPublicKey, digest[:], decoded) {
+			return v.reject(c, "sendgrid", "signature mismatch")
+		}
+
+		if !v.remember(signature, signedAt.Add(v.MaxSkew)) {
+			return v.reject(c, "sendgrid", "replayed signature")
+		}
+
+		return next(c)
+	}
+}
+
+// remember records a signature until it expires and reports whether it was new.
+func (v *WebhookVerifier) remember(signature string, expiresAt time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+
+	// Expiries are not strictly ordered, as signatures are remembered until their signed
+	// timestamp plus MaxSkew, but an entry lingers at most 2*MaxSkew past its expiry
+	// behind an older one. Each request only evicts from the front.
+	now := v.Now()
+	for len(v.expiries) > 0 && now.After(v.expiries[0].expiresAt) {
+		// The signature may have been remembered again since, with a later expiry.
+		if oldest := v.expiries[0]; v.seen[oldest.signature].Equal(oldest.expiresAt) {
+			delete(v.seen, oldest.signature)
+		}
+		v.expiries = v.expiries[1:]
+	}
+
+	if expiry, replayed := v.seen[signature]; replayed && !now.After(expiry) {
+		return false
+	}
+	v.seen[signature] = expiresAt
+	v.expiries = append(v.expiries, seenSignature{signature: signature, expiresAt: expiresAt})
+	return true
+}
+
+// requestURL returns the URL the provider called, including the query string.
+func (v *WebhookVerifier) requestURL(c echo.Context) string {
+	base := v.PublicURL
+	if base == "" {
+		base = c.Scheme() + "://" + c.Request().Host
+	}
+	return base + c.Request().URL.RequestURI()
+}
+
+func (v *WebhookVerifier) reject(c echo.Context, provider, reason string) error {
+	log.Warnf("rejected %s webhook %s from %s: %s", provider, c.Request().URL.Path, c.RealIP(), reason)
+	err := apperrors.NewHTTPError(errors.New(reason), http.StatusUnauthorized, "invalid webhook signature")
+	return apperrors.ApiErrorResponse(c, err, http.StatusUnauthorized, "invalid webhook signature")
+}
+
+// readBody reads the raw request body and puts it back for the handler.
+func readBody(c echo.Context) ([]byte, error) {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
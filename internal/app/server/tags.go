@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"hatchapp/internal/pkg/apperrors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateTag creates a tag of the request's tenant. Tag names are unique per tenant
+// regardless of case.
+func (s *Server) CreateTag(c echo.Context) error {
+	input, err := s.bindTag(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	tag, err := s.Repo.CreateTag(c.Request().Context(), s.tenantID(c), input.Name)
+	if err != nil {
+		err = conflictError(err, "a tag with this name already exists")
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to create tag")
+	}
+
+	return c.JSON(http.StatusCreated, tag)
+}
+
+// GetTags returns the tenant's tags with the number of conversations and messages carrying
+// each.
+func (s *Server) GetTags(c echo.Context) error {
+	tags, err := s.Repo.GetTags(c.Request().Context(), s.tenantID(c))
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to get tags")
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// DeleteTag deletes a tag and removes it from every conversation and message.
+func (s *Server) DeleteTag(c echo.Context) error {
+	if err := s.Repo.DeleteTag(c.Request().Context(), s.tenantID(c), c.Param("id")); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to delete tag")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TagConversation puts the tag named in the body on a conversation, creating the tag if
+// needed, and returns the conversation's tags.
+func (s *Server) TagConversation(c echo.Context) error {
+	input, err := s.bindTag(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	tags, err := s.Repo.TagConversation(c.Request().Context(), s.tenantID(c), c.Param("id"), input.Name)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to tag conversation")
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// UntagConversation removes a tag from a conversation.
+func (s *Server) UntagConversation(c echo.Context) error {
+	if err := s.Repo.UntagConversation(c.Request().Context(), s.tenantID(c), c.Param("id"), c.Param("tagID")); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to untag conversation")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TagMessage puts the tag named in the body on a message, creating the tag if needed,
+// and returns the message's tags.
+func (s *Server) TagMessage(c echo.Context) error {
+	input, err := s.bindTag(c)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	tags, err := s.Repo.TagMessage(c.Request().Context(), s.tenantID(c), c.Param("id"), input.Name)
+	if err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to tag message")
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// UntagMessage removes a tag from a message.
+func (s *Server) UntagMessage(c echo.Context) error {
+	if err := s.Repo.UntagMessage(c.Request().Context(), s.tenantID(c), c.Param("id"), c.Param("tagID")); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to untag message")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) bindTag(c echo.Context) (*TagInput, error) {
+	var input TagInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return nil, apperrors.NewHTTPError(err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	if err := s.Validate(&input); err != nil {
+		return nil, err
+	}
+
+	return &input, nil
+}
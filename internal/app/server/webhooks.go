@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// twilioStatuses maps Twilio MessageStatus values onto repository statuses. Statuses
+// that don't move an outbound message forward (queued, accepted, sending...) are
+// acknowledged and ignored.
+var twilioStatuses = map[string]string{
+	"sent":        repository.MessageStatusSent,
+	"delivered":   repository.MessageStatusDelivered,
+	"undelivered": repository.MessageStatusUndelivered,
+	"failed":      repository.MessageStatusFailed,
+	"canceled":    repository.MessageStatusFailed,
+	"read":        repository.MessageStatusRead,
+}
+
+// sendGridEvents maps SendGrid event webhook types onto repository statuses. Engagement
+// events other than opens and clicks (unsubscribes, spam reports...) are ignored.
+var sendGridEvents = map[string]string{
+	"processed": repository.MessageStatusSent,
+	"delivered": repository.MessageStatusDelivered,
+	"bounce":    repository.MessageStatusUndelivered,
+	"dropped":   repository.MessageStatusFailed,
+	"open":      repository.MessageStatusRead,
+	"click":     repository.MessageStatusRead,
+}
+
+// SendGridEvent is a single entry of a SendGrid event webhook batch.
+type SendGridEvent struct {
+	Event       string `json:"event"`
+	SGMessageID string `json:"sg_message_id"`
+	Reason      string `json:"reason"`
+	Status      string `json:"status"`
+}
+
+// providerMessageID strips the per-recipient suffix SendGrid appends to sg_message_id,
+// leaving the X-Message-Id returned when the message was sent.
+func (e SendGridEvent) providerMessageID() string {
+	if i := strings.Index(e.SGMessageID, ".filter"); i >= 0 {
+		return e.SGMessageID[:i]
+	}
+	return e.SGMessageID
+}
+
+// TwilioStatusCallback applies a Twilio message status callback to the message whose
+// provider_id matches MessageSid.
+func (s *Server) TwilioStatusCallback(c echo.Context) error {
+	providerID := c.FormValue("MessageSid")
+	twilioStatus := c.FormValue("MessageStatus")
+	if providerID == "" || twilioStatus == "" {
+		err := apperrors.NewHTTPError(errors.New("missing MessageSid or MessageStatus"),
+			http.StatusUnprocessableEntity, "MessageSid and MessageStatus are required")
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid request input")
+	}
+
+	status, ok := twilioStatuses[twilioStatus]
+	if !ok {
+		log.Infof("ignoring twilio status %q for message %s", twilioStatus, providerID)
+		return c.JSON(http.StatusOK, map[string]string{
+			"provider_id": providerID,
+			"status":      "ignored",
+		})
+	}
+
+	errorCode := c.FormValue("ErrorCode")
+	var errorMessage string
+	if errorCode != "" {
+		errorMessage = fmt.Sprintf("twilio reported error %s", errorCode)
+	}
+
+	msgID, err := s.Repo.UpdateMessageStatusByProviderID(c.Request().Context(), providerID, status, errorCode, errorMessage)
+	if err != nil {
+		err = conflictError(err, fmt.Sprintf("message cannot move to status %s", status))
+		return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to apply status callback")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"provider_id": providerID,
+		"message_id":  fmt.Sprintf("%d", *msgID),
+		"status":      status,
+	})
+}
+
+// SendGridEventWebhook applies a batch of SendGrid delivery events. SendGrid retries the
+// whole batch on any non-2xx answer, so events for unknown messages or out-of-order
+// events are skipped and reported in the response instead of failing the request.
+func (s *Server) SendGridEventWebhook(c echo.Context) error {
+	var events []SendGridEvent
+	if err := json.NewDecoder(c.Request().Body).Decode(&events); err != nil {
+		return apperrors.ApiErrorResponse(c, err, http.StatusUnprocessableEntity, "invalid payload: failed to decode json")
+	}
+
+	var applied, ignored, rejected int
+	for _, event := range events {
+		status, ok := sendGridEvents[event.Event]
+		if !ok || event.SGMessageID == "" {
+			ignored++
+			continue
+		}
+
+		providerID := event.providerMessageID()
+		_, err := s.Repo.UpdateMessageStatusByProviderID(c.Request().Context(), providerID, status, event.Status, event.Reason)
+		switch {
+		case err == nil:
+			applied++
+		case errors.Is(err, apperrors.DBErrorNotFound), errors.Is(err, apperrors.DBErrorConflict):
+			log.Warnf("rejected sendgrid %s event for message %s: %v", event.Event, providerID, err)
+			rejected++
+		default:
+			return apperrors.ApiErrorResponse(c, err, http.StatusInternalServerError, "failed to apply email events")
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{
+		"applied":  applied,
+		"ignored":  ignored,
+		"rejected": rejected,
+	})
+}
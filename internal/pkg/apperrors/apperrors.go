@@ -30,6 +30,11 @@ func ApiErrorResponse(eCtx echo.Context, errInput error, statusCode int, message
 			return eCtx.JSON(http.StatusNotFound, map[string]string{"error": "Resource Not Found"})
 		}
 
+		if errors.Is(dbErr, DBErrorConflict) {
+			log.Warnf("database resource conflict: %s", message)
+			return eCtx.JSON(http.StatusConflict, map[string]string{"error": "Conflict"})
+		}
+
 		log.Errorf("database error occurred: %s", dbErr.Err)
 		return eCtx.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
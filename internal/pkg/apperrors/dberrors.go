@@ -7,6 +7,11 @@ var DBErrorNotFound = NewDBError(
 	"The requested database resource was not found",
 )
 
+var DBErrorConflict = NewDBError(
+	errors.New("resource conflict"),
+	"The request conflicts with the current state of the resource",
+)
+
 type DBError struct {
 	Err     error
 	Message string
@@ -19,3 +24,7 @@ func NewDBError(err error, message string) *DBError {
 func (e *DBError) Error() string {
 	return e.Message
 }
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
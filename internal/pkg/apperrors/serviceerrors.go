@@ -1,14 +1,32 @@
 package apperrors
 
 type ServiceError struct {
-	Err     error
-	Message string
+	Err        error
+	Message    string
+	Code       string // provider specific error code, if any
+	StatusCode int    // HTTP status code returned by the provider, if any
+	Retryable  bool
 }
 
 func NewServiceError(err error, message string) *ServiceError {
 	return &ServiceError{Err: err, Message: message}
 }
 
+// NewProviderError creates a ServiceError describing an error response from a messaging provider.
+func NewProviderError(err error, statusCode int, code, message string, retryable bool) *ServiceError {
+	return &ServiceError{
+		Err:        err,
+		Message:    message,
+		Code:       code,
+		StatusCode: statusCode,
+		Retryable:  retryable,
+	}
+}
+
 func (e *ServiceError) Error() string {
 	return e.Message
 }
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
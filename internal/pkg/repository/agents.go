@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+)
+
+// CreateAgent stores an agent. It returns apperrors.DBErrorConflict if another agent has
+// the same email.
+func (r *PostgresRepository) CreateAgent(ctx context.Context, agent Agent) (*Agent, error) {
+	const insertAgentQuery = `
+		INSERT INTO agents (name, email, available)
+		VALUES ($1, $2, $3)
+		RETURNING id, name, email, available, created_at, updated_at
+	`
+	created, err := scanAgent(r.db.QueryRowContext(ctx, insertAgentQuery, agent.Name, agent.Email, agent.Available))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, apperrors.DBErrorConflict
+		}
+		return nil, apperrors.NewDBError(err, "failed to insert agent")
+	}
+
+	return created, nil
+}
+
+// GetAgents returns all agents, oldest first.
+func (r *PostgresRepository) GetAgents(ctx context.Context) ([]Agent, error) {
+	const agentsQuery = `
+		SELECT id, name, email, available, created_at, updated_at
+		FROM agents
+		ORDER BY id
+	`
+	rows, err := r.db.QueryContext(ctx, agentsQuery)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to query agents")
+	}
+	defer rows.Close()
+
+	agents := []Agent{}
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan agent row")
+		}
+		agents = append(agents, *agent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return agents, nil
+}
+
+// GetAgentByID returns an agent.
+func (r *PostgresRepository) GetAgentByID(ctx context.Context, id string) (*Agent, error) {
+	const agentQuery = `
+		SELECT id, name, email, available, created_at, updated_at
+		FROM agents
+		WHERE id = $1
+	`
+	agent, err := scanAgent(r.db.QueryRowContext(ctx, agentQuery, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query agent %s", id))
+	}
+
+	return agent, nil
+}
+
+// UpdateAgent replaces an agent's name, email and availability. It returns
+// apperrors.DBErrorConflict if another agent has the same email.
+func (r *PostgresRepository) UpdateAgent(ctx context.Context, id string, agent Agent) (*Agent, error) {
+	const updateAgentQuery = `
+		UPDATE agents
+		SET name = $2, email = $3, available = $4, updated_at = now()
+		WHERE id = $1
+		RETURNING id, name, email, available, created_at, updated_at
+	`
+	updated, err := scanAgent(r.db.QueryRowContext(ctx, updateAgentQuery, id, agent.Name, agent.Email, agent.Available))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		if isUniqueViolation(err) {
+			return nil, apperrors.DBErrorConflict
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to update agent %s", id))
+	}
+
+	return updated, nil
+}
+
+// AssignConversation assigns a conversation to an agent, or unassigns it when agentID is
+// nil, and records the change in its assignment history. assignedBy identifies the user
+// making the change. Assigning a conversation to its current assignee is a no-op.
+func (r *PostgresRepository) AssignConversation(ctx context.Context, id string, agentID *int64, assignedBy string) (*Conversation, error) {
+	var conversation *Conversation
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var conversationID int64
+		var current sql.NullInt64
+		findConversationQuery := `SELECT id, assignee_id FROM conversations WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, findConversationQuery, id).Scan(&conversationID, &current); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to find conversation %s", id))
+		}
+
+		unchanged := (agentID == nil && !current.Valid) || (agentID != nil && current.Valid && *agentID == current.Int64)
+		if !unchanged {
+			if agentID != nil {
+				touchAgentQuery := `UPDATE agents SET last_assigned_at = now() WHERE id = $1`
+				result, err := tx.ExecContext(ctx, touchAgentQuery, *agentID)
+				if err != nil {
+					return apperrors.NewDBError(err, fmt.Sprintf("failed to update agent %d", *agentID))
+				}
+				if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+					return apperrors.DBErrorNotFound
+				}
+			}
+
+			if err := assign(ctx, tx, conversationID, agentID, assignedBy); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		conversation, err = getConversationSummary(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// GetAssignmentHistory returns the changes of a conversation's assignee, oldest first.
+func (r *PostgresRepository) GetAssignmentHistory(ctx context.Context, id string) ([]Assignment, error) {
+	var exists bool
+	const conversationQuery = `SELECT EXISTS (SELECT 1 FROM conversations WHERE id = $1)`
+	if err := r.db.QueryRowContext(ctx, conversationQuery, id).Scan(&exists); err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query conversation %s", id))
+	}
+	if !exists {
+		return nil, apperrors.DBErrorNotFound
+	}
+
+	const historyQuery = `
+		SELECT agent_id, assigned_by, created_at
+		FROM conversation_assignments
+		WHERE conversation_id = $1
+		ORDER BY created_at, id
+	`
+	rows, err := r.db.QueryContext(ctx, historyQuery, id)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query assignment history of conversation %s", id))
+	}
+	defer rows.Close()
+
+	history := []Assignment{}
+	for rows.Next() {
+		var (
+			assignment Assignment
+			agentID    sql.NullInt64
+			assignedBy sql.NullString
+			createdAt  time.Time
+		)
+		if err := rows.Scan(&agentID, &assignedBy, &createdAt); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan assignment row")
+		}
+		if agentID.Valid {
+			assignment.AgentID = &agentID.Int64
+		}
+		assignment.AssignedBy = assignedBy.String
+		assignment.CreatedAt = createdAt.Format(time.RFC3339)
+		history = append(history, assignment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return history, nil
+}
+
+// assignNewConversation assigns the conversation messageID started to the next available
+// agent. Conversations the message did not start, or that were assigned already, are left
+// alone.
+func (r *PostgresRepository) assignNewConversation(ctx context.Context, messageID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	// A new conversation is stored together with its first message, so the message
+	// started the conversation when no message has a lower ID.
+	var conversationID int64
+	findConversationQuery := `
+		SELECT c.id
+		FROM conversations c
+		JOIN messages m ON m.conversation_id = c.id
+		WHERE m.id = $1
+		  AND c.assignee_id IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM messages earlier WHERE earlier.conversation_id = c.id AND earlier.id < m.id)
+		  AND NOT EXISTS (SELECT 1 FROM conversation_assignments ca WHERE ca.conversation_id = c.id)
+		FOR UPDATE OF c
+	`
+	if err := tx.QueryRowContext(ctx, findConversationQuery, messageID).Scan(&conversationID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to find the conversation of message %d", messageID))
+	}
+
+	if err := assignNextAgent(ctx, tx, conversationID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// assignNextAgent assigns a new conversation to the available agent who has waited
+// longest for one. Nothing happens when no agent is available. An agent another
+// conversation is being assigned to right now is skipped rather than waited for.
+func assignNextAgent(ctx context.Context, tx *sql.Tx, conversationID int64) error {
+	const nextAgentQuery = `
+		UPDATE agents
+		SET last_assigned_at = now()
+		WHERE id = (
+			SELECT id
+			FROM agents
+			WHERE available
+			ORDER BY last_assigned_at NULLS FIRST, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+	var agentID int64
+	if err := tx.QueryRowContext(ctx, nextAgentQuery).Scan(&agentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return apperrors.NewDBError(err, "failed to pick an agent")
+	}
+
+	return assign(ctx, tx, conversationID, &agentID, "")
+}
+
+// assign sets a conversation's assignee and records the change.
+func assign(ctx context.Context, tx *sql.Tx, conversationID int64, agentID *int64, assignedBy string) error {
+	updateAssigneeQuery := `UPDATE conversations SET assignee_id = $2 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, updateAssigneeQuery, conversationID, agentID); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to assign conversation %d", conversationID))
+	}
+
+	insertAssignmentQuery := `
+		INSERT INTO conversation_assignments (conversation_id, agent_id, assigned_by)
+		VALUES ($1, $2, $3)
+	`
+	if _, err := tx.ExecContext(ctx, insertAssignmentQuery, conversationID, agentID, nullString(assignedBy)); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to record assignment of conversation %d", conversationID))
+	}
+
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAgent(row rowScanner) (*Agent, error) {
+	var (
+		agent                Agent
+		createdAt, updatedAt time.Time
+	)
+	if err := row.Scan(&agent.ID, &agent.Name, &agent.Email, &agent.Available, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	agent.CreatedAt = createdAt.Format(time.RFC3339)
+	agent.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &agent, nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CreateAPIKey stores an API key by the hash of its secret.
+func (r *PostgresRepository) CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error) {
+	const insertKeyQuery = `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, user_id, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, name, prefix, scopes, user_id, tenant_id, created_at, last_used_at, revoked_at
+	`
+	created, err := scanAPIKey(r.db.QueryRowContext(ctx, insertKeyQuery,
+		key.Name, key.Prefix, keyHash, pq.Array(key.Scopes), nullString(key.UserID), key.TenantID))
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to insert API key")
+	}
+
+	return created, nil
+}
+
+// GetAPIKeys returns all API keys, including revoked ones, oldest first.
+func (r *PostgresRepository) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	const keysQuery = `
+		SELECT id, name, prefix, scopes, user_id, tenant_id, created_at, last_used_at, revoked_at
+		FROM api_keys
+		ORDER BY id
+	`
+	rows, err := r.db.QueryContext(ctx, keysQuery)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to query API keys")
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan API key row")
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey stops an API key from authenticating requests. Revoking a revoked key
+// keeps its original revocation time.
+func (r *PostgresRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	const revokeKeyQuery = `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, revokeKeyQuery, id)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to revoke API key %s", id))
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperrors.DBErrorNotFound
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey returns the unrevoked API key with the given hash and records that
+// it was used. Usage is recorded at most once a minute per key, to keep lookups cheap.
+// It returns apperrors.DBErrorNotFound for unknown and revoked keys.
+func (r *PostgresRepository) AuthenticateAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	const authenticateQuery = `
+		WITH key AS (
+			SELECT id, name, prefix, scopes, user_id, tenant_id, created_at, last_used_at, revoked_at
+			FROM api_keys
+			WHERE key_hash = $1 AND revoked_at IS NULL
+		), touched AS (
+			UPDATE api_keys
+			SET last_used_at = now()
+			WHERE id IN (SELECT id FROM key)
+			  AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+		)
+		SELECT id, name, prefix, scopes, user_id, tenant_id, created_at, last_used_at, revoked_at
+		FROM key
+	`
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, authenticateQuery, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, "failed to authenticate API key")
+	}
+
+	return key, nil
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var (
+		key        APIKey
+		scopes     pq.StringArray
+		userID     sql.NullString
+		createdAt  time.Time
+		lastUsedAt sql.NullTime
+		revokedAt  sql.NullTime
+	)
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &userID, &key.TenantID, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = scopes
+	key.UserID = userID.String
+	key.CreatedAt = createdAt.Format(time.RFC3339)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = lastUsedAt.Time.Format(time.RFC3339)
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = revokedAt.Time.Format(time.RFC3339)
+	}
+	return &key, nil
+}
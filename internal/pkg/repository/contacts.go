@@ -0,0 +1,555 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// CreateContact stores a contact and links its identifiers to it. It returns
+// apperrors.DBErrorConflict if an identifier already belongs to another contact.
+func (r *PostgresRepository) CreateContact(ctx context.Context, contact Contact) (*Contact, error) {
+	var created *Contact
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		metadata, err := encodeMetadata(contact.Metadata)
+		if err != nil {
+			return err
+		}
+
+		var contactID int64
+		insertContactQuery := `INSERT INTO contacts (name, metadata) VALUES ($1, $2) RETURNING id`
+		if err := tx.QueryRowContext(ctx, insertContactQuery, contact.Name, metadata).Scan(&contactID); err != nil {
+			return apperrors.NewDBError(err, "failed to insert contact")
+		}
+
+		if err := assignIdentifiers(ctx, tx, contactID, contact.Identifiers); err != nil {
+			return err
+		}
+
+		created, err = getContact(ctx, tx, contactID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// GetContacts returns a page of contacts, most recently created first.
+func (r *PostgresRepository) GetContacts(ctx context.Context, query ContactQuery) (*ContactPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	var afterTime sql.NullTime
+	var afterID int64
+	if query.After != nil {
+		afterTime = sql.NullTime{Time: query.After.Time, Valid: true}
+		afterID = query.After.ID
+	}
+
+	// One extra contact is fetched to learn whether there is a next page.
+	const contactsQuery = `
+		SELECT id, name, metadata, merged_into_id, created_at, updated_at
+		FROM contacts
+		WHERE merged_into_id IS NULL
+		  AND ($1::timestamptz IS NULL OR (created_at, id) < ($1, $2))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, contactsQuery, afterTime, afterID, limit+1)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to query contacts")
+	}
+	defer rows.Close()
+
+	page := &ContactPage{Contacts: make([]Contact, 0, limit)}
+	var lastCreatedAt time.Time
+	for rows.Next() {
+		contact, createdAt, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Contacts) == limit {
+			last := page.Contacts[limit-1]
+			page.NextCursor = Cursor{Time: lastCreatedAt, ID: last.ID}.Encode()
+			break
+		}
+		page.Contacts = append(page.Contacts, *contact)
+		lastCreatedAt = createdAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	if err := loadIdentifiers(ctx, r.db, page.Contacts); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// GetContactByID returns a contact with its identifiers, including those of the
+// contacts merged into it.
+func (r *PostgresRepository) GetContactByID(ctx context.Context, id string) (*Contact, error) {
+	return getContact(ctx, r.db, id)
+}
+
+// UpdateContact replaces a contact's name, metadata and identifiers. Identifiers no
+// longer listed are unlinked from the contact.
+func (r *PostgresRepository) UpdateContact(ctx context.Context, id string, contact Contact) (*Contact, error) {
+	var updated *Contact
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		metadata, err := encodeMetadata(contact.Metadata)
+		if err != nil {
+			return err
+		}
+
+		var contactID int64
+		updateContactQuery := `
+			UPDATE contacts
+			SET name = $2, metadata = $3, updated_at = now()
+			WHERE id = $1
+			RETURNING id
+		`
+		if err := tx.QueryRowContext(ctx, updateContactQuery, id, contact.Name, metadata).Scan(&contactID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to update contact %s", id))
+		}
+
+		identifiers := make([]string, 0, len(contact.Identifiers))
+		for _, comm := range contact.Identifiers {
+			identifiers = append(identifiers, comm.Identifier)
+		}
+
+		unlinkQuery := `
+			UPDATE communications
+			SET contact_id = NULL
+			WHERE contact_id = $1 AND NOT (identifier = ANY($2))
+		`
+		if _, err := tx.ExecContext(ctx, unlinkQuery, contactID, pq.Array(identifiers)); err != nil {
+			return apperrors.NewDBError(err, "failed to unlink contact identifiers")
+		}
+
+		if err := assignIdentifiers(ctx, tx, contactID, contact.Identifiers); err != nil {
+			return err
+		}
+
+		updated, err = getContact(ctx, tx, contactID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteContact deletes a contact. Its identifiers and the contacts merged into it are
+// kept but no longer belong to any contact.
+func (r *PostgresRepository) DeleteContact(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM contacts WHERE id = $1`, id)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to delete contact %s", id))
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperrors.DBErrorNotFound
+	}
+
+	return nil
+}
+
+// MergeContacts merges the source contact into the target contact: the target gains
+// the identities, and therefore the conversations, of the source. Contacts previously
+// merged into the source are moved to the target. It returns apperrors.DBErrorConflict
+// when either contact is itself merged, or when they are the same contact.
+func (r *PostgresRepository) MergeContacts(ctx context.Context, targetID, sourceID string) (*Contact, error) {
+	var merged *Contact
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		lockQuery := `
+			SELECT id, merged_into_id
+			FROM contacts
+			WHERE id IN ($1, $2)
+			ORDER BY id
+			FOR UPDATE
+		`
+		rows, err := tx.QueryContext(ctx, lockQuery, targetID, sourceID)
+		if err != nil {
+			return apperrors.NewDBError(err, "failed to lock contacts")
+		}
+
+		var found int
+		var alreadyMerged bool
+		for rows.Next() {
+			var id int64
+			var mergedInto sql.NullInt64
+			if err := rows.Scan(&id, &mergedInto); err != nil {
+				rows.Close()
+				return apperrors.NewDBError(err, "failed to scan contact row")
+			}
+			found++
+			alreadyMerged = alreadyMerged || mergedInto.Valid
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return apperrors.NewDBError(err, "encountered error while iterating database rows")
+		}
+
+		if targetID == sourceID || alreadyMerged {
+			return apperrors.DBErrorConflict
+		}
+		if found != 2 {
+			return apperrors.DBErrorNotFound
+		}
+
+		mergeQuery := `
+			UPDATE contacts
+			SET merged_into_id = $1, updated_at = now()
+			WHERE id = $2 OR merged_into_id = $2
+		`
+		if _, err := tx.ExecContext(ctx, mergeQuery, targetID, sourceID); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to merge contact %s into %s", sourceID, targetID))
+		}
+
+		merged, err = getContact(ctx, tx, targetID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// UnmergeContact splits a merged contact off again, taking back its own identities. It
+// returns apperrors.DBErrorConflict when the contact is not merged.
+func (r *PostgresRepository) UnmergeContact(ctx context.Context, id string) (*Contact, error) {
+	var unmerged *Contact
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var mergedInto sql.NullInt64
+		findContactQuery := `SELECT merged_into_id FROM contacts WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, findContactQuery, id).Scan(&mergedInto); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to find contact %s", id))
+		}
+
+		if !mergedInto.Valid {
+			return apperrors.DBErrorConflict
+		}
+
+		unmergeQuery := `UPDATE contacts SET merged_into_id = NULL, updated_at = now() WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, unmergeQuery, id); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to unmerge contact %s", id))
+		}
+
+		var err error
+		unmerged, err = getContact(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return unmerged, nil
+}
+
+// GetContactTimeline returns a page of the messages of every conversation the contact
+// takes part in through any of its identities, newest first. A merged contact's
+// timeline is the one of the contact it is merged into.
+func (r *PostgresRepository) GetContactTimeline(ctx context.Context, id string, query TimelineQuery) (*TimelinePage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	var contactID int64
+	findContactQuery := `SELECT COALESCE(merged_into_id, id) FROM contacts WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, findContactQuery, id).Scan(&contactID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to find contact %s", id))
+	}
+
+	var beforeTime sql.NullTime
+	var beforeID int64
+	if query.Before != nil {
+		beforeTime = sql.NullTime{Time: query.Before.Time, Valid: true}
+		beforeID = query.Before.ID
+	}
+
+	// One extra message is fetched to learn whether there is a next page.
+	const timelineQuery = `
+		WITH identities AS (
+			SELECT comm.id
+			FROM communications comm
+			JOIN contacts owner ON owner.id = comm.contact_id
+			WHERE COALESCE(owner.merged_into_id, owner.id) = $1
+		), conversation_ids AS (
+			SELECT DISTINCT cm.conversation_id
+			FROM conversation_memberships cm
+			JOIN identities ON identities.id = cm.communication_id
+		)
+		SELECT
+			m.id,
+			m.conversation_id,
+			comm.identifier AS sender_identifier,
+			m.message_type,
+			m.direction,
+			m.body,
+			m.attachments,
+			m.provider_id,
+			m.message_status,
+			m.status_updated_at,
+			m.error_code,
+			m.created_at
+		FROM messages m
+		JOIN conversation_ids ON conversation_ids.conversation_id = m.conversation_id
+		JOIN communications comm ON comm.id = m.sender_id
+		WHERE m.deleted_at IS NULL
+		  AND ($2::timestamptz IS NULL OR (m.created_at, m.id) < ($2, $3))
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $4
+	`
+
+	rows, err := r.db.QueryContext(ctx, timelineQuery, contactID, beforeTime, beforeID, limit+1)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query timeline of contact %s", id))
+	}
+	defer rows.Close()
+
+	page := &TimelinePage{Messages: make([]Message, 0, limit)}
+	var lastCreatedAt time.Time
+	for rows.Next() {
+		var (
+			msg         Message
+			body        sql.NullString
+			attachments pq.StringArray
+			providerID  sql.NullString
+			statusAt    time.Time
+			errorCode   sql.NullString
+			createdAt   time.Time
+		)
+
+		if err := rows.Scan(
+			&msg.ID, &msg.ConversationID, &msg.From, &msg.Type, &msg.Direction, &body,
+			&attachments, &providerID, &msg.Status, &statusAt, &errorCode, &createdAt,
+		); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan timeline row")
+		}
+
+		if len(page.Messages) == limit {
+			last := page.Messages[limit-1]
+			page.NextCursor = Cursor{Time: lastCreatedAt, ID: last.ID}.Encode()
+			break
+		}
+
+		msg.Body = body.String
+		msg.Attachments = attachments
+		msg.ProviderID = providerID.String
+		msg.StatusUpdatedAt = statusAt.Format(time.RFC3339)
+		msg.ErrorCode = errorCode.String
+		msg.CreatedAt = createdAt.Format(time.RFC3339)
+		page.Messages = append(page.Messages, msg)
+		lastCreatedAt = createdAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return page, nil
+}
+
+// assignIdentifiers links communications to a contact, creating the ones we have not
+// seen yet. Identifiers owned by another contact make it return apperrors.DBErrorConflict.
+func assignIdentifiers(ctx context.Context, tx *sql.Tx, contactID int64, comms []Communication) error {
+	if len(comms) == 0 {
+		return nil
+	}
+
+	upsertCommQuery := `
+		INSERT INTO communications (identifier, communication_type)
+		VALUES ($1, $2)
+		ON CONFLICT (identifier) DO NOTHING
+	`
+	identifiers := make([]string, 0, len(comms))
+	for _, comm := range comms {
+		if _, err := tx.ExecContext(ctx, upsertCommQuery, comm.Identifier, comm.Type); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to upsert communication %s", comm.Identifier))
+		}
+		identifiers = append(identifiers, comm.Identifier)
+	}
+
+	assignQuery := `UPDATE communications SET contact_id = $1 WHERE identifier = ANY($2) AND contact_id IS NULL`
+	if _, err := tx.ExecContext(ctx, assignQuery, contactID, pq.Array(identifiers)); err != nil {
+		return apperrors.NewDBError(err, "failed to assign contact identifiers")
+	}
+
+	// Identities of contacts merged into this one already belong to it and keep their
+	// owner, so that unmerging gives them back.
+	var owned int
+	ownedQuery := `
+		SELECT count(*)
+		FROM communications comm
+		LEFT JOIN contacts owner ON owner.id = comm.contact_id
+		WHERE comm.identifier = ANY($2) AND COALESCE(owner.merged_into_id, owner.id) = $1
+	`
+	if err := tx.QueryRowContext(ctx, ownedQuery, contactID, pq.Array(identifiers)).Scan(&owned); err != nil {
+		return apperrors.NewDBError(err, "failed to verify contact identifiers")
+	}
+	if owned != len(identifiers) {
+		return apperrors.DBErrorConflict
+	}
+
+	return nil
+}
+
+// getContact returns a contact with its identifiers.
+func getContact(ctx context.Context, q querier, id any) (*Contact, error) {
+	const contactQuery = `
+		SELECT id, name, metadata, merged_into_id, created_at, updated_at
+		FROM contacts
+		WHERE id = $1
+	`
+	rows, err := q.QueryContext(ctx, contactQuery, id)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query contact %v", id))
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+		}
+		return nil, apperrors.DBErrorNotFound
+	}
+
+	contact, _, err := scanContact(rows)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	contacts := []Contact{*contact}
+	if err := loadIdentifiers(ctx, q, contacts); err != nil {
+		return nil, err
+	}
+
+	return &contacts[0], nil
+}
+
+// scanContact scans a contacts row selected as id, name, metadata, merged_into_id,
+// created_at, updated_at.
+func scanContact(rows *sql.Rows) (*Contact, time.Time, error) {
+	var (
+		contact    Contact
+		metadata   []byte
+		mergedInto sql.NullInt64
+		createdAt  time.Time
+		updatedAt  time.Time
+	)
+
+	if err := rows.Scan(&contact.ID, &contact.Name, &metadata, &mergedInto, &createdAt, &updatedAt); err != nil {
+		return nil, createdAt, apperrors.NewDBError(err, "failed to scan contact row")
+	}
+
+	if err := json.Unmarshal(metadata, &contact.Metadata); err != nil {
+		return nil, createdAt, apperrors.NewDBError(err, "failed to decode contact metadata")
+	}
+	if mergedInto.Valid {
+		contact.MergedIntoID = &mergedInto.Int64
+	}
+	contact.Identifiers = []Communication{}
+	contact.CreatedAt = createdAt.Format(time.RFC3339)
+	contact.UpdatedAt = updatedAt.Format(time.RFC3339)
+
+	return &contact, createdAt, nil
+}
+
+// loadIdentifiers fills in the identifiers of contacts. A merged contact keeps listing
+// its own identifiers; the contact it is merged into lists them as well.
+func loadIdentifiers(ctx context.Context, q querier, contacts []Contact) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(contacts))
+	byID := make(map[int64]*Contact, len(contacts))
+	for i := range contacts {
+		ids = append(ids, contacts[i].ID)
+		byID[contacts[i].ID] = &contacts[i]
+	}
+
+	const identifiersQuery = `
+		SELECT owner.id, owner.merged_into_id, comm.id, comm.identifier, comm.communication_type
+		FROM communications comm
+		JOIN contacts owner ON owner.id = comm.contact_id
+		WHERE owner.id = ANY($1) OR owner.merged_into_id = ANY($1)
+		ORDER BY comm.id
+	`
+	rows, err := q.QueryContext(ctx, identifiersQuery, pq.Array(ids))
+	if err != nil {
+		return apperrors.NewDBError(err, "failed to query contact identifiers")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			ownerID    int64
+			mergedInto sql.NullInt64
+			comm       Communication
+		)
+		if err := rows.Scan(&ownerID, &mergedInto, &comm.ID, &comm.Identifier, &comm.Type); err != nil {
+			return apperrors.NewDBError(err, "failed to scan contact identifier row")
+		}
+
+		if contact, ok := byID[ownerID]; ok {
+			contact.Identifiers = append(contact.Identifiers, comm)
+		}
+		if contact, ok := byID[mergedInto.Int64]; ok && mergedInto.Valid {
+			contact.Identifiers = append(contact.Identifiers, comm)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return nil
+}
+
+// encodeMetadata marshals contact or message metadata for a JSONB column.
+func encodeMetadata(metadata map[string]any) ([]byte, error) {
+	if metadata == nil {
+		return []byte("{}"), nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to encode metadata")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"slices"
+	"time"
+)
+
+// effectiveStatus is the SQL status of conversation c, reporting snoozed conversations
+// whose snooze has ended as open.
+const effectiveStatus = `CASE WHEN c.status = 'snoozed' AND c.snoozed_until <= now() THEN 'open' ELSE c.status::text END`
+
+// statusConditions select the conversations c in each effective status, written so the
+// status indexes can be used.
+var statusConditions = map[string]string{
+	ConversationStatusOpen:     `(c.status = 'open' OR (c.status = 'snoozed' AND c.snoozed_until <= now()))`,
+	ConversationStatusSnoozed:  `(c.status = 'snoozed' AND c.snoozed_until > now())`,
+	ConversationStatusClosed:   `c.status = 'closed'`,
+	ConversationStatusArchived: `c.status = 'archived'`,
+}
+
+// conversationTransitions lists the statuses a conversation can move to from each
+// status. Snoozing a snoozed conversation changes when it wakes up.
+var conversationTransitions = map[string][]string{
+	ConversationStatusOpen:     {ConversationStatusSnoozed, ConversationStatusClosed, ConversationStatusArchived},
+	ConversationStatusSnoozed:  {ConversationStatusOpen, ConversationStatusSnoozed, ConversationStatusClosed, ConversationStatusArchived},
+	ConversationStatusClosed:   {ConversationStatusOpen, ConversationStatusArchived},
+	ConversationStatusArchived: {ConversationStatusOpen},
+}
+
+// CanTransitionConversation reports whether a conversation may move from one status to
+// another.
+func CanTransitionConversation(from, to string) bool {
+	return slices.Contains(conversationTransitions[from], to)
+}
+
+// TransitionConversation moves a conversation to a new status; snoozedUntil is only used
+// for ConversationStatusSnoozed. Moving a conversation to its current status is a no-op,
+// and moves not allowed by conversationTransitions return apperrors.DBErrorConflict.
+func (r *PostgresRepository) TransitionConversation(ctx context.Context, id, status string, snoozedUntil time.Time) (*Conversation, error) {
+	var conversation *Conversation
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var current string
+		findConversationQuery := `SELECT ` + effectiveStatus + ` FROM conversations c WHERE c.id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, findConversationQuery, id).Scan(&current); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to find conversation %s", id))
+		}
+
+		if current != status || status == ConversationStatusSnoozed {
+			if !CanTransitionConversation(current, status) {
+				return apperrors.DBErrorConflict
+			}
+
+			until := sql.NullTime{Time: snoozedUntil, Valid: status == ConversationStatusSnoozed}
+			updateStatusQuery := `
+				UPDATE conversations
+				SET status = $2, snoozed_until = $3, status_updated_at = now()
+				WHERE id = $1
+			`
+			if _, err := tx.ExecContext(ctx, updateStatusQuery, id, status, until); err != nil {
+				return apperrors.NewDBError(err, fmt.Sprintf("failed to update status of conversation %s", id))
+			}
+		}
+
+		var err error
+		conversation, err = getConversationSummary(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// getConversationSummary returns a conversation without its participants and messages.
+func getConversationSummary(ctx context.Context, q querier, id string) (*Conversation, error) {
+	const conversationQuery = `
+		SELECT c.id, c.created_at, c.last_activity_at, c.message_count, ` + effectiveStatus + `, c.snoozed_until,
+			c.assignee_id
+		FROM conversations c
+		WHERE c.id = $1
+	`
+
+	var (
+		conversation              Conversation
+		createdAt, lastActivityAt time.Time
+		snoozedUntil              sql.NullTime
+		assigneeID                sql.NullInt64
+	)
+	if err := q.QueryRowContext(ctx, conversationQuery, id).Scan(
+		&conversation.ID, &createdAt, &lastActivityAt, &conversation.MessageCount,
+		&conversation.Status, &snoozedUntil, &assigneeID,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query conversation %s", id))
+	}
+
+	conversation.CreatedAt = createdAt.Format(time.RFC3339)
+	conversation.LastActivityAt = lastActivityAt.Format(time.RFC3339)
+	if conversation.Status == ConversationStatusSnoozed {
+		conversation.SnoozedUntil = snoozedUntil.Time.Format(time.RFC3339)
+	}
+	if assigneeID.Valid {
+		conversation.AssigneeID = &assigneeID.Int64
+	}
+
+	return &conversation, nil
+}
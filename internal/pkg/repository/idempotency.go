@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hatchapp/internal/pkg/apperrors"
+)
+
+// ReserveIdempotencyKey claims key for a new request until key.LockedUntil. It returns
+// true together with the reservation the caller holds when the key was unused, had
+// expired, or was left in flight past its lock by an identical request (e.g. because
+// the server handling it crashed). Otherwise it returns the stored key so the caller can
+// compare fingerprints and replay its response; a StatusCode of 0 means the first
+// request is still in flight.
+func (r *PostgresRepository) ReserveIdempotencyKey(ctx context.Context, key IdempotencyKey) (*IdempotencyKey, bool, error) {
+	reserveQuery := `
+		INSERT INTO idempotency_keys (owner, endpoint, idempotency_key, fingerprint, expires_at, locked_until)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (owner, endpoint, idempotency_key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			response = NULL,
+			created_at = now(),
+			expires_at = EXCLUDED.expires_at,
+			locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.expires_at <= now()
+		   OR (idempotency_keys.status_code IS NULL
+		       AND idempotency_keys.locked_until < now()
+		       AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+		RETURNING locked_until
+	`
+
+	reservation := key
+	err := r.db.QueryRowContext(ctx, reserveQuery,
+		key.Owner, key.Endpoint, key.Key, key.Fingerprint, key.ExpiresAt, key.LockedUntil,
+	).Scan(&reservation.LockedUntil)
+	if err == nil {
+		return &reservation, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, apperrors.NewDBError(err, "failed to reserve idempotency key")
+	}
+
+	existing := IdempotencyKey{Key: key.Key, Endpoint: key.Endpoint, Owner: key.Owner}
+	var statusCode sql.NullInt64
+
+	findKeyQuery := `
+		SELECT fingerprint, status_code, response, expires_at
+		FROM idempotency_keys
+		WHERE owner = $1 AND endpoint = $2 AND idempotency_key = $3
+	`
+	if err := r.db.QueryRowContext(ctx, findKeyQuery, key.Owner, key.Endpoint, key.Key).Scan(
+		&existing.Fingerprint, &statusCode, &existing.Response, &existing.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The first request failed and released the key in the meantime.
+			return nil, false, apperrors.DBErrorConflict
+		}
+		return nil, false, apperrors.NewDBError(err, "failed to find idempotency key")
+	}
+	existing.StatusCode = int(statusCode.Int64)
+
+	return &existing, false, nil
+}
+
+// SaveIdempotentResponse stores the response of the request holding the reservation key
+// so later requests with the same key replay it. Nothing is stored once another request
+// took the reservation over.
+func (r *PostgresRepository) SaveIdempotentResponse(ctx context.Context, key IdempotencyKey) error {
+	saveQuery := `
+		UPDATE idempotency_keys
+		SET status_code = $5, response = $6
+		WHERE owner = $1 AND endpoint = $2 AND idempotency_key = $3 AND locked_until = $4
+	`
+	if _, err := r.db.ExecContext(ctx, saveQuery,
+		key.Owner, key.Endpoint, key.Key, key.LockedUntil, key.StatusCode, key.Response,
+	); err != nil {
+		return apperrors.NewDBError(err, "failed to save idempotent response")
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the reservation key whose request failed, so the client
+// can retry it.
+func (r *PostgresRepository) ReleaseIdempotencyKey(ctx context.Context, key IdempotencyKey) error {
+	releaseQuery := `
+		DELETE FROM idempotency_keys
+		WHERE owner = $1 AND endpoint = $2 AND idempotency_key = $3 AND locked_until = $4 AND status_code IS NULL
+	`
+	if _, err := r.db.ExecContext(ctx, releaseQuery, key.Owner, key.Endpoint, key.Key, key.LockedUntil); err != nil {
+		return apperrors.NewDBError(err, "failed to release idempotency key")
+	}
+
+	return nil
+}
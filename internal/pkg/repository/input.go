@@ -1,40 +1,266 @@
 package repository
 
+import (
+	"strings"
+	"time"
+)
+
 const (
 	CommunicationTypeEmail = "email"
 	CommunicationTypePhone = "phone"
 )
 
 const (
-	MessageStatusSuccess = "success"
-	MessageStatusFailed  = "failed"
+	MessageStatusQueued      = "queued"
+	MessageStatusSending     = "sending"
+	MessageStatusSent        = "sent"
+	MessageStatusDelivered   = "delivered"
+	MessageStatusUndelivered = "undelivered"
+	MessageStatusFailed      = "failed"
+	MessageStatusRead        = "read"
+	MessageStatusReceived    = "received"
+)
+
+const (
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+)
+
+const (
+	ConversationStatusOpen     = "open"
+	ConversationStatusSnoozed  = "snoozed"
+	ConversationStatusClosed   = "closed"
+	ConversationStatusArchived = "archived"
+)
+
+const (
+	ItemKindMessage = "message"
+	ItemKindNote    = "note"
 )
 
 // Message represents the expected JSON payload for SMS messages.
 type Message struct {
 	ID                int64    `json:"id,omitempty"`
+	ConversationID    int64    `json:"conversation_id,omitempty"`
 	From              string   `json:"from"`
-	To                string   `json:"to,omitempty"`
+	To                []string `json:"to,omitempty"`
 	CommunicationType string   `json:"communication_type,omitempty"`
 	Type              string   `json:"type"`
+	Direction         string   `json:"direction,omitempty"`
 	Body              string   `json:"body"`
+	Subject           string   `json:"-"` // subject line of an outbound email, kept with its outbox rows
 	Attachments       []string `json:"attachments"`
 	ProviderID        string   `json:"provider_id"`
 	Status            string   `json:"status,omitempty"`
+	StatusUpdatedAt   string   `json:"status_updated_at,omitempty"`
+	ErrorCode         string   `json:"error_code,omitempty"`
 	CreatedAt         string   `json:"timestamp"`
+	// Recipients is the delivery status of each recipient of an outbound message.
+	Recipients []Recipient `json:"recipients,omitempty"`
+}
+
+// MessageDetail is a message with its internal metadata and full status history. Deleted
+// messages are still returned, with DeletedAt set.
+type MessageDetail struct {
+	Message
+	Metadata  map[string]any `json:"metadata"`
+	DeletedAt string         `json:"deleted_at,omitempty"`
+	Tags      []string       `json:"tags"`
+	History   []StatusChange `json:"history"`
+}
+
+// StatusChange is an entry of a message's status history.
+type StatusChange struct {
+	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	CreatedAt    string `json:"timestamp"`
+}
+
+// SearchResult is a message matching a search, with the matching words of its body
+// wrapped in <mark> tags. The rest of the highlight is HTML escaped text.
+type SearchResult struct {
+	Message
+	Rank      float32 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
+// Recipient is the delivery status of an outbound message for one of its recipients.
+type Recipient struct {
+	To         string `json:"to"`
+	Status     string `json:"status"`
+	ProviderID string `json:"provider_id,omitempty"`
+	ErrorCode  string `json:"error_code,omitempty"`
 }
 
 // Conversation represents a conversation in the messaging service.
 type Conversation struct {
-	ID           int64           `json:"id"`
-	CreatedAt    string          `json:"created_at"`
+	ID             int64  `json:"id"`
+	CreatedAt      string `json:"created_at"`
+	LastActivityAt string `json:"last_activity_at,omitempty"`
+	// Status is the conversation's status; a snoozed conversation is open again once
+	// SnoozedUntil has passed.
+	Status       string          `json:"status,omitempty"`
+	SnoozedUntil string          `json:"snoozed_until,omitempty"`
+	AssigneeID   *int64          `json:"assignee_id,omitempty"`
+	Tags         []string        `json:"tags,omitempty"`
+	LastMessage  *MessagePreview `json:"last_message,omitempty"`
+	MessageCount int             `json:"message_count,omitempty"`
+	// UnreadCount is the number of inbound messages the requesting user has not read.
+	UnreadCount  *int            `json:"unread_count,omitempty"`
 	Participants []Communication `json:"participants,omitempty"`
 	Messages     []Message       `json:"messages,omitempty"`
 }
 
+// MessagePreview summarizes a message for conversation lists.
+type MessagePreview struct {
+	ID        int64  `json:"id"`
+	From      string `json:"from"`
+	Type      string `json:"type"`
+	Snippet   string `json:"snippet"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"timestamp"`
+}
+
+// ReadReceipt is how far a user has read a conversation.
+type ReadReceipt struct {
+	ConversationID    int64  `json:"conversation_id"`
+	UserID            string `json:"user_id"`
+	LastReadMessageID *int64 `json:"last_read_message_id,omitempty"`
+	UnreadCount       int    `json:"unread_count"`
+	ReadAt            string `json:"read_at"`
+}
+
+// UnreadSummary totals a user's unread inbound messages across open and snoozed
+// conversations.
+type UnreadSummary struct {
+	UnreadCount         int `json:"unread_count"`
+	UnreadConversations int `json:"unread_conversations"`
+}
+
 // Communications represents a communication entity.
 type Communication struct {
 	ID         int64  `json:"id"`
 	Identifier string `json:"identifier"`
 	Type       string `json:"type"`
 }
+
+// Contact is a customer owning one or more communications, e.g. a phone number and an
+// email address.
+type Contact struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Metadata    map[string]any  `json:"metadata"`
+	Identifiers []Communication `json:"identifiers"`
+	// MergedIntoID is set while the contact is merged into another contact.
+	MergedIntoID *int64 `json:"merged_into_id,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// Note is an internal note agents leave on a conversation. Notes are stored apart from
+// messages and are never delivered to the conversation's participants.
+type Note struct {
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	Author         string `json:"author"`
+	Body           string `json:"body"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+// ConversationItem is an entry of a conversation's timeline: a message or a note, as
+// Kind tells.
+type ConversationItem struct {
+	Kind    string   `json:"kind"`
+	Message *Message `json:"message,omitempty"`
+	Note    *Note    `json:"note,omitempty"`
+}
+
+// Tag is a label put on conversations and messages. Names are unique regardless of case.
+type Tag struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// TagCount is a tag with the number of conversations and messages carrying it.
+type TagCount struct {
+	Tag
+	ConversationCount int `json:"conversation_count"`
+	MessageCount      int `json:"message_count"`
+}
+
+// Agent is an inbox user conversations can be assigned to.
+type Agent struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// Available agents are assigned new inbound conversations in turn.
+	Available bool   `json:"available"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Assignment is a change of a conversation's assignee. AgentID is nil when the
+// conversation was unassigned, and AssignedBy is empty for automatic assignments.
+type Assignment struct {
+	AgentID    *int64 `json:"agent_id"`
+	AssignedBy string `json:"assigned_by,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// APIKey is a key clients authenticate /api requests with. Only a hash of the key is
+// stored; Prefix is its first characters, to tell keys apart. Requests made with a key
+// issued to a user act for that user, e.g. when reading conversations or writing notes.
+type APIKey struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	UserID     string   `json:"user_id,omitempty"`
+	TenantID   string   `json:"tenant_id,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+}
+
+// OutboxItem is a queued outbound message claimed by a delivery worker.
+type OutboxItem struct {
+	ID                int64
+	MessageID         int64
+	CommunicationType string
+	From              string
+	To                string
+	Subject           string
+	Body              string
+	Attachments       []string
+	Attempts          int
+	LockedUntil       time.Time // end of the lease, identifies the worker's claim
+}
+
+// IdempotencyKey is a client supplied Idempotency-Key together with a fingerprint of the
+// request that first used it and, once that request finished, its response.
+type IdempotencyKey struct {
+	Key         string
+	Endpoint    string
+	Owner       string // the API key that used it, empty when API keys are not required
+	Fingerprint string
+	StatusCode  int
+	Response    []byte
+	ExpiresAt   time.Time
+	LockedUntil time.Time // end of the in-flight request's lock, identifies its reservation
+}
+
+// snippetLength is the number of characters of a message body shown in previews.
+const snippetLength = 100
+
+// snippet shortens a message body for previews, collapsing whitespace and cutting it at
+// snippetLength characters.
+func snippet(body string) string {
+	collapsed := []rune(strings.Join(strings.Fields(body), " "))
+	if len(collapsed) <= snippetLength {
+		return string(collapsed)
+	}
+	return strings.TrimSpace(string(collapsed[:snippetLength])) + "…"
+}
@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GetMessageByID returns a message with its recipients, metadata, the tenant's tags and
+// status history.
+func (r *PostgresRepository) GetMessageByID(ctx context.Context, tenantID, id string) (*MessageDetail, error) {
+	return getMessageDetail(ctx, r.db, tenantID, id)
+}
+
+// DeleteMessage hides a message from its conversation. The message is kept, and can
+// still be read by ID, for audit. Recipients an outbound message was not delivered to
+// yet are not sent it anymore. Deleting a deleted message is a no-op.
+func (r *PostgresRepository) DeleteMessage(ctx context.Context, id string) error {
+	return r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var messageID, conversationID int64
+		var direction string
+		var deletedAt sql.NullTime
+		findMessageQuery := `SELECT id, conversation_id, direction, deleted_at FROM messages WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, findMessageQuery, id).Scan(&messageID, &conversationID, &direction, &deletedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to find message %s", id))
+		}
+
+		if deletedAt.Valid {
+			return nil
+		}
+
+		deleteMessageQuery := `UPDATE messages SET deleted_at = now() WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, deleteMessageQuery, id); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to delete message %s", id))
+		}
+
+		// The conversation previews and counts its visible messages only.
+		inbound := direction == DirectionInbound
+		refreshConversationQuery := `
+			UPDATE conversations c
+			SET message_count = message_count - 1,
+				inbound_count = inbound_count - CASE WHEN $2 THEN 1 ELSE 0 END,
+				last_message_id = (
+					SELECT m.id
+					FROM messages m
+					WHERE m.conversation_id = c.id AND m.deleted_at IS NULL
+					ORDER BY m.created_at DESC, m.id DESC
+					LIMIT 1
+				)
+			WHERE c.id = $1
+		`
+		if _, err := tx.ExecContext(ctx, refreshConversationQuery, conversationID, inbound); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to update conversation %d", conversationID))
+		}
+
+		if !inbound {
+			return cancelOutbox(ctx, tx, messageID)
+		}
+
+		// Users who already read the message no longer count it, so their unread counts
+		// stay the same while everybody else has one message less to read.
+		uncountReadQuery := `
+			UPDATE conversation_reads cr
+			SET read_inbound_count = read_inbound_count - 1
+			FROM messages last_read, messages deleted
+			WHERE cr.conversation_id = $1
+			  AND cr.read_inbound_count > 0
+			  AND last_read.id = cr.last_read_message_id
+			  AND deleted.id = $2
+			  AND (last_read.created_at, last_read.id) >= (deleted.created_at, deleted.id)
+		`
+		if _, err := tx.ExecContext(ctx, uncountReadQuery, conversationID, id); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to update read state of conversation %d", conversationID))
+		}
+
+		return nil
+	})
+}
+
+// cancelOutbox stops the pending deliveries of a deleted message and marks their
+// recipients failed. A send a worker is in the middle of cannot be stopped anymore, but
+// its outcome is no longer recorded.
+func cancelOutbox(ctx context.Context, tx *sql.Tx, messageID int64) error {
+	const reason = "message deleted before delivery"
+
+	var recipients pq.StringArray
+	cancelOutboxQuery := `
+		WITH cancelled AS (
+			UPDATE outbox
+			SET processed_at = now(), locked_until = NULL, last_error = $2
+			WHERE message_id = $1 AND processed_at IS NULL
+			RETURNING recipient
+		)
+		SELECT array_agg(recipient ORDER BY recipient) FROM cancelled
+	`
+	if err := tx.QueryRowContext(ctx, cancelOutboxQuery, messageID, reason).Scan(&recipients); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to cancel deliveries of message %d", messageID))
+	}
+
+	for _, recipient := range recipients {
+		if err := setRecipientStatus(ctx, tx, messageID, recipient, MessageStatusFailed, "", "", reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateMessageMetadata merges metadata into a message's metadata. Top-level keys set to
+// null are removed; nulls nested in a value are kept.
+func (r *PostgresRepository) UpdateMessageMetadata(ctx context.Context, tenantID, id string, metadata map[string]any) (*MessageDetail, error) {
+	patch, err := encodeMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var message *MessageDetail
+	err = r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		updateMetadataQuery := `
+			UPDATE messages
+			SET metadata = (metadata || $2::jsonb) - ARRAY(SELECT key FROM jsonb_each($2::jsonb) WHERE value = 'null')
+			WHERE id = $1
+		`
+		result, err := tx.ExecContext(ctx, updateMetadataQuery, id, patch)
+		if err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to update metadata of message %s", id))
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			return apperrors.DBErrorNotFound
+		}
+
+		message, err = getMessageDetail(ctx, tx, tenantID, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// getMessageDetail loads a message with its recipients, metadata, status history and the
+// tenant's tags.
+func getMessageDetail(ctx context.Context, q querier, tenantID, id string) (*MessageDetail, error) {
+	const messageQuery = `
+		SELECT
+			m.id,
+			m.conversation_id,
+			comm.identifier AS sender_identifier,
+			m.message_type,
+			m.channel,
+			m.direction,
+			m.body,
+			m.attachments,
+			m.provider_id,
+			m.message_status,
+			m.status_updated_at,
+			m.error_code,
+			m.created_at,
+			m.metadata,
+			m.deleted_at,
+			ARRAY(
+				SELECT t.name
+				FROM message_tags mt
+				JOIN tags t ON t.id = mt.tag_id
+				WHERE mt.message_id = m.id AND t.tenant_id = $2
+				ORDER BY lower(t.name)
+			) AS tags,
+			recipients.statuses
+		FROM messages m
+		JOIN communications comm ON comm.id = m.sender_id
+		LEFT JOIN LATERAL (
+			SELECT json_agg(json_build_object(
+				'to', rc.identifier,
+				'status', mr.message_status,
+				'provider_id', COALESCE(mr.provider_id, ''),
+				'error_code', COALESCE(mr.error_code, '')
+			) ORDER BY rc.id) AS statuses
+			FROM message_recipients mr
+			JOIN communications rc ON rc.id = mr.communication_id
+			WHERE mr.message_id = m.id
+		) recipients ON true
+		WHERE m.id = $1
+	`
+
+	var (
+		detail      MessageDetail
+		body        sql.NullString
+		attachments pq.StringArray
+		providerID  sql.NullString
+		statusAt    time.Time
+		errorCode   sql.NullString
+		createdAt   time.Time
+		metadata    []byte
+		deletedAt   sql.NullTime
+		tags        pq.StringArray
+		statuses    []byte
+	)
+	if err := q.QueryRowContext(ctx, messageQuery, id, tenantID).Scan(
+		&detail.ID, &detail.ConversationID, &detail.From, &detail.Type,
+		&detail.CommunicationType, &detail.Direction, &body, &attachments, &providerID,
+		&detail.Status, &statusAt, &errorCode, &createdAt, &metadata, &deletedAt, &tags, &statuses,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query message %s", id))
+	}
+
+	detail.Body = body.String
+	detail.Attachments = attachments
+	detail.ProviderID = providerID.String
+	detail.StatusUpdatedAt = statusAt.Format(time.RFC3339)
+	detail.ErrorCode = errorCode.String
+	detail.CreatedAt = createdAt.Format(time.RFC3339)
+	detail.Tags = tags
+	if deletedAt.Valid {
+		detail.DeletedAt = deletedAt.Time.Format(time.RFC3339)
+	}
+	if err := json.Unmarshal(metadata, &detail.Metadata); err != nil {
+		return nil, apperrors.NewDBError(err, "failed to decode message metadata")
+	}
+	if statuses != nil {
+		if err := json.Unmarshal(statuses, &detail.Recipients); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to decode message recipients")
+		}
+	}
+
+	const historyQuery = `
+		SELECT message_status, provider_error_code, error_message, created_at
+		FROM message_status_history
+		WHERE message_id = $1
+		ORDER BY created_at, id
+	`
+	rows, err := q.QueryContext(ctx, historyQuery, detail.ID)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query status history of message %s", id))
+	}
+	defer rows.Close()
+
+	detail.History = []StatusChange{}
+	for rows.Next() {
+		var (
+			change       StatusChange
+			changeCode   sql.NullString
+			errorMessage sql.NullString
+			changedAt    time.Time
+		)
+		if err := rows.Scan(&change.Status, &changeCode, &errorMessage, &changedAt); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan status history row")
+		}
+		change.ErrorCode = changeCode.String
+		change.ErrorMessage = errorMessage.String
+		change.CreatedAt = changedAt.Format(time.RFC3339)
+		detail.History = append(detail.History, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return &detail, nil
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+)
+
+// CreateNote adds an internal note to a conversation.
+func (r *PostgresRepository) CreateNote(ctx context.Context, conversationID string, note Note) (*Note, error) {
+	const insertNoteQuery = `
+		INSERT INTO conversation_notes (conversation_id, author, body)
+		SELECT id, $2, $3 FROM conversations WHERE id = $1
+		RETURNING id, conversation_id, author, body, created_at, updated_at
+	`
+	created, _, err := scanNote(r.db.QueryRowContext(ctx, insertNoteQuery, conversationID, note.Author, note.Body))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to insert note on conversation %s", conversationID))
+	}
+
+	return created, nil
+}
+
+// GetNotes returns the notes of a conversation, oldest first.
+func (r *PostgresRepository) GetNotes(ctx context.Context, conversationID string) ([]Note, error) {
+	var convID int64
+	const conversationQuery = `SELECT id FROM conversations WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, conversationQuery, conversationID).Scan(&convID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query conversation %s", conversationID))
+	}
+
+	notes, _, err := loadNotes(ctx, r.db, convID, sql.NullTime{}, sql.NullTime{})
+	return notes, err
+}
+
+// UpdateNote replaces the body of a note.
+func (r *PostgresRepository) UpdateNote(ctx context.Context, id string, body string) (*Note, error) {
+	const updateNoteQuery = `
+		UPDATE conversation_notes
+		SET body = $2, updated_at = now()
+		WHERE id = $1
+		RETURNING id, conversation_id, author, body, created_at, updated_at
+	`
+	updated, _, err := scanNote(r.db.QueryRowContext(ctx, updateNoteQuery, id, body))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to update note %s", id))
+	}
+
+	return updated, nil
+}
+
+// DeleteNote deletes a note.
+func (r *PostgresRepository) DeleteNote(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM conversation_notes WHERE id = $1`, id)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to delete note %s", id))
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperrors.DBErrorNotFound
+	}
+
+	return nil
+}
+
+// loadNotes returns a conversation's notes written from from (inclusive) until to
+// (exclusive), oldest first, with their timestamps. Null bounds are not applied.
+func loadNotes(ctx context.Context, q querier, conversationID int64, from, to sql.NullTime) ([]Note, []time.Time, error) {
+	const notesQuery = `
+		SELECT id, conversation_id, author, body, created_at, updated_at
+		FROM conversation_notes
+		WHERE conversation_id = $1
+		  AND ($2::timestamptz IS NULL OR created_at >= $2)
+		  AND ($3::timestamptz IS NULL OR created_at < $3)
+		ORDER BY created_at, id
+	`
+	rows, err := q.QueryContext(ctx, notesQuery, conversationID, from, to)
+	if err != nil {
+		return nil, nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query notes of conversation %d", conversationID))
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	var times []time.Time
+	for rows.Next() {
+		note, createdAt, err := scanNote(rows)
+		if err != nil {
+			return nil, nil, apperrors.NewDBError(err, "failed to scan note row")
+		}
+		notes = append(notes, *note)
+		times = append(times, createdAt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return notes, times, nil
+}
+
+// interleaveNotes merges a page of messages and the notes written alongside them into
+// one timeline, both given oldest first with their timestamps. A note written at the
+// same time as a message follows it. Items hold copies, so the inputs can be reordered
+// afterwards.
+func interleaveNotes(messages []Message, messageTimes []time.Time, notes []Note, noteTimes []time.Time) []ConversationItem {
+	items := make([]ConversationItem, 0, len(messages)+len(notes))
+	i, j := 0, 0
+	for i < len(messages) || j < len(notes) {
+		if j == len(notes) || (i < len(messages) && !messageTimes[i].After(noteTimes[j])) {
+			message := messages[i]
+			items = append(items, ConversationItem{Kind: ItemKindMessage, Message: &message})
+			i++
+		} else {
+			note := notes[j]
+			items = append(items, ConversationItem{Kind: ItemKindNote, Note: &note})
+			j++
+		}
+	}
+
+	return items
+}
+
+func scanNote(row rowScanner) (*Note, time.Time, error) {
+	var (
+		note                 Note
+		createdAt, updatedAt time.Time
+	)
+	if err := row.Scan(&note.ID, &note.ConversationID, &note.Author, &note.Body, &createdAt, &updatedAt); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	note.CreatedAt = createdAt.Format(time.RFC3339)
+	note.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &note, createdAt, nil
+}
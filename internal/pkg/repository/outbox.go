@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EnqueueMessage stores an outbound message as queued together with an outbox row per
+// recipient that a delivery worker will pick up, so the message is never stored without
+// being scheduled for delivery (or the other way around).
+func (r *PostgresRepository) EnqueueMessage(ctx context.Context, msg Message) (*int64, error) {
+	msg.Status = MessageStatusQueued
+	msg.Direction = DirectionOutbound
+
+	var messageID int64
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		messageID, _, err = createMessage(ctx, tx, msg)
+		if err != nil {
+			return err
+		}
+
+		// Every recipient is delivered separately and tracks its own status.
+		insertRecipientsQuery := `
+			INSERT INTO message_recipients (message_id, communication_id, message_status)
+			SELECT $1, id, $3 FROM communications WHERE identifier = ANY($2)
+		`
+		if _, err := tx.ExecContext(ctx, insertRecipientsQuery, messageID, pq.Array(msg.To), MessageStatusQueued); err != nil {
+			return apperrors.NewDBError(err, "failed to insert message recipients")
+		}
+
+		insertOutboxQuery := `INSERT INTO outbox (message_id, recipient, subject) SELECT $1, unnest($2::text[]), NULLIF($3, '')`
+		if _, err := tx.ExecContext(ctx, insertOutboxQuery, messageID, pq.Array(msg.To), msg.Subject); err != nil {
+			return apperrors.NewDBError(err, "failed to insert outbox rows")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &messageID, nil
+}
+
+// ClaimOutbox leases up to limit pending outbox rows to the caller and marks their
+// recipients as sending. Rows locked by another transaction are skipped, and rows whose
+// lease has expired (e.g. because the worker holding them crashed) become claimable again.
+// The lease end returned with each row identifies the claim: once another worker claims
+// the row, the row can no longer be renewed or finished with it.
+func (r *PostgresRepository) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxItem, error) {
+	const query = `
+		WITH claimed AS (
+			SELECT o.id
+			FROM outbox o
+			WHERE o.processed_at IS NULL
+			  AND o.available_at <= now()
+			  AND (o.locked_until IS NULL OR o.locked_until < now())
+			ORDER BY o.available_at, o.id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox o
+		SET locked_until = now() + make_interval(secs => $2),
+		    attempts = o.attempts + 1
+		FROM claimed, messages m
+		JOIN communications comm ON comm.id = m.sender_id
+		WHERE o.id = claimed.id AND m.id = o.message_id
+		RETURNING
+			o.id,
+			o.message_id,
+			comm.communication_type,
+			comm.identifier,
+			o.recipient,
+			o.subject,
+			m.body,
+			m.attachments,
+			o.attempts,
+			o.locked_until;
+	`
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	rows, err := tx.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to claim outbox rows")
+	}
+	defer rows.Close()
+
+	items := make([]OutboxItem, 0, limit)
+	for rows.Next() {
+		var (
+			item        OutboxItem
+			subject     sql.NullString
+			body        sql.NullString
+			attachments pq.StringArray
+		)
+
+		if err := rows.Scan(
+			&item.ID, &item.MessageID, &item.CommunicationType, &item.From,
+			&item.To, &subject, &body, &attachments, &item.Attempts, &item.LockedUntil,
+		); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan outbox row")
+		}
+
+		item.Subject = subject.String
+		item.Body = body.String
+		item.Attachments = attachments
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	// Messages are locked in ID order so concurrent claims of a group message's
+	// recipients cannot deadlock.
+	locking := slices.Clone(items)
+	slices.SortFunc(locking, func(a, b OutboxItem) int { return cmp.Compare(a.MessageID, b.MessageID) })
+	for _, item := range locking {
+		if err := setRecipientStatus(ctx, tx, item.MessageID, item.To, MessageStatusSending, "", "", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return items, nil
+}
+
+// RenewOutboxLease extends the caller's lease on an outbox row to lease from now and
+// returns the new lease end. It returns apperrors.DBErrorNotFound if the row was
+// finished or claimed by another worker since item was claimed.
+func (r *PostgresRepository) RenewOutboxLease(ctx context.Context, item OutboxItem, lease time.Duration) (time.Time, error) {
+	const renewLeaseQuery = `
+		UPDATE outbox
+		SET locked_until = now() + make_interval(secs => $3)
+		WHERE id = $1 AND processed_at IS NULL AND locked_until = $2
+		RETURNING locked_until
+	`
+	var lockedUntil time.Time
+	if err := r.db.QueryRowContext(ctx, renewLeaseQuery, item.ID, item.LockedUntil, lease.Seconds()).Scan(&lockedUntil); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, apperrors.DBErrorNotFound
+		}
+		return time.Time{}, apperrors.NewDBError(err, fmt.Sprintf("failed to renew lease on outbox row %d", item.ID))
+	}
+
+	return lockedUntil, nil
+}
+
+// CompleteOutbox records that the provider accepted the outbox row's message.
+func (r *PostgresRepository) CompleteOutbox(ctx context.Context, item OutboxItem, providerID string) error {
+	return r.finishOutbox(ctx, item, MessageStatusSent, providerID, "", "")
+}
+
+// FailOutbox gives up on delivering the outbox row's message.
+func (r *PostgresRepository) FailOutbox(ctx context.Context, item OutboxItem, errorCode, reason string) error {
+	return r.finishOutbox(ctx, item, MessageStatusFailed, "", errorCode, reason)
+}
+
+// RetryOutbox releases the outbox row so it can be claimed again at retryAt, and puts
+// its recipient back in the queue.
+func (r *PostgresRepository) RetryOutbox(ctx context.Context, item OutboxItem, retryAt time.Time, errorCode, reason string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	updateOutboxQuery := `
+		UPDATE outbox
+		SET available_at = $2, locked_until = NULL, last_error = $3
+		WHERE id = $1 AND processed_at IS NULL AND locked_until = $4
+	`
+	result, err := tx.ExecContext(ctx, updateOutboxQuery, item.ID, retryAt, reason, item.LockedUntil)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to reschedule outbox row %d", item.ID))
+	}
+
+	// Another worker claimed or finished this row after our lease expired.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil
+	}
+
+	if err := setRecipientStatus(ctx, tx, item.MessageID, item.To, MessageStatusQueued, "", errorCode, reason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// ReleaseOutbox hands a claimed outbox row back without trying to deliver it, e.g. when
+// the worker stops. Unlike RetryOutbox it does not count the claim as an attempt or record
+// an error, and the row can be claimed again right away.
+func (r *PostgresRepository) ReleaseOutbox(ctx context.Context, item OutboxItem) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	releaseOutboxQuery := `
+		UPDATE outbox
+		SET locked_until = NULL, attempts = GREATEST(attempts - 1, 0)
+		WHERE id = $1 AND processed_at IS NULL AND locked_until = $2
+	`
+	result, err := tx.ExecContext(ctx, releaseOutboxQuery, item.ID, item.LockedUntil)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to release outbox row %d", item.ID))
+	}
+
+	// Another worker claimed or finished this row after our lease expired.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil
+	}
+
+	if err := setRecipientStatus(ctx, tx, item.MessageID, item.To, MessageStatusQueued, "", "", ""); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) finishOutbox(ctx context.Context, item OutboxItem, status, providerID, errorCode, reason string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	updateOutboxQuery := `
+		UPDATE outbox
+		SET processed_at = now(), locked_until = NULL, last_error = NULLIF($2, '')
+		WHERE id = $1 AND processed_at IS NULL AND locked_until = $3
+	`
+	result, err := tx.ExecContext(ctx, updateOutboxQuery, item.ID, reason, item.LockedUntil)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to update outbox row %d", item.ID))
+	}
+
+	// Another worker claimed or finished this row after our lease expired.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil
+	}
+
+	// A status callback for this send may have been applied already (e.g. delivered
+	// before the worker recorded it as sent), which must not be regressed.
+	var current string
+	lockRecipientQuery := `
+		SELECT mr.message_status
+		FROM message_recipients mr
+		JOIN communications comm ON comm.id = mr.communication_id
+		WHERE mr.message_id = $1 AND comm.identifier = $2
+		FOR UPDATE OF mr
+	`
+	if err := tx.QueryRowContext(ctx, lockRecipientQuery, item.MessageID, item.To).Scan(&current); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to lock message %d for %s", item.MessageID, item.To))
+	}
+
+	if CanTransition(current, status) {
+		if err := setRecipientStatus(ctx, tx, item.MessageID, item.To, status, providerID, errorCode, reason); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return nil
+}
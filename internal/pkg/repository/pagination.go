@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned when a client supplied cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of the last item of a page in a list ordered by a timestamp
+// with the ID breaking ties. Clients only ever see it encoded.
+type Cursor struct {
+	Time time.Time `json:"t"`
+	ID   int64     `json:"id"`
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned by Cursor.Encode.
+func DecodeCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// SearchCursor is the position of the last result of a page of search results, which
+// are ordered by rank with the message ID breaking ties.
+type SearchCursor struct {
+	Rank float32 `json:"r"`
+	ID   int64   `json:"id"`
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c SearchCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeSearchCursor parses a cursor returned by SearchCursor.Encode.
+func DecodeSearchCursor(value string) (*SearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor SearchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// Columns the conversation list can be sorted by.
+const (
+	SortByCreatedAt    = "created_at"
+	SortByLastActivity = "last_activity_at"
+)
+
+// ConversationQuery selects a page of the conversation list. Filters left at their zero
+// value are not applied.
+type ConversationQuery struct {
+	Limit int
+	// UserID, when set, adds the user's unread count to each conversation.
+	UserID string
+	// Participant keeps conversations with a participant whose identifier equals it, or
+	// starts with it when ParticipantPrefix is set.
+	Participant       string
+	ParticipantPrefix bool
+	CommunicationType string
+	// From and To bound the timestamp the list is sorted by; From is inclusive, To exclusive.
+	From, To time.Time
+	// HasFailed keeps conversations with (or without) failed or undelivered messages.
+	HasFailed *bool
+	// Statuses keeps conversations in any of these statuses. Snoozed conversations whose
+	// snooze has ended count as open.
+	Statuses []string
+	// AssigneeID keeps the conversations assigned to an agent, Unassigned those assigned
+	// to nobody.
+	AssigneeID int64
+	Unassigned bool
+	// Tags keeps conversations carrying all of these tags of the tenant TenantID, whose
+	// tags are listed with each conversation.
+	Tags     []string
+	TenantID string
+	// SortBy is SortByLastActivity (the default) or SortByCreatedAt. Cursors are only
+	// valid for the sort they were returned with.
+	SortBy    string
+	Ascending bool
+	// After is the cursor of the last conversation of the previous page.
+	After *Cursor
+}
+
+// ConversationPage is a page of conversations ordered by last activity, most recent
+// first. NextCursor is empty on the last page.
+type ConversationPage struct {
+	Conversations []Conversation `json:"conversations"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+}
+
+// MessageQuery selects a page of a conversation's messages. Before and After are message
+// IDs bounding the page (exclusive); at most one of them is set.
+type MessageQuery struct {
+	Limit  int
+	Before int64
+	After  int64
+	// Descending returns the newest messages first.
+	Descending bool
+	// Direction keeps only inbound or outbound messages when set.
+	Direction string
+}
+
+// MessagePage is a conversation with a page of its messages. HasMore reports whether
+// more messages exist past the page, in the direction it was read: older ones for
+// Before or a descending first page, newer ones otherwise. Items interleaves the page's
+// messages with the notes written alongside them; notes do not count towards the limit.
+type MessagePage struct {
+	Conversation
+	Items   []ConversationItem `json:"items"`
+	HasMore bool               `json:"has_more"`
+}
+
+// ContactQuery selects a page of the contact list.
+type ContactQuery struct {
+	Limit int
+	// After is the cursor of the last contact of the previous page.
+	After *Cursor
+}
+
+// ContactPage is a page of contacts, most recently created first. Contacts merged into
+// another contact are left out. NextCursor is empty on the last page.
+type ContactPage struct {
+	Contacts   []Contact `json:"contacts"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// TimelineQuery selects a page of a contact's timeline.
+type TimelineQuery struct {
+	Limit int
+	// Before is the cursor of the oldest message of the previous page.
+	Before *Cursor
+}
+
+// TimelinePage is a page of the messages of all of a contact's conversations, newest
+// first. NextCursor, pointing at older messages, is empty on the last page.
+type TimelinePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// SearchQuery selects a page of full-text search results. Every filter is optional.
+type SearchQuery struct {
+	Limit int
+	// Text is a web search style query: words, "quoted phrases", OR and -excluded words.
+	Text string
+	// Channel is a communication type, e.g. CommunicationTypePhone.
+	Channel string
+	// Participant is the identifier of a participant of the message's conversation.
+	Participant string
+	// From and To bound the message timestamp; From is inclusive, To exclusive.
+	From, To  time.Time
+	Direction string
+	// Tags keeps messages carrying all of these tags of the tenant TenantID, on themselves
+	// or their conversation.
+	Tags     []string
+	TenantID string
+	// After is the cursor of the last result of the previous page.
+	After *SearchCursor
+}
+
+// SearchPage is a page of search results, best match first. NextCursor is empty on the
+// last page.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
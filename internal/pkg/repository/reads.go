@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+)
+
+// MarkConversationRead records that the user has read the conversation up to and
+// including the given message, or up to its last message when messageID is 0. Read
+// cursors never move backwards: marking an older message read again is a no-op.
+func (r *PostgresRepository) MarkConversationRead(ctx context.Context, id, userID string, messageID int64) (*ReadReceipt, error) {
+	var receipt *ReadReceipt
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var (
+			convID        int64
+			inboundCount  int
+			lastMessageID sql.NullInt64
+		)
+		findConversationQuery := `SELECT id, inbound_count, last_message_id FROM conversations WHERE id = $1`
+		if err := tx.QueryRowContext(ctx, findConversationQuery, id).Scan(&convID, &inboundCount, &lastMessageID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to find conversation %s", id))
+		}
+
+		// Reading up to a specific message counts the inbound messages up to it.
+		readID, readCount := lastMessageID, inboundCount
+		if messageID != 0 {
+			countQuery := `
+				SELECT count(*) FILTER (WHERE m.direction = 'inbound' AND m.deleted_at IS NULL)
+				FROM messages m, messages target
+				WHERE target.id = $2 AND target.conversation_id = $1
+				  AND m.conversation_id = $1
+				  AND (m.created_at, m.id) <= (target.created_at, target.id)
+				HAVING count(*) > 0
+			`
+			if err := tx.QueryRowContext(ctx, countQuery, convID, messageID).Scan(&readCount); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return apperrors.DBErrorNotFound
+				}
+				return apperrors.NewDBError(err, fmt.Sprintf("failed to count read messages of conversation %s", id))
+			}
+			readID = sql.NullInt64{Int64: messageID, Valid: true}
+		}
+
+		// The cursor only moves to messages after the one already read, in the order
+		// messages are listed.
+		upsertReadQuery := `
+			INSERT INTO conversation_reads (user_id, conversation_id, last_read_message_id, read_inbound_count)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, conversation_id) DO UPDATE
+			SET last_read_message_id = CASE
+					WHEN conversation_reads.last_read_message_id IS NULL OR (
+						SELECT (target.created_at, target.id) > (last_read.created_at, last_read.id)
+						FROM messages target, messages last_read
+						WHERE target.id = EXCLUDED.last_read_message_id
+						  AND last_read.id = conversation_reads.last_read_message_id
+					)
+					THEN EXCLUDED.last_read_message_id
+					ELSE conversation_reads.last_read_message_id
+				END,
+				read_inbound_count = GREATEST(conversation_reads.read_inbound_count, EXCLUDED.read_inbound_count),
+				read_at = now()
+			RETURNING last_read_message_id, read_inbound_count, read_at
+		`
+		var (
+			readAt           time.Time
+			readInboundCount int
+		)
+		if err := tx.QueryRowContext(ctx, upsertReadQuery, userID, convID, readID, readCount).Scan(&readID, &readInboundCount, &readAt); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to mark conversation %s read", id))
+		}
+
+		receipt = &ReadReceipt{
+			ConversationID: convID,
+			UserID:         userID,
+			UnreadCount:    inboundCount - readInboundCount,
+			ReadAt:         readAt.Format(time.RFC3339),
+		}
+		if readID.Valid {
+			receipt.LastReadMessageID = &readID.Int64
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return receipt, nil
+}
+
+// GetUnreadSummary returns the number of inbound messages the user has not read and the
+// number of conversations they are in. Closed and archived conversations are not counted.
+func (r *PostgresRepository) GetUnreadSummary(ctx context.Context, userID string) (*UnreadSummary, error) {
+	const unreadQuery = `
+		SELECT
+			COALESCE(sum(c.inbound_count - COALESCE(cr.read_inbound_count, 0)), 0),
+			count(*) FILTER (WHERE c.inbound_count > COALESCE(cr.read_inbound_count, 0))
+		FROM conversations c
+		LEFT JOIN conversation_reads cr ON cr.conversation_id = c.id AND cr.user_id = $1
+		WHERE c.inbound_count > 0 AND c.status IN ('open', 'snoozed')
+	`
+
+	var summary UnreadSummary
+	if err := r.db.QueryRowContext(ctx, unreadQuery, userID).Scan(&summary.UnreadCount, &summary.UnreadConversations); err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to count unread messages of user %s", userID))
+	}
+
+	return &summary, nil
+}
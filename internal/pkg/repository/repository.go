@@ -3,9 +3,12 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hatchapp/internal/pkg/apperrors"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/labstack/gommon/log"
@@ -15,9 +18,57 @@ import (
 // Repository is the interface for the messaging repository.
 type Repository interface {
 	Ping() error
-	CreateMessage(ctx context.Context, msg Message) (*int64, error)
-	GetConversations(ctx context.Context) ([]Conversation, error)
-	GetConversationByID(ctx context.Context, id string) (*Conversation, error)
+	CreateMessage(ctx context.Context, msg Message) (*int64, bool, error)
+	EnqueueMessage(ctx context.Context, msg Message) (*int64, error)
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxItem, error)
+	RenewOutboxLease(ctx context.Context, item OutboxItem, lease time.Duration) (time.Time, error)
+	CompleteOutbox(ctx context.Context, item OutboxItem, providerID string) error
+	RetryOutbox(ctx context.Context, item OutboxItem, retryAt time.Time, errorCode, reason string) error
+	FailOutbox(ctx context.Context, item OutboxItem, errorCode, reason string) error
+	ReleaseOutbox(ctx context.Context, item OutboxItem) error
+	RetryMessage(ctx context.Context, id string) error
+	UpdateMessageStatusByProviderID(ctx context.Context, providerID, status, errorCode, errorMessage string) (*int64, error)
+	ReserveIdempotencyKey(ctx context.Context, key IdempotencyKey) (*IdempotencyKey, bool, error)
+	SaveIdempotentResponse(ctx context.Context, key IdempotencyKey) error
+	ReleaseIdempotencyKey(ctx context.Context, key IdempotencyKey) error
+	GetConversations(ctx context.Context, query ConversationQuery) (*ConversationPage, error)
+	GetConversationByID(ctx context.Context, id string, query MessageQuery) (*MessagePage, error)
+	MarkConversationRead(ctx context.Context, id, userID string, messageID int64) (*ReadReceipt, error)
+	GetUnreadSummary(ctx context.Context, userID string) (*UnreadSummary, error)
+	SearchMessages(ctx context.Context, query SearchQuery) (*SearchPage, error)
+	TransitionConversation(ctx context.Context, id, status string, snoozedUntil time.Time) (*Conversation, error)
+	CreateNote(ctx context.Context, conversationID string, note Note) (*Note, error)
+	GetNotes(ctx context.Context, conversationID string) ([]Note, error)
+	UpdateNote(ctx context.Context, id string, body string) (*Note, error)
+	DeleteNote(ctx context.Context, id string) error
+	AssignConversation(ctx context.Context, id string, agentID *int64, assignedBy string) (*Conversation, error)
+	GetAssignmentHistory(ctx context.Context, id string) ([]Assignment, error)
+	CreateTag(ctx context.Context, tenantID, name string) (*Tag, error)
+	GetTags(ctx context.Context, tenantID string) ([]TagCount, error)
+	DeleteTag(ctx context.Context, tenantID, id string) error
+	TagConversation(ctx context.Context, tenantID, id, name string) ([]Tag, error)
+	UntagConversation(ctx context.Context, tenantID, id, tagID string) error
+	TagMessage(ctx context.Context, tenantID, id, name string) ([]Tag, error)
+	UntagMessage(ctx context.Context, tenantID, id, tagID string) error
+	CreateAgent(ctx context.Context, agent Agent) (*Agent, error)
+	GetAgents(ctx context.Context) ([]Agent, error)
+	GetAgentByID(ctx context.Context, id string) (*Agent, error)
+	UpdateAgent(ctx context.Context, id string, agent Agent) (*Agent, error)
+	GetMessageByID(ctx context.Context, tenantID, id string) (*MessageDetail, error)
+	DeleteMessage(ctx context.Context, id string) error
+	UpdateMessageMetadata(ctx context.Context, tenantID, id string, metadata map[string]any) (*MessageDetail, error)
+	CreateContact(ctx context.Context, contact Contact) (*Contact, error)
+	GetContacts(ctx context.Context, query ContactQuery) (*ContactPage, error)
+	GetContactByID(ctx context.Context, id string) (*Contact, error)
+	UpdateContact(ctx context.Context, id string, contact Contact) (*Contact, error)
+	DeleteContact(ctx context.Context, id string) error
+	MergeContacts(ctx context.Context, targetID, sourceID string) (*Contact, error)
+	UnmergeContact(ctx context.Context, id string) (*Contact, error)
+	GetContactTimeline(ctx context.Context, id string, query TimelineQuery) (*TimelinePage, error)
+	CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	AuthenticateAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
 	Close() error
 	GetDriver() *sql.DB
 }
@@ -51,69 +102,216 @@ func (r *PostgresRepository) GetDriver() *sql.DB {
 	return r.db
 }
 
-func (r *PostgresRepository) CreateMessage(ctx context.Context, msg Message) (*int64, error) {
+// maxTxAttempts is the number of times a serializable transaction is attempted
+// before a serialization failure is returned to the caller.
+const maxTxAttempts = 5
+
+// withSerializableTx runs fn in a serializable transaction and commits it. Concurrent
+// writers touching the same conversation can make Postgres abort one of them, in which
+// case the whole transaction is retried.
+func (r *PostgresRepository) withSerializableTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = r.runSerializableTx(ctx, fn)
+		if !isSerializationFailure(err) {
+			return err
+		}
+		log.Warnf("transaction aborted by a concurrent update, retrying (%d/%d)", attempt, maxTxAttempts)
+	}
+
+	return err
+}
+
+func (r *PostgresRepository) runSerializableTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelSerializable,
 	})
 	if err != nil {
-		return nil, err
+		return apperrors.NewDBError(err, "failed to begin transaction")
 	}
 	defer tx.Rollback() // Ensure rollback on error, unless committed
 
-	var fromID, toID, conversationID, messageID int64
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// isSerializationFailure reports whether err was caused by Postgres aborting a
+// transaction that conflicted with a concurrent one.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	return pqErr.Code == "40001" || pqErr.Code == "40P01" // serialization_failure, deadlock_detected
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// CreateMessage stores a message and returns its ID. Inbound messages are unique per
+// channel and provider ID: for a redelivered one the existing message ID is returned
+// and created is false.
+func (r *PostgresRepository) CreateMessage(ctx context.Context, msg Message) (*int64, bool, error) {
+	var (
+		messageID int64
+		created   bool
+	)
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		messageID, created, err = createMessage(ctx, tx, msg)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Picking an agent updates a row every new conversation competes for, so it happens
+	// after the message is stored rather than holding up its serializable transaction.
+	// The message is kept when no agent could be assigned.
+	if created && msg.Direction == DirectionInbound {
+		if err := r.assignNewConversation(ctx, messageID); err != nil {
+			log.Errorf("failed to assign the conversation of message %d: %v", messageID, err)
+		}
+	}
+
+	return &messageID, created, nil
+}
+
+// isDeduplicated reports whether msg is an inbound message whose provider ID identifies
+// redeliveries of it.
+func (msg Message) isDeduplicated() bool {
+	return msg.Direction == DirectionInbound && msg.ProviderID != ""
+}
+
+// participants returns the sender and recipients of msg without duplicates.
+func (msg Message) participants() []string {
+	participants := []string{msg.From}
+	for _, to := range msg.To {
+		if !slices.Contains(participants, to) {
+			participants = append(participants, to)
+		}
+	}
+	return participants
+}
+
+// findInboundMessage returns the ID of the inbound message already stored for msg's
+// channel and provider ID.
+func findInboundMessage(ctx context.Context, tx *sql.Tx, msg Message) (int64, bool, error) {
+	var messageID int64
+
+	findMessageQuery := `
+		SELECT id
+		FROM messages
+		WHERE channel = $1 AND provider_id = $2 AND message_status = $3
+	`
+	err := tx.QueryRowContext(ctx, findMessageQuery, msg.CommunicationType, msg.ProviderID, MessageStatusReceived).Scan(&messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, apperrors.NewDBError(err, "failed to look up inbound message")
+	}
+
+	return messageID, true, nil
+}
+
+func createMessage(ctx context.Context, tx *sql.Tx, msg Message) (int64, bool, error) {
+	var fromID, conversationID, messageID int64
+
+	// 0. Skip inbound messages the provider already delivered
+	if msg.isDeduplicated() {
+		existingID, found, err := findInboundMessage(ctx, tx, msg)
+		if err != nil {
+			return 0, false, err
+		}
+		if found {
+			return existingID, false, nil
+		}
+	}
 
 	// 1. Upsert communications
+	identifiers := msg.participants()
 	upsertCommQuery := `
 		INSERT INTO communications (identifier, communication_type)
-		VALUES ($1, $2)
+		SELECT unnest($1::text[]), $2
 		ON CONFLICT (identifier) DO NOTHING
 	`
 
-	if _, err := tx.ExecContext(ctx, upsertCommQuery, msg.From, msg.CommunicationType); err != nil {
-		return nil, apperrors.NewDBError(err, "failed to upsert communication for sender")
-	}
-	if _, err := tx.ExecContext(ctx, upsertCommQuery, msg.To, msg.CommunicationType); err != nil {
-		return nil, apperrors.NewDBError(err, "failed to upsert communication for recipient")
+	if _, err := tx.ExecContext(ctx, upsertCommQuery, pq.Array(identifiers), msg.CommunicationType); err != nil {
+		return 0, false, apperrors.NewDBError(err, "failed to upsert communications for participants")
 	}
 
-	// 2. Lookup IDs for both communications
-	getCommIDQuery := `SELECT id FROM communications WHERE identifier = $1`
-	if err := tx.QueryRowContext(ctx, getCommIDQuery, msg.From).Scan(&fromID); err != nil {
-		return nil, apperrors.NewDBError(err, "failed to get sender communication ID")
+	// 2. Lookup IDs for all participants
+	getCommIDsQuery := `SELECT id, identifier FROM communications WHERE identifier = ANY($1) ORDER BY id`
+	rows, err := tx.QueryContext(ctx, getCommIDsQuery, pq.Array(identifiers))
+	if err != nil {
+		return 0, false, apperrors.NewDBError(err, "failed to get participant communication IDs")
 	}
-	if err := tx.QueryRowContext(ctx, getCommIDQuery, msg.To).Scan(&toID); err != nil {
-		return nil, apperrors.NewDBError(err, "failed to get recipient communication ID")
+	participantIDs := make([]int64, 0, len(identifiers))
+	for rows.Next() {
+		var id int64
+		var identifier string
+		if err := rows.Scan(&id, &identifier); err != nil {
+			rows.Close()
+			return 0, false, apperrors.NewDBError(err, "failed to scan participant communication ID")
+		}
+		if identifier == msg.From {
+			fromID = id
+		}
+		participantIDs = append(participantIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, false, apperrors.NewDBError(err, "encountered error while iterating database rows")
 	}
 
-	// 3. Try to find an existing conversation with just these two participants
+	// 3. Try to find an existing conversation with exactly these participants
 	findConversationQuery := `
 		SELECT cm.conversation_id
 		FROM conversation_memberships cm
-		WHERE cm.communication_id IN ($1, $2)
+		WHERE cm.conversation_id IN (
+			SELECT conversation_id FROM conversation_memberships WHERE communication_id = $1
+		)
 		GROUP BY cm.conversation_id
-		HAVING COUNT(*) = 2 AND bool_and(cm.communication_id IN ($1, $2))
+		HAVING COUNT(*) = cardinality($2::bigint[]) AND bool_and(cm.communication_id = ANY($2))
+		ORDER BY cm.conversation_id
 		LIMIT 1
 	`
 
-	if err := tx.QueryRowContext(ctx, findConversationQuery, fromID, toID).Scan(&conversationID); err != nil {
+	if err := tx.QueryRowContext(ctx, findConversationQuery, fromID, pq.Array(participantIDs)).Scan(&conversationID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// 4. Create new conversation
-			createConvQuery := `INSERT INTO conversations (created_at) VALUES (now()) RETURNING id`
-			if err := tx.QueryRowContext(ctx, createConvQuery).Scan(&conversationID); err != nil {
-				return nil, apperrors.NewDBError(err, "failed to create new conversation")
+			createConvQuery := `
+				INSERT INTO conversations (created_at, last_activity_at, communication_type)
+				VALUES (now(), $1, $2)
+				RETURNING id
+			`
+			if err := tx.QueryRowContext(ctx, createConvQuery, msg.CreatedAt, msg.CommunicationType).Scan(&conversationID); err != nil {
+				return 0, false, apperrors.NewDBError(err, "failed to create new conversation")
 			}
 
 			// 5. Insert conversation memberships
 			insertMembershipQuery := `
 				INSERT INTO conversation_memberships (conversation_id, communication_id)
-				VALUES ($1, $2), ($1, $3)
+				SELECT $1, unnest($2::bigint[])
 				ON CONFLICT DO NOTHING
 			`
-			if _, err := tx.ExecContext(ctx, insertMembershipQuery, conversationID, fromID, toID); err != nil {
-				return nil, apperrors.NewDBError(err, "failed to insert conversation memberships")
+			if _, err := tx.ExecContext(ctx, insertMembershipQuery, conversationID, pq.Array(participantIDs)); err != nil {
+				return 0, false, apperrors.NewDBError(err, "failed to insert conversation memberships")
 			}
 		} else {
-			return nil, err
+			return 0, false, apperrors.NewDBError(err, "failed to find conversation")
 		}
 	}
 
@@ -124,12 +322,14 @@ func (r *PostgresRepository) CreateMessage(ctx context.Context, msg Message) (*i
 			sender_id,
 			provider_id,
 			message_type,
+			channel,
+			direction,
 			body,
 			attachments,
 			created_at,
 			message_status
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
 	`
 
@@ -138,67 +338,197 @@ func (r *PostgresRepository) CreateMessage(ctx context.Context, msg Message) (*i
 		fromID,
 		msg.ProviderID,
 		msg.Type,
+		msg.CommunicationType,
+		msg.Direction,
 		msg.Body,
 		pq.Array(msg.Attachments),
 		msg.CreatedAt,
 		msg.Status,
 	).Scan(&messageID); err != nil {
-		return nil, apperrors.NewDBError(err, "failed to insert message")
+		return 0, false, apperrors.NewDBError(err, "failed to insert message")
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, apperrors.NewDBError(err, "failed to commit transaction")
+	// 7. Keep the conversation's activity, last message and count up to date. Messages
+	// can arrive out of order, so the last message only moves forward in time. A new
+	// inbound message reopens the conversation.
+	touchConversationQuery := `
+		UPDATE conversations
+		SET message_count = message_count + 1,
+			inbound_count = inbound_count + CASE WHEN $4 = 'inbound' THEN 1 ELSE 0 END,
+			status = CASE WHEN $4 = 'inbound' THEN 'open' ELSE status END,
+			snoozed_until = CASE WHEN $4 = 'inbound' THEN NULL ELSE snoozed_until END,
+			status_updated_at = CASE
+				WHEN $4 = 'inbound' AND status <> 'open' THEN now()
+				ELSE status_updated_at
+			END,
+			last_activity_at = GREATEST(last_activity_at, $2),
+			last_message_id = CASE
+				WHEN last_message_id IS NULL
+					OR ($2::timestamptz, $3::bigint) >= (SELECT created_at, id FROM messages WHERE id = last_message_id)
+				THEN $3
+				ELSE last_message_id
+			END
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, touchConversationQuery, conversationID, msg.CreatedAt, messageID, msg.Direction); err != nil {
+		return 0, false, apperrors.NewDBError(err, "failed to update conversation activity")
 	}
 
-	return &messageID, nil
+	if err := recordStatus(ctx, tx, messageID, msg.Status, "", ""); err != nil {
+		return 0, false, err
+	}
+
+	return messageID, true, nil
 }
 
-func (r *PostgresRepository) GetConversations(ctx context.Context) ([]Conversation, error) {
-	const query = `
+// GetConversations returns a page of the conversations matching the query's filters with
+// their participants, most recently active first unless sorted otherwise. Conversations
+// with the same sort timestamp are ordered by ID so pages never skip or repeat one.
+func (r *PostgresRepository) GetConversations(ctx context.Context, query ConversationQuery) (*ConversationPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	var afterTime sql.NullTime
+	var afterID int64
+	if query.After != nil {
+		afterTime = sql.NullTime{Time: query.After.Time, Valid: true}
+		afterID = query.After.ID
+	}
+
+	sortColumn := SortByLastActivity
+	if query.SortBy == SortByCreatedAt {
+		sortColumn = SortByCreatedAt
+	}
+	comparison, order := "<", "DESC"
+	if query.Ascending {
+		comparison, order = ">", "ASC"
+	}
+
+	args := []any{afterTime, afterID, limit + 1, query.UserID, query.TenantID}
+	filters := conversationFilters(query, sortColumn, &args)
+
+	// One extra conversation is fetched to learn whether there is a next page.
+	conversationsQuery := fmt.Sprintf(`
+		WITH page AS (
+			SELECT
+				c.id, c.created_at, c.last_activity_at, c.last_message_id, c.message_count,
+				`+effectiveStatus+` AS status, c.snoozed_until, c.assignee_id,
+				c.inbound_count - COALESCE(cr.read_inbound_count, 0) AS unread_count,
+				c.%[1]s AS sort_key
+			FROM conversations c
+			LEFT JOIN conversation_reads cr ON cr.conversation_id = c.id AND cr.user_id = $4
+			WHERE ($1::timestamptz IS NULL OR (c.%[1]s, c.id) %[2]s ($1, $2))%[4]s
+			ORDER BY c.%[1]s %[3]s, c.id %[3]s
+			LIMIT $3
+		)
 		SELECT
-		  c.id AS conversation_id,
-		  c.created_at,
+		  page.id AS conversation_id,
+		  page.sort_key,
+		  page.created_at,
+		  page.last_activity_at,
+		  page.status,
+		  page.snoozed_until,
+		  page.assignee_id,
+		  ARRAY(
+			SELECT t.name
+			FROM conversation_tags ct
+			JOIN tags t ON t.id = ct.tag_id
+			WHERE ct.conversation_id = page.id AND t.tenant_id = $5
+			ORDER BY lower(t.name)
+		  ) AS tags,
+		  page.message_count,
+		  page.unread_count,
+		  lm.id AS last_message_id,
+		  sender.identifier AS last_message_sender,
+		  lm.message_type,
+		  lm.body,
+		  lm.message_status,
+		  lm.created_at AS last_message_created_at,
 		  comm.id AS participant_id,
 		  comm.identifier,
 		  comm.communication_type
-		FROM conversations c
-		LEFT JOIN conversation_memberships cm ON cm.conversation_id = c.id
+		FROM page
+		LEFT JOIN messages lm ON lm.id = page.last_message_id
+		LEFT JOIN communications sender ON sender.id = lm.sender_id
+		LEFT JOIN conversation_memberships cm ON cm.conversation_id = page.id
 		LEFT JOIN communications comm ON comm.id = cm.communication_id
-		WHERE EXISTS (
-			SELECT 1 FROM messages m
-			WHERE m.conversation_id = c.id AND m.message_status = $1
-		)
-		ORDER BY c.created_at DESC;
-	`
+		ORDER BY page.sort_key %[3]s, page.id %[3]s, comm.id;
+	`, sortColumn, comparison, order, filters)
 
-	rows, err := r.db.QueryContext(ctx, query, MessageStatusSuccess)
+	rows, err := r.db.QueryContext(ctx, conversationsQuery, args...)
 	if err != nil {
-		return nil, err
+		return nil, apperrors.NewDBError(err, "failed to query conversations")
 	}
 	defer rows.Close()
 
 	conversationMap := make(map[int64]*Conversation)
+	sortKeys := make(map[int64]time.Time)
 	orderedIDs := make([]int64, 0)
 
 	for rows.Next() {
-		var convID int64
-		var createdAt time.Time
-		var participantID sql.NullInt64
-		var identifier sql.NullString
-		var commType sql.NullString
+		var (
+			convID                    int64
+			sortKey                   time.Time
+			createdAt, lastActivityAt time.Time
+			status                    string
+			snoozedUntil              sql.NullTime
+			assigneeID                sql.NullInt64
+			tags                      pq.StringArray
+			messageCount              int
+			unreadCount               int
+			lastMessageID             sql.NullInt64
+			lastSender                sql.NullString
+			lastType                  sql.NullString
+			lastBody                  sql.NullString
+			lastStatus                sql.NullString
+			lastCreatedAt             sql.NullTime
+			participantID             sql.NullInt64
+			identifier                sql.NullString
+			commType                  sql.NullString
+		)
 
-		if err := rows.Scan(&convID, &createdAt, &participantID, &identifier, &commType); err != nil {
+		if err := rows.Scan(
+			&convID, &sortKey, &createdAt, &lastActivityAt, &status, &snoozedUntil, &assigneeID, &tags, &messageCount, &unreadCount,
+			&lastMessageID, &lastSender, &lastType, &lastBody, &lastStatus, &lastCreatedAt,
+			&participantID, &identifier, &commType,
+		); err != nil {
 			return nil, apperrors.NewDBError(err, "failed to scan conversation row")
 		}
 
 		conv, exists := conversationMap[convID]
 		if !exists {
 			conv = &Conversation{
-				ID:           convID,
-				CreatedAt:    createdAt.Format(time.RFC3339),
-				Participants: []Communication{},
+				ID:             convID,
+				CreatedAt:      createdAt.Format(time.RFC3339),
+				LastActivityAt: lastActivityAt.Format(time.RFC3339),
+				Status:         status,
+				Tags:           tags,
+				MessageCount:   messageCount,
+				Participants:   []Communication{},
+			}
+			if status == ConversationStatusSnoozed {
+				conv.SnoozedUntil = snoozedUntil.Time.Format(time.RFC3339)
+			}
+			if assigneeID.Valid {
+				conv.AssigneeID = &assigneeID.Int64
+			}
+			if query.UserID != "" {
+				conv.UnreadCount = &unreadCount
+			}
+			if lastMessageID.Valid {
+				conv.LastMessage = &MessagePreview{
+					ID:        lastMessageID.Int64,
+					From:      lastSender.String,
+					Type:      lastType.String,
+					Snippet:   snippet(lastBody.String),
+					Status:    lastStatus.String,
+					CreatedAt: lastCreatedAt.Time.Format(time.RFC3339),
+				}
 			}
 			conversationMap[convID] = conv
+			sortKeys[convID] = sortKey
 			orderedIDs = append(orderedIDs, convID) // record retrieval order
 		}
 
@@ -215,89 +545,283 @@ func (r *PostgresRepository) GetConversations(ctx context.Context) ([]Conversati
 		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
 	}
 
-	conversations := make([]Conversation, 0, len(orderedIDs))
+	page := &ConversationPage{Conversations: make([]Conversation, 0, limit)}
+	if len(orderedIDs) > limit {
+		orderedIDs = orderedIDs[:limit]
+		last := orderedIDs[limit-1]
+		page.NextCursor = Cursor{Time: sortKeys[last], ID: last}.Encode()
+	}
+
 	for _, id := range orderedIDs {
-		conversations = append(conversations, *conversationMap[id])
+		page.Conversations = append(page.Conversations, *conversationMap[id])
 	}
 
-	return conversations, nil
+	return page, nil
 }
 
-func (r *PostgresRepository) GetConversationByID(ctx context.Context, id string) (*Conversation, error) {
-	const query = `
+// conversationFilters returns the SQL conditions, each starting with AND, selecting the
+// conversations c matching the query's filters. Their arguments are appended to args.
+func conversationFilters(query ConversationQuery, sortColumn string, args *[]any) string {
+	var filters strings.Builder
+	arg := func(value any) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	if query.Participant != "" {
+		condition := "comm.identifier = " + arg(query.Participant)
+		if query.ParticipantPrefix {
+			condition = "comm.identifier LIKE " + arg(likePrefix(query.Participant))
+		}
+		filters.WriteString(`
+			  AND c.id IN (
+				SELECT cm.conversation_id
+				FROM conversation_memberships cm
+				JOIN communications comm ON comm.id = cm.communication_id
+				WHERE ` + condition + `
+			  )`)
+	}
+	if query.CommunicationType != "" {
+		filters.WriteString(" AND c.communication_type = " + arg(query.CommunicationType))
+	}
+	if !query.From.IsZero() {
+		filters.WriteString(fmt.Sprintf(" AND c.%s >= %s", sortColumn, arg(query.From)))
+	}
+	if !query.To.IsZero() {
+		filters.WriteString(fmt.Sprintf(" AND c.%s < %s", sortColumn, arg(query.To)))
+	}
+	if query.HasFailed != nil {
+		not := ""
+		if !*query.HasFailed {
+			not = "NOT "
+		}
+		filters.WriteString(fmt.Sprintf(`
+			  AND %sEXISTS (
+				SELECT 1 FROM messages fm
+				WHERE fm.conversation_id = c.id AND fm.deleted_at IS NULL
+				  AND fm.message_status IN ('failed', 'undelivered')
+			  )`, not))
+	}
+	if len(query.Statuses) > 0 {
+		conditions := make([]string, 0, len(query.Statuses))
+		for _, status := range query.Statuses {
+			conditions = append(conditions, statusConditions[status])
+		}
+		filters.WriteString(" AND (" + strings.Join(conditions, " OR ") + ")")
+	}
+	if query.Unassigned {
+		filters.WriteString(" AND c.assignee_id IS NULL")
+	} else if query.AssigneeID != 0 {
+		*args = append(*args, query.AssigneeID)
+		filters.WriteString(fmt.Sprintf(" AND c.assignee_id = $%d", len(*args)))
+	}
+	if len(query.Tags) > 0 {
+		*args = append(*args, pq.Array(query.Tags))
+		filters.WriteString(fmt.Sprintf(`
+			  AND NOT EXISTS (
+				SELECT 1
+				FROM unnest($%d::text[]) AS wanted(name)
+				WHERE NOT EXISTS (
+					SELECT 1
+					FROM conversation_tags ct
+					JOIN tags t ON t.id = ct.tag_id
+					WHERE ct.conversation_id = c.id AND t.tenant_id = $5 AND lower(t.name) = lower(wanted.name)
+				)
+			  )`, len(*args)))
+	}
+
+	return filters.String()
+}
+
+// likePrefix returns a LIKE pattern matching values starting with prefix.
+func likePrefix(prefix string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return escaper.Replace(prefix) + "%"
+}
+
+// GetConversationByID returns a conversation with a page of its messages, ordered by
+// timestamp with the message ID breaking ties.
+func (r *PostgresRepository) GetConversationByID(ctx context.Context, id string, query MessageQuery) (*MessagePage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	var (
+		convID    int64
+		createdAt time.Time
+	)
+	const conversationQuery = `SELECT id, created_at FROM conversations WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, conversationQuery, id).Scan(&convID, &createdAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query conversation %s", id))
+	}
+
+	// Pages are read walking away from the cursor, or from the requested end of the
+	// conversation when there is none, and flipped afterwards if needed.
+	readDescending := query.Before != 0 || (query.After == 0 && query.Descending)
+	comparison, order := ">", "ASC"
+	if readDescending {
+		comparison, order = "<", "DESC"
+	}
+
+	cursorID := query.After
+	if query.Before != 0 {
+		cursorID = query.Before
+	}
+
+	var cursorTime sql.NullTime
+	if cursorID != 0 {
+		const cursorQuery = `SELECT created_at FROM messages WHERE id = $1 AND conversation_id = $2`
+		if err := r.db.QueryRowContext(ctx, cursorQuery, cursorID, convID).Scan(&cursorTime); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, apperrors.DBErrorNotFound
+			}
+			return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query message %d", cursorID))
+		}
+	}
+
+	messagesQuery := fmt.Sprintf(`
 		SELECT
-			c.id AS conversation_id,
-			c.created_at,
 			m.id AS message_id,
 			comm.identifier AS sender_identifier,
 			m.message_type,
+			m.direction,
 			m.body,
 			m.attachments,
 			m.provider_id,
-			m.created_at AS message_created_at
-		FROM conversations c
-		LEFT JOIN messages m ON m.conversation_id = c.id
-		LEFT JOIN communications comm ON comm.id = m.sender_id
-		WHERE c.id = $1 AND m.message_status = $2
-		ORDER BY m.created_at;
-	`
-
-	rows, err := r.db.QueryContext(ctx, query, id, MessageStatusSuccess)
+			m.message_status,
+			m.status_updated_at,
+			m.error_code,
+			m.created_at AS message_created_at,
+			recipients.statuses
+		FROM messages m
+		JOIN communications comm ON comm.id = m.sender_id
+		LEFT JOIN LATERAL (
+			SELECT json_agg(json_build_object(
+				'to', rc.identifier,
+				'status', mr.message_status,
+				'provider_id', COALESCE(mr.provider_id, ''),
+				'error_code', COALESCE(mr.error_code, '')
+			) ORDER BY rc.id) AS statuses
+			FROM message_recipients mr
+			JOIN communications rc ON rc.id = mr.communication_id
+			WHERE mr.message_id = m.id
+		) recipients ON true
+		WHERE m.conversation_id = $1
+		  AND m.deleted_at IS NULL
+		  AND ($2::timestamptz IS NULL OR (m.created_at, m.id) %[1]s ($2, $3))
+		  AND ($5::message_direction IS NULL OR m.direction = $5)
+		ORDER BY m.created_at %[2]s, m.id %[2]s
+		LIMIT $4;
+	`, comparison, order)
+
+	rows, err := r.db.QueryContext(ctx, messagesQuery, convID, cursorTime, cursorID, limit+1, nullString(query.Direction))
 	if err != nil {
-		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query conversation %s", id))
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query messages of conversation %s", id))
 	}
 	defer rows.Close()
 
-	var conv *Conversation
+	page := &MessagePage{
+		Conversation: Conversation{
+			ID:        convID,
+			CreatedAt: createdAt.Format(time.RFC3339),
+			Messages:  []Message{},
+		},
+	}
+
+	var times []time.Time
 	for rows.Next() {
 		var (
-			convID      int64
-			createdAt   time.Time
-			msgID       sql.NullInt64
-			from        sql.NullString
-			msgType     sql.NullString
+			msgID       int64
+			from        string
+			msgType     string
+			direction   string
 			body        sql.NullString
 			attachments pq.StringArray
 			providerID  sql.NullString
-			timestamp   sql.NullTime
+			status      string
+			statusAt    time.Time
+			errorCode   sql.NullString
+			timestamp   time.Time
+			statuses    []byte
+			recipients  []Recipient
 		)
 
 		if err := rows.Scan(
-			&convID, &createdAt,
-			&msgID, &from, &msgType, &body,
-			&attachments, &providerID, &timestamp,
+			&msgID, &from, &msgType, &direction, &body,
+			&attachments, &providerID, &status,
+			&statusAt, &errorCode, &timestamp, &statuses,
 		); err != nil {
-			return nil, apperrors.NewDBError(err, "failed to scan conversation row")
+			return nil, apperrors.NewDBError(err, "failed to scan message row")
 		}
 
-		if conv == nil {
-			conv = &Conversation{
-				ID:        convID,
-				CreatedAt: createdAt.Format(time.RFC3339),
-				Messages:  []Message{},
+		if statuses != nil {
+			if err := json.Unmarshal(statuses, &recipients); err != nil {
+				return nil, apperrors.NewDBError(err, "failed to decode message recipients")
 			}
 		}
 
-		if msgID.Valid {
-			conv.Messages = append(conv.Messages, Message{
-				ID:          msgID.Int64,
-				From:        from.String,
-				Type:        msgType.String,
-				Body:        body.String,
-				Attachments: attachments,
-				ProviderID:  providerID.String,
-				CreatedAt:   timestamp.Time.Format(time.RFC3339),
-			})
-		}
+		page.Messages = append(page.Messages, Message{
+			ID:              msgID,
+			From:            from,
+			Type:            msgType,
+			Direction:       direction,
+			Body:            body.String,
+			Attachments:     attachments,
+			ProviderID:      providerID.String,
+			Status:          status,
+			StatusUpdatedAt: statusAt.Format(time.RFC3339),
+			ErrorCode:       errorCode.String,
+			CreatedAt:       timestamp.Format(time.RFC3339),
+			Recipients:      recipients,
+		})
+		times = append(times, timestamp)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
 	}
 
-	if conv == nil {
-		return nil, apperrors.DBErrorNotFound
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		times = times[:limit]
+		page.HasMore = true
+	}
+
+	// Notes are placed between the messages they were written after, so each note shows
+	// up on exactly one page: the one whose messages span its timestamp.
+	var notesFrom, notesTo sql.NullTime
+	if readDescending {
+		notesTo = cursorTime
+		if page.HasMore {
+			notesFrom = sql.NullTime{Time: times[limit-1], Valid: true}
+		}
+		slices.Reverse(page.Messages)
+		slices.Reverse(times)
+	} else {
+		notesFrom = cursorTime
+		if page.HasMore {
+			notesTo = sql.NullTime{Time: times[limit-1], Valid: true}
+		}
+	}
+
+	// Notes have no direction, so filtering by one leaves them out.
+	var notes []Note
+	var noteTimes []time.Time
+	if query.Direction == "" {
+		if notes, noteTimes, err = loadNotes(ctx, r.db, convID, notesFrom, notesTo); err != nil {
+			return nil, err
+		}
+	}
+	page.Items = interleaveNotes(page.Messages, times, notes, noteTimes)
+
+	if query.Descending {
+		slices.Reverse(page.Messages)
+		slices.Reverse(page.Items)
 	}
 
-	return conv, nil
+	return page, nil
 }
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// searchHeadlineOptions configures the highlighted snippet of each search result.
+const searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter= … "
+
+// SearchMessages returns a page of the messages whose body matches query.Text, best
+// match first. HTML bodies are searched and highlighted without their markup. A tag
+// filter matches tags on the message or on its conversation.
+func (r *PostgresRepository) SearchMessages(ctx context.Context, query SearchQuery) (*SearchPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	var afterRank sql.NullFloat64
+	var afterID int64
+	if query.After != nil {
+		afterRank = sql.NullFloat64{Float64: float64(query.After.Rank), Valid: true}
+		afterID = query.After.ID
+	}
+
+	// One extra result is fetched to learn whether there is a next page. The rank is
+	// compared as real, the type ts_rank_cd returns, so cursors match exactly. Bodies are
+	// HTML escaped before highlighting, since markup strip_html leaves behind (e.g. an
+	// unclosed tag) would otherwise reach clients next to the <mark> tags.
+	const searchQuery = `
+		WITH query AS (
+			SELECT websearch_to_tsquery('english', $1) AS tsquery
+		), matches AS (
+			SELECT m.*, ts_rank_cd(m.search_vector, query.tsquery) AS rank, query.tsquery
+			FROM messages m, query
+			WHERE m.search_vector @@ query.tsquery
+			  AND m.deleted_at IS NULL
+			  AND ($2::communication_type IS NULL OR m.channel = $2)
+			  AND ($3::message_direction IS NULL OR m.direction = $3)
+			  AND ($4::timestamptz IS NULL OR m.created_at >= $4)
+			  AND ($5::timestamptz IS NULL OR m.created_at < $5)
+			  AND ($6::text IS NULL OR EXISTS (
+				SELECT 1
+				FROM conversation_memberships cm
+				JOIN communications pc ON pc.id = cm.communication_id
+				WHERE cm.conversation_id = m.conversation_id AND pc.identifier = $6
+			  ))
+			  AND ($11::text[] IS NULL OR NOT EXISTS (
+				SELECT 1
+				FROM unnest($11::text[]) AS wanted(name)
+				WHERE NOT EXISTS (
+					SELECT 1
+					FROM tags t
+					WHERE t.tenant_id = $12 AND lower(t.name) = lower(wanted.name)
+					  AND (
+						EXISTS (SELECT 1 FROM message_tags mt WHERE mt.message_id = m.id AND mt.tag_id = t.id)
+						OR EXISTS (SELECT 1 FROM conversation_tags ct WHERE ct.conversation_id = m.conversation_id AND ct.tag_id = t.id)
+					  )
+				)
+			  ))
+		)
+		SELECT
+			matches.id,
+			matches.conversation_id,
+			comm.identifier AS sender_identifier,
+			matches.message_type,
+			matches.channel,
+			matches.direction,
+			matches.body,
+			matches.attachments,
+			matches.provider_id,
+			matches.message_status,
+			matches.created_at,
+			matches.rank,
+			ts_headline('english',
+				replace(replace(replace(replace(replace(strip_html(matches.body),
+					'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+				matches.tsquery, $9)
+		FROM matches
+		JOIN communications comm ON comm.id = matches.sender_id
+		WHERE $7::real IS NULL OR (matches.rank, matches.id) < ($7::real, $8)
+		ORDER BY matches.rank DESC, matches.id DESC
+		LIMIT $10
+	`
+
+	rows, err := r.db.QueryContext(ctx, searchQuery,
+		query.Text,
+		nullString(query.Channel),
+		nullString(query.Direction),
+		nullTime(query.From),
+		nullTime(query.To),
+		nullString(query.Participant),
+		afterRank,
+		afterID,
+		searchHeadlineOptions,
+		limit+1,
+		pq.Array(query.Tags),
+		query.TenantID,
+	)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to search messages")
+	}
+	defer rows.Close()
+
+	page := &SearchPage{Results: make([]SearchResult, 0, limit)}
+	for rows.Next() {
+		var (
+			result      SearchResult
+			body        sql.NullString
+			attachments pq.StringArray
+			providerID  sql.NullString
+			createdAt   time.Time
+		)
+
+		if err := rows.Scan(
+			&result.ID, &result.ConversationID, &result.From, &result.Type,
+			&result.CommunicationType, &result.Direction, &body, &attachments,
+			&providerID, &result.Status, &createdAt, &result.Rank, &result.Highlight,
+		); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan search result row")
+		}
+
+		if len(page.Results) == limit {
+			last := page.Results[limit-1]
+			page.NextCursor = SearchCursor{Rank: last.Rank, ID: last.ID}.Encode()
+			break
+		}
+
+		result.Body = body.String
+		result.Attachments = attachments
+		result.ProviderID = providerID.String
+		result.CreatedAt = createdAt.Format(time.RFC3339)
+		page.Results = append(page.Results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return page, nil
+}
+
+// nullString passes an empty string to a query as NULL, which disables optional filters.
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// nullTime passes a zero time to a query as NULL, which disables optional filters.
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value, Valid: !value.IsZero()}
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+
+	"github.com/lib/pq"
+)
+
+// statusRanks orders outbound statuses by how far along delivery they are. Statuses of
+// the same rank are terminal alternatives of each other.
+var statusRanks = map[string]int{
+	MessageStatusQueued:      0,
+	MessageStatusSending:     1,
+	MessageStatusSent:        2,
+	MessageStatusDelivered:   3,
+	MessageStatusUndelivered: 3,
+	MessageStatusFailed:      3,
+	MessageStatusRead:        4,
+}
+
+// CanTransition reports whether an outbound message may move from status current to
+// next. Only forward moves are allowed, so late or out-of-order provider callbacks
+// (e.g. sent after delivered) cannot regress a message. Only a message that reached its
+// recipient can be read, so failed and undelivered messages stay that way.
+func CanTransition(current, next string) bool {
+	currentRank, currentKnown := statusRanks[current]
+	nextRank, nextKnown := statusRanks[next]
+	if !currentKnown || !nextKnown {
+		return false
+	}
+
+	if next == MessageStatusRead {
+		return current == MessageStatusSent || current == MessageStatusDelivered
+	}
+
+	return nextRank > currentRank
+}
+
+// aggregateStatus derives a message's status from the statuses of its recipients. While
+// any recipient is in flight the least advanced one decides. Once all are done a failure
+// wins, so the message shows up as retryable; otherwise the least advanced one decides.
+func aggregateStatus(statuses []string) string {
+	var inFlight, failed, undelivered bool
+	least := ""
+	for _, status := range statuses {
+		switch status {
+		case MessageStatusQueued, MessageStatusSending:
+			inFlight = true
+		case MessageStatusFailed:
+			failed = true
+		case MessageStatusUndelivered:
+			undelivered = true
+		}
+		if least == "" || statusRanks[status] < statusRanks[least] {
+			least = status
+		}
+	}
+
+	switch {
+	case inFlight:
+		return least
+	case failed:
+		return MessageStatusFailed
+	case undelivered:
+		return MessageStatusUndelivered
+	}
+	return least
+}
+
+// recordStatus appends a status to the history of a message.
+func recordStatus(ctx context.Context, tx *sql.Tx, messageID int64, status, errorCode, errorMessage string) error {
+	insertHistoryQuery := `
+		INSERT INTO message_status_history (message_id, message_status, provider_error_code, error_message)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+	`
+	if _, err := tx.ExecContext(ctx, insertHistoryQuery, messageID, status, errorCode, errorMessage); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to record status history for message %d", messageID))
+	}
+
+	return nil
+}
+
+// setMessageStatus updates the current status of a message and records it in the history.
+func setMessageStatus(ctx context.Context, tx *sql.Tx, messageID int64, status, errorCode, errorMessage string) error {
+	updateMessageQuery := `
+		UPDATE messages
+		SET message_status = $2, status_updated_at = now(), error_code = NULLIF($3, '')
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, updateMessageQuery, messageID, status, errorCode); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to update status of message %d", messageID))
+	}
+
+	return recordStatus(ctx, tx, messageID, status, errorCode, errorMessage)
+}
+
+// setRecipientStatus updates the delivery status of an outbound message for one of its
+// recipients, then derives the message's own status from all of its recipients.
+func setRecipientStatus(ctx context.Context, tx *sql.Tx, messageID int64, recipient, status, providerID, errorCode, errorMessage string) error {
+	updateRecipientQuery := `
+		UPDATE message_recipients mr
+		SET message_status = $3,
+			status_updated_at = now(),
+			provider_id = COALESCE(NULLIF($4, ''), mr.provider_id),
+			error_code = NULLIF($5, '')
+		FROM communications comm
+		WHERE comm.id = mr.communication_id AND mr.message_id = $1 AND comm.identifier = $2
+	`
+	if _, err := tx.ExecContext(ctx, updateRecipientQuery, messageID, recipient, status, providerID, errorCode); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to update status of message %d for %s", messageID, recipient))
+	}
+
+	// The message keeps the first provider ID it got; each recipient has its own.
+	if providerID != "" {
+		updateProviderIDQuery := `UPDATE messages SET provider_id = $2 WHERE id = $1 AND COALESCE(provider_id, '') = ''`
+		if _, err := tx.ExecContext(ctx, updateProviderIDQuery, messageID, providerID); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to update message %d", messageID))
+		}
+	}
+
+	return refreshMessageStatus(ctx, tx, messageID, errorCode, errorMessage)
+}
+
+// refreshMessageStatus sets a message's status from its recipients' statuses if it changed.
+func refreshMessageStatus(ctx context.Context, tx *sql.Tx, messageID int64, errorCode, errorMessage string) error {
+	// Locking the message serializes concurrent updates of its recipients, so the
+	// statuses read below include every committed recipient update.
+	var current string
+	lockMessageQuery := `SELECT message_status FROM messages WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, lockMessageQuery, messageID).Scan(&current); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to lock message %d", messageID))
+	}
+
+	var statuses pq.StringArray
+	recipientStatusesQuery := `SELECT array_agg(message_status::text) FROM message_recipients WHERE message_id = $1`
+	if err := tx.QueryRowContext(ctx, recipientStatusesQuery, messageID).Scan(&statuses); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to get recipient statuses of message %d", messageID))
+	}
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	status := aggregateStatus(statuses)
+	if status == current {
+		return nil
+	}
+
+	return setMessageStatus(ctx, tx, messageID, status, errorCode, errorMessage)
+}
+
+// RetryMessage queues the recipients a failed or undelivered outbound message did not
+// reach for another delivery attempt. Deleted messages are not retried.
+func (r *PostgresRepository) RetryMessage(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	var (
+		messageID int64
+		status    string
+		deletedAt sql.NullTime
+	)
+
+	findMessageQuery := `SELECT id, message_status, deleted_at FROM messages WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, findMessageQuery, id).Scan(&messageID, &status, &deletedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.DBErrorNotFound
+		}
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to find message %s", id))
+	}
+
+	if deletedAt.Valid || (status != MessageStatusFailed && status != MessageStatusUndelivered) {
+		return apperrors.DBErrorConflict
+	}
+
+	// Inbound messages have no recipients to deliver to.
+	var recipients pq.StringArray
+	findRecipientsQuery := `
+		SELECT array_agg(comm.identifier ORDER BY comm.id)
+		FROM message_recipients mr
+		JOIN communications comm ON comm.id = mr.communication_id
+		WHERE mr.message_id = $1 AND mr.message_status IN ($2, $3)
+	`
+	if err := tx.QueryRowContext(ctx, findRecipientsQuery, messageID, MessageStatusFailed, MessageStatusUndelivered).Scan(&recipients); err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to find recipients of message %d", messageID))
+	}
+	if len(recipients) == 0 {
+		return apperrors.DBErrorConflict
+	}
+
+	// The retry is sent with the subject of the earlier attempts.
+	insertOutboxQuery := `
+		INSERT INTO outbox (message_id, recipient, subject)
+		SELECT $1, recipient, (SELECT subject FROM outbox WHERE message_id = $1 ORDER BY id DESC LIMIT 1)
+		FROM unnest($2::text[]) AS recipient
+	`
+	if _, err := tx.ExecContext(ctx, insertOutboxQuery, messageID, recipients); err != nil {
+		return apperrors.NewDBError(err, "failed to insert outbox rows")
+	}
+
+	for _, recipient := range recipients {
+		if err := setRecipientStatus(ctx, tx, messageID, recipient, MessageStatusQueued, "", "", ""); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// UpdateMessageStatusByProviderID applies a provider status callback to the outbound
+// message recipient with the given provider ID and returns the message ID. It returns
+// apperrors.DBErrorConflict when the transition would move the recipient backwards.
+func (r *PostgresRepository) UpdateMessageStatusByProviderID(ctx context.Context, providerID, status, errorCode, errorMessage string) (*int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // Ensure rollback on error, unless committed
+
+	var (
+		messageID     int64
+		recipient     string
+		currentStatus string
+	)
+
+	findRecipientQuery := `
+		SELECT mr.message_id, comm.identifier, mr.message_status
+		FROM message_recipients mr
+		JOIN communications comm ON comm.id = mr.communication_id
+		WHERE mr.provider_id = $1
+		ORDER BY mr.message_id DESC
+		LIMIT 1
+		FOR UPDATE OF mr
+	`
+	if err := tx.QueryRowContext(ctx, findRecipientQuery, providerID).Scan(&messageID, &recipient, &currentStatus); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.DBErrorNotFound
+		}
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to find message with provider ID %s", providerID))
+	}
+
+	// Providers redeliver callbacks, so repeating the current status is not an error.
+	if currentStatus == status {
+		return &messageID, nil
+	}
+
+	if !CanTransition(currentStatus, status) {
+		return &messageID, apperrors.DBErrorConflict
+	}
+
+	if err := setRecipientStatus(ctx, tx, messageID, recipient, status, "", errorCode, errorMessage); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apperrors.NewDBError(err, "failed to commit transaction")
+	}
+
+	return &messageID, nil
+}
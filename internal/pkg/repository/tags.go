@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"time"
+)
+
+// tagTarget describes a resource tags can be put on.
+type tagTarget struct {
+	table     string
+	linkTable string
+	column    string
+}
+
+var (
+	conversationTagTarget = tagTarget{table: "conversations", linkTable: "conversation_tags", column: "conversation_id"}
+	messageTagTarget      = tagTarget{table: "messages", linkTable: "message_tags", column: "message_id"}
+)
+
+// CreateTag stores a tag of a tenant. It returns apperrors.DBErrorConflict if the tenant
+// has a tag with the same name, ignoring case.
+func (r *PostgresRepository) CreateTag(ctx context.Context, tenantID, name string) (*Tag, error) {
+	const insertTagQuery = `INSERT INTO tags (tenant_id, name) VALUES ($1, $2) RETURNING id, name, created_at`
+
+	var (
+		tag       Tag
+		createdAt time.Time
+	)
+	if err := r.db.QueryRowContext(ctx, insertTagQuery, tenantID, name).Scan(&tag.ID, &tag.Name, &createdAt); err != nil {
+		if isUniqueViolation(err) {
+			return nil, apperrors.DBErrorConflict
+		}
+		return nil, apperrors.NewDBError(err, "failed to insert tag")
+	}
+
+	tag.CreatedAt = createdAt.Format(time.RFC3339)
+	return &tag, nil
+}
+
+// GetTags returns the tags of a tenant by name, each with the number of conversations and
+// visible messages carrying it.
+func (r *PostgresRepository) GetTags(ctx context.Context, tenantID string) ([]TagCount, error) {
+	const tagsQuery = `
+		SELECT
+			t.id,
+			t.name,
+			t.created_at,
+			(SELECT count(*) FROM conversation_tags ct WHERE ct.tag_id = t.id),
+			(
+				SELECT count(*)
+				FROM message_tags mt
+				JOIN messages m ON m.id = mt.message_id
+				WHERE mt.tag_id = t.id AND m.deleted_at IS NULL
+			)
+		FROM tags t
+		WHERE t.tenant_id = $1
+		ORDER BY lower(t.name)
+	`
+	rows, err := r.db.QueryContext(ctx, tagsQuery, tenantID)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, "failed to query tags")
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var (
+			tag       TagCount
+			createdAt time.Time
+		)
+		if err := rows.Scan(&tag.ID, &tag.Name, &createdAt, &tag.ConversationCount, &tag.MessageCount); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan tag row")
+		}
+		tag.CreatedAt = createdAt.Format(time.RFC3339)
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return tags, nil
+}
+
+// DeleteTag deletes a tag of a tenant and removes it from every conversation and message.
+func (r *PostgresRepository) DeleteTag(ctx context.Context, tenantID, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM tags WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to delete tag %s", id))
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperrors.DBErrorNotFound
+	}
+
+	return nil
+}
+
+// TagConversation puts the tenant's tag with the given name on a conversation, creating
+// the tag if needed, and returns the tenant's tags on the conversation.
+func (r *PostgresRepository) TagConversation(ctx context.Context, tenantID, id, name string) ([]Tag, error) {
+	return r.addTag(ctx, conversationTagTarget, tenantID, id, name)
+}
+
+// UntagConversation removes a tenant's tag from a conversation.
+func (r *PostgresRepository) UntagConversation(ctx context.Context, tenantID, id, tagID string) error {
+	return r.removeTag(ctx, conversationTagTarget, tenantID, id, tagID)
+}
+
+// TagMessage puts the tenant's tag with the given name on a message, creating the tag if
+// needed, and returns the tenant's tags on the message.
+func (r *PostgresRepository) TagMessage(ctx context.Context, tenantID, id, name string) ([]Tag, error) {
+	return r.addTag(ctx, messageTagTarget, tenantID, id, name)
+}
+
+// UntagMessage removes a tenant's tag from a message.
+func (r *PostgresRepository) UntagMessage(ctx context.Context, tenantID, id, tagID string) error {
+	return r.removeTag(ctx, messageTagTarget, tenantID, id, tagID)
+}
+
+func (r *PostgresRepository) addTag(ctx context.Context, target tagTarget, tenantID, id, name string) ([]Tag, error) {
+	var tags []Tag
+	err := r.withSerializableTx(ctx, func(tx *sql.Tx) error {
+		var targetID int64
+		findTargetQuery := fmt.Sprintf(`SELECT id FROM %s WHERE id = $1`, target.table)
+		if err := tx.QueryRowContext(ctx, findTargetQuery, id).Scan(&targetID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.DBErrorNotFound
+			}
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to find %s %s", target.table, id))
+		}
+
+		upsertTagQuery := `INSERT INTO tags (tenant_id, name) VALUES ($1, $2) ON CONFLICT (tenant_id, (lower(name))) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, upsertTagQuery, tenantID, name); err != nil {
+			return apperrors.NewDBError(err, "failed to upsert tag")
+		}
+
+		linkTagQuery := fmt.Sprintf(`
+			INSERT INTO %s (%s, tag_id)
+			SELECT $1, id FROM tags WHERE tenant_id = $2 AND lower(name) = lower($3)
+			ON CONFLICT DO NOTHING
+		`, target.linkTable, target.column)
+		if _, err := tx.ExecContext(ctx, linkTagQuery, targetID, tenantID, name); err != nil {
+			return apperrors.NewDBError(err, fmt.Sprintf("failed to tag %s %s", target.table, id))
+		}
+
+		var err error
+		tags, err = loadTags(ctx, tx, target, tenantID, targetID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (r *PostgresRepository) removeTag(ctx context.Context, target tagTarget, tenantID, id, tagID string) error {
+	untagQuery := fmt.Sprintf(`
+		DELETE FROM %s
+		WHERE %s = $1 AND tag_id = $2
+		  AND EXISTS (SELECT 1 FROM tags t WHERE t.id = tag_id AND t.tenant_id = $3)
+	`, target.linkTable, target.column)
+	result, err := r.db.ExecContext(ctx, untagQuery, id, tagID, tenantID)
+	if err != nil {
+		return apperrors.NewDBError(err, fmt.Sprintf("failed to untag %s %s", target.table, id))
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperrors.DBErrorNotFound
+	}
+
+	return nil
+}
+
+// loadTags returns a tenant's tags on a conversation or message, by name.
+func loadTags(ctx context.Context, q querier, target tagTarget, tenantID string, id int64) ([]Tag, error) {
+	tagsQuery := fmt.Sprintf(`
+		SELECT t.id, t.name, t.created_at
+		FROM %s link
+		JOIN tags t ON t.id = link.tag_id
+		WHERE link.%s = $1 AND t.tenant_id = $2
+		ORDER BY lower(t.name)
+	`, target.linkTable, target.column)
+	rows, err := q.QueryContext(ctx, tagsQuery, id, tenantID)
+	if err != nil {
+		return nil, apperrors.NewDBError(err, fmt.Sprintf("failed to query tags of %s %d", target.table, id))
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var (
+			tag       Tag
+			createdAt time.Time
+		)
+		if err := rows.Scan(&tag.ID, &tag.Name, &createdAt); err != nil {
+			return nil, apperrors.NewDBError(err, "failed to scan tag row")
+		}
+		tag.CreatedAt = createdAt.Format(time.RFC3339)
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apperrors.NewDBError(err, "encountered error while iterating database rows")
+	}
+
+	return tags, nil
+}
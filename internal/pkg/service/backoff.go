@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock abstracts time so that retry timing can be tested.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is a Clock backed by the time package.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// BackoffPolicy controls how failed provider requests are retried.
+type BackoffPolicy struct {
+	BaseDelay   time.Duration // delay before the first retry
+	Multiplier  float64       // growth factor applied to the delay after each attempt
+	MaxDelay    time.Duration // upper bound for a single computed delay
+	FullJitter  bool          // draw each delay uniformly from [0, computed delay)
+	MaxAttempts int           // total number of attempts, including the first one
+	Deadline    time.Duration // total time budget across all attempts, 0 for none
+
+	// Random returns a number in [0, 1) and is used for jitter. Defaults to math/rand.
+	Random func() float64
+}
+
+// DefaultBackoffPolicy returns the policy used when none is configured.
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		BaseDelay:   100 * time.Millisecond,
+		Multiplier:  2,
+		MaxDelay:    5 * time.Second,
+		FullJitter:  true,
+		MaxAttempts: MaxRetries,
+		Deadline:    30 * time.Second,
+	}
+}
+
+// Delay returns how long to wait after the given failed attempt (starting at 1)
+// before trying again.
+func (p BackoffPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.FullJitter {
+		random := p.Random
+		if random == nil {
+			random = rand.Float64
+		}
+		delay *= random()
+	}
+
+	return time.Duration(delay)
+}
+
+// ParseRetryAfter parses a Retry-After header given either as delay seconds or
+// as an HTTP-date. It reports false when the header is missing or malformed.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// retryAfter returns the wait requested by a 429 or 503 response, if any.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	return ParseRetryAfter(resp.Header.Get("Retry-After"), now)
+}
+
+// sleep waits for d on clock, returning early with the context's error if it is done first.
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,175 @@
+package service_test
+
+import (
+	"context"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/service"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock records requested sleeps and advances time instantly.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func newTestService(transport *service.MockTransport, clock *fakeClock, policy service.BackoffPolicy) *service.ExternalService {
+	s := service.NewTwilioService(transport, "accountSID", "authToken")
+	s.Backoff = policy
+	s.Clock = clock
+	return s
+}
+
+func testPolicy() service.BackoffPolicy {
+	return service.BackoffPolicy{
+		BaseDelay:   100 * time.Millisecond,
+		Multiplier:  2,
+		MaxDelay:    time.Second,
+		MaxAttempts: 5,
+	}
+}
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	policy := testPolicy()
+
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Delay(2))
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(3))
+	assert.Equal(t, time.Second, policy.Delay(5), "expected delay to be capped at MaxDelay")
+
+	policy.FullJitter = true
+	policy.Random = func() float64 { return 0.5 }
+	assert.Equal(t, 200*time.Millisecond, policy.Delay(3), "expected full jitter to scale the computed delay")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 11, 1, 14, 0, 0, 0, time.UTC)
+
+	wait, ok := service.ParseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, wait)
+
+	wait, ok = service.ParseRetryAfter("Fri, 01 Nov 2024 14:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+
+	wait, ok = service.ParseRetryAfter("Fri, 01 Nov 2024 13:00:00 GMT", now)
+	assert.True(t, ok, "expected a date in the past to be valid")
+	assert.Zero(t, wait)
+
+	_, ok = service.ParseRetryAfter("soon", now)
+	assert.False(t, ok)
+
+	_, ok = service.ParseRetryAfter("", now)
+	assert.False(t, ok)
+}
+
+func TestSendMessageBacksOff(t *testing.T) {
+	transport := service.NewMockTransport("apiKey", "accountID", http.StatusInternalServerError, "")
+	clock := &fakeClock{now: time.Now()}
+	s := newTestService(transport, clock, testPolicy())
+
+	_, err := s.SendMessage(context.Background(), service.OutboundMessage{From: "+1234567890", To: "+0987654321", Body: "hi"})
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), transport.Requests())
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+	}, clock.sleeps)
+}
+
+func TestSendMessageKeepsLastProviderError(t *testing.T) {
+	transport := service.NewMockTransport("apiKey", "accountID", http.StatusServiceUnavailable, `{"code":30001,"message":"Queue overflow"}`)
+	clock := &fakeClock{now: time.Now()}
+
+	policy := testPolicy()
+	policy.MaxAttempts = 2
+	s := newTestService(transport, clock, policy)
+
+	_, err := s.SendMessage(context.Background(), service.OutboundMessage{From: "+1234567890", To: "+0987654321", Body: "hi"})
+
+	var serviceErr *apperrors.ServiceError
+	if assert.ErrorAs(t, err, &serviceErr) {
+		assert.True(t, serviceErr.Retryable)
+		assert.Equal(t, http.StatusServiceUnavailable, serviceErr.StatusCode, "expected the last provider status to be kept")
+		assert.Equal(t, "30001", serviceErr.Code, "expected the last provider code to be kept")
+	}
+}
+
+func TestSendMessageHonorsRetryAfter(t *testing.T) {
+	transport := service.NewMockTransport("apiKey", "accountID", http.StatusTooManyRequests, "")
+	transport.ResponseHeaders = map[string]string{"Retry-After": "3"}
+	clock := &fakeClock{now: time.Now()}
+
+	policy := testPolicy()
+	policy.MaxAttempts = 2
+	s := newTestService(transport, clock, policy)
+
+	_, err := s.SendMessage(context.Background(), service.OutboundMessage{From: "+1234567890", To: "+0987654321", Body: "hi"})
+
+	assert.Error(t, err)
+	assert.Equal(t, []time.Duration{3 * time.Second}, clock.sleeps, "expected Retry-After to override the computed delay")
+}
+
+func TestSendMessageStopsAtDeadline(t *testing.T) {
+	transport := service.NewMockTransport("apiKey", "accountID", http.StatusServiceUnavailable, "")
+	transport.ResponseHeaders = map[string]string{"Retry-After": "60"}
+	clock := &fakeClock{now: time.Now()}
+
+	policy := testPolicy()
+	policy.Deadline = 10 * time.Second
+	s := newTestService(transport, clock, policy)
+
+	_, err := s.SendMessage(context.Background(), service.OutboundMessage{From: "+1234567890", To: "+0987654321", Body: "hi"})
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), transport.Requests(), "expected no retry when Retry-After exceeds the deadline")
+	assert.Empty(t, clock.sleeps)
+}
+
+func TestSendMessageRespectsContext(t *testing.T) {
+	transport := service.NewMockTransport("apiKey", "accountID", http.StatusInternalServerError, "")
+
+	policy := testPolicy()
+	policy.BaseDelay = time.Hour
+	policy.MaxDelay = time.Hour
+	s := service.NewTwilioService(transport, "accountSID", "authToken")
+	s.Backoff = policy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.SendMessage(ctx, service.OutboundMessage{From: "+1234567890", To: "+0987654321", Body: "hi"})
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Minute, "expected the send to stop when the context is done")
+	assert.Equal(t, int64(1), transport.Requests())
+}
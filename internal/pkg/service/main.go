@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"hatchapp/internal/pkg/apperrors"
 	"io"
@@ -12,153 +14,159 @@ import (
 	"github.com/labstack/gommon/log"
 )
 
+// MaxRetries is the default number of attempts made for a single message.
 const MaxRetries = 3
 
-// MockRequest is a mock implementation of an HTTP request for testing purposes.
-type MockRequest struct {
-	Headers  map[string]string
-	Method   string
-	URL      string
-	Body     string
-	Response *http.Response
+const (
+	DefaultSendGridBaseURL = "https://api.sendgrid.com"
+	DefaultTwilioBaseURL   = "https://api.twilio.com"
+)
+
+// ExternalService is a Provider that sends messages through a Transport using the wire
+// format of its Adapter, retrying transient failures. It holds no per-request state, so
+// one instance can be shared by concurrent requests.
+type ExternalService struct {
+	Transport Transport
+	Adapter   Adapter
+	Backoff   BackoffPolicy
+	Clock     Clock
 }
 
-func (r *MockRequest) Do() (*http.Response, error) {
-	// Simulate an HTTP request
-	fmt.Printf("Mock request: %s %s\n", r.Method, r.URL)
-	if r.Body != "" {
-		fmt.Printf("Request body: %s\n", r.Body)
+// NewExternalService creates a service that sends messages encoded by adapter over transport.
+func NewExternalService(transport Transport, adapter Adapter) *ExternalService {
+	return &ExternalService{
+		Transport: transport,
+		Adapter:   adapter,
+		Backoff:   DefaultBackoffPolicy(),
+		Clock:     SystemClock{},
 	}
-	return r.Response, nil
 }
 
-type ExternalService struct {
-	Request    MockRequest
-	RetryCount int
+// NewTwilioService creates a Provider for SMS and MMS messages backed by Twilio.
+func NewTwilioService(transport Transport, accountSID, authToken string) *ExternalService {
+	return NewExternalService(transport, &TwilioAdapter{AccountSID: accountSID, AuthToken: authToken})
+}
+
+// NewSendGridService creates a Provider for email messages backed by SendGrid.
+func NewSendGridService(transport Transport, apiKey string) *ExternalService {
+	return NewExternalService(transport, &SendGridAdapter{APIKey: apiKey})
 }
 
 func NewEmailService(apiKey, accountID string) *ExternalService {
-	return &ExternalService{
-		Request: MockRequest{
-			Headers: map[string]string{
-				"X-API-Key":    apiKey,
-				"X-Account-ID": accountID,
-			},
-			Method: http.MethodPost,
-			URL:    "https://api.sendgrid.com/emails",
-
-			Response: &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(strings.NewReader(`{"status":"success"}`)),
-			},
-		},
+	transport := NewMockTransport(apiKey, accountID, http.StatusAccepted, "")
+	transport.Respond = func(Request) *http.Response {
+		return mockResponse(http.StatusAccepted, map[string]string{"X-Message-Id": mockMessageID("")}, "")
 	}
+	return NewSendGridService(transport, apiKey)
 }
 
 func NewEmailServiceWithError(apiKey, accountID string, statusCode int, body string) *ExternalService {
-	return &ExternalService{
-		Request: MockRequest{
-			Headers: map[string]string{
-				"X-API-Key":    apiKey,
-				"X-Account-ID": accountID,
-			},
-			Method: http.MethodPost,
-			URL:    "https://api.sendgrid.com/emails",
-			Response: &http.Response{
-				StatusCode: statusCode,
-				Body:       io.NopCloser(strings.NewReader(body)),
-			},
-		},
-	}
+	return NewSendGridService(NewMockTransport(apiKey, accountID, statusCode, body), apiKey)
 }
 
 func NewTextService(apiKey, accountID string) *ExternalService {
-	return &ExternalService{
-		Request: MockRequest{
-			Headers: map[string]string{
-				"X-API-Key":    apiKey,
-				"X-Account-ID": accountID,
-			},
-			Method: http.MethodPost,
-			URL:    "https://api.twilio.com/messages",
-			Response: &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(strings.NewReader(`{"status":"success"}`)),
-			},
-		},
+	transport := NewMockTransport(apiKey, accountID, http.StatusCreated, "")
+	transport.Respond = func(Request) *http.Response {
+		body := fmt.Sprintf(`{"sid":"%s","status":"queued"}`, mockMessageID("SM"))
+		return mockResponse(http.StatusCreated, nil, body)
 	}
+	return NewTwilioService(transport, accountID, apiKey)
 }
 
 func NewTextServiceWithError(apiKey, accountID string, statusCode int, body string) *ExternalService {
-	return &ExternalService{
-		Request: MockRequest{
-			Headers: map[string]string{
-				"X-API-Key":    apiKey,
-				"X-Account-ID": accountID,
-			},
-			Method: http.MethodPost,
-			URL:    "https://api.twilio.com/messages",
-			Response: &http.Response{
-				StatusCode: statusCode,
-				Body:       io.NopCloser(strings.NewReader(body)),
-			},
-		},
-	}
+	return NewTwilioService(NewMockTransport(apiKey, accountID, statusCode, body), accountID, apiKey)
 }
 
-func (s *ExternalService) SendMessageWithRetries(from, to, body string, attachments []string) (string, error) {
-	// Many API services return a 429 Too Many Requests status code when rate limiting.
-	// The `Retry-After` header can be used to determine how long to wait before retrying (exponential backoff).
-	// I'm opting for a simple retry mechanism here.
-
-	for s.RetryCount < MaxRetries {
-		resp, err := s.sendMessage(from, to, body, attachments)
-		if err != nil {
-			time.Sleep(time.Duration(s.RetryCount+1) * time.Millisecond)
-			log.Warnf("Attempt %d failed: %v", s.RetryCount+1, err)
-			continue
-		}
+// SendMessage sends msg through the provider, retrying transient failures according
+// to the service's BackoffPolicy. A Retry-After header on 429 and 503 responses takes
+// precedence over the computed delay.
+func (s *ExternalService) SendMessage(ctx context.Context, msg OutboundMessage) (string, error) {
+	start := s.Clock.Now()
 
-		switch resp.StatusCode {
-		case http.StatusUnauthorized:
-			return "", apperrors.NewServiceError(err, "unauthorized: check your API key or account ID")
-		case http.StatusNotFound:
-			return "", apperrors.NewServiceError(err, "not found")
-		case http.StatusForbidden:
-			return "", apperrors.NewServiceError(err, "access forbidden")
-		case http.StatusBadRequest:
-			return "", apperrors.NewServiceError(err, "bad request")
-		case http.StatusInternalServerError:
-			log.Warnf("Retrying due to server error (%d/%d)...\n", s.RetryCount+1, MaxRetries)
-			s.RetryCount++
-			continue
-		case http.StatusTooManyRequests:
-			log.Warnf("Rate limit exceeded (%d/%d): %v\n", s.RetryCount+1, MaxRetries, err)
-			s.RetryCount++
-			continue
-		case http.StatusOK:
+	var lastErr error
+	for attempt := 1; attempt <= s.Backoff.MaxAttempts; attempt++ {
+		providerID, wait, err := s.attempt(ctx, msg)
+		if err == nil {
 			log.Infof("Message sent successfully")
-			mockID := fmt.Sprintf("message-%d", rand.Intn(100)) // Mock message ID for demonstration
-			return mockID, nil
-		default:
-			log.Warnf("Unexpected status code %d: %v", resp.StatusCode, err)
-			return "", apperrors.NewServiceError(err, fmt.Sprintf("unexpected status code from service: %d", resp.StatusCode))
+			return providerID, nil
+		}
+
+		var serviceErr *apperrors.ServiceError
+		if errors.As(err, &serviceErr) && !serviceErr.Retryable {
+			return "", err
+		}
+
+		lastErr = err
+		if attempt == s.Backoff.MaxAttempts {
+			break
+		}
+
+		if wait == 0 {
+			wait = s.Backoff.Delay(attempt)
+		}
+
+		if s.Backoff.Deadline > 0 && s.Clock.Now().Add(wait).Sub(start) > s.Backoff.Deadline {
+			log.Warnf("Attempt %d/%d failed, retry deadline exceeded: %v", attempt, s.Backoff.MaxAttempts, err)
+			break
+		}
+
+		log.Warnf("Attempt %d/%d failed, retrying in %s: %v", attempt, s.Backoff.MaxAttempts, wait, err)
+		if err := sleep(ctx, s.Clock, wait); err != nil {
+			return "", apperrors.NewProviderError(err, 0, "", "message send cancelled while waiting to retry", true)
 		}
 	}
 
-	return "", fmt.Errorf("failed to send message after %d retries", MaxRetries)
+	// The failure is transient, so callers may try again later. The last provider response
+	// is kept so its status and code are recorded with the failure.
+	var statusCode int
+	var code string
+	var lastServiceErr *apperrors.ServiceError
+	if errors.As(lastErr, &lastServiceErr) {
+		statusCode, code = lastServiceErr.StatusCode, lastServiceErr.Code
+	}
+	return "", apperrors.NewProviderError(lastErr, statusCode, code, "failed to send message after retries", true)
 }
 
-func (s *ExternalService) sendMessage(from, to, body string, attachments []string) (*http.Response, error) {
-	// Simulate sending the message
-	fmt.Printf("Sending SMS from %s to %s: %s with attachments [%+v]\n", from, to, body, attachments)
+// attempt makes a single request to the provider. When the provider asks us to back
+// off it also returns the requested wait.
+func (s *ExternalService) attempt(ctx context.Context, msg OutboundMessage) (string, time.Duration, error) {
+	req, err := s.Adapter.EncodeRequest(msg)
+	if err != nil {
+		return "", 0, apperrors.NewServiceError(err, "failed to encode message")
+	}
 
-	s.Request.Body = fmt.Sprintf(`{"from":"%s","to":"%s","body":"%s","attachments":%+v}`, from, to, body, attachments)
-	resp, err := s.Request.Do()
+	resp, err := s.Transport.Do(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send message: %w", err)
+		if ctx.Err() != nil {
+			return "", 0, apperrors.NewProviderError(err, 0, "", "message send cancelled", false)
+		}
+		return "", 0, fmt.Errorf("failed to send message: %w", err)
 	}
 	defer resp.Body.Close()
 
-	return resp, nil
+	wait, _ := retryAfter(resp, s.Clock.Now())
+	providerID, err := s.Adapter.DecodeResponse(resp)
+
+	// Drain the body so the underlying connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return providerID, wait, err
+}
+
+func mockResponse(statusCode int, headers map[string]string, body string) *http.Response {
+	header := http.Header{}
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// mockMessageID generates a random message ID for mock providers.
+func mockMessageID(prefix string) string {
+	return fmt.Sprintf("%s%032x", prefix, rand.Uint64())
 }
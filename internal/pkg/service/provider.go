@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"net/http"
+)
+
+// OutboundMessage is a message to be delivered through a Provider.
+type OutboundMessage struct {
+	From        string
+	To          string
+	Subject     string
+	Body        string
+	Attachments []string
+}
+
+// Provider delivers outbound messages through an external messaging provider
+// and returns the provider's ID for the message.
+type Provider interface {
+	SendMessage(ctx context.Context, msg OutboundMessage) (string, error)
+}
+
+// Adapter translates outbound messages to a provider's wire format and
+// decodes the provider's responses.
+type Adapter interface {
+	EncodeRequest(msg OutboundMessage) (Request, error)
+	DecodeResponse(resp *http.Response) (string, error)
+}
+
+// isRetryableStatus reports whether a provider response status is worth retrying.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const DefaultEmailSubject = "New message"
+
+// sendGridErrors maps documented SendGrid v3 status codes to a short description.
+// See https://www.twilio.com/docs/sendgrid/api-reference/how-to-use-the-sendgrid-v3-api/responses
+var sendGridErrors = map[int]string{
+	http.StatusBadRequest:            "bad request",
+	http.StatusUnauthorized:          "unauthorized: check your API key",
+	http.StatusForbidden:             "access forbidden",
+	http.StatusNotFound:              "not found",
+	http.StatusRequestEntityTooLarge: "payload too large",
+	http.StatusTooManyRequests:       "too many requests",
+	http.StatusInternalServerError:   "sendgrid internal server error",
+	http.StatusServiceUnavailable:    "sendgrid service unavailable",
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[a-zA-Z/][^>]*>`)
+
+// SendGridAdapter speaks SendGrid's v3 mail/send JSON API.
+type SendGridAdapter struct {
+	APIKey string
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+}
+
+type sendGridErrorResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+		Field   string `json:"field"`
+	} `json:"errors"`
+}
+
+func (a *SendGridAdapter) EncodeRequest(msg OutboundMessage) (Request, error) {
+	subject := msg.Subject
+	if subject == "" {
+		subject = DefaultEmailSubject
+	}
+
+	contentType := "text/plain"
+	if htmlTagPattern.MatchString(msg.Body) {
+		contentType = "text/html"
+	}
+
+	// SendGrid only accepts base64 encoded attachment content, so attachment URLs
+	// are delivered as links appended to the body instead.
+	body := msg.Body
+	if len(msg.Attachments) > 0 {
+		separator := "\n"
+		if contentType == "text/html" {
+			separator = "<br>"
+		}
+		body += separator + strings.Join(msg.Attachments, separator)
+	}
+
+	payload, err := json.Marshal(sendGridMail{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: msg.To}}}},
+		From:             sendGridAddress{Email: msg.From},
+		Subject:          subject,
+		Content:          []sendGridContent{{Type: contentType, Value: body}},
+	})
+	if err != nil {
+		return Request{}, fmt.Errorf("failed to encode sendgrid request: %w", err)
+	}
+
+	return Request{
+		Method: http.MethodPost,
+		Path:   "/v3/mail/send",
+		Headers: map[string]string{
+			"Authorization": "Bearer " + a.APIKey,
+			"Content-Type":  "application/json",
+		},
+		Body: string(payload),
+	}, nil
+}
+
+func (a *SendGridAdapter) DecodeResponse(resp *http.Response) (string, error) {
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read sendgrid response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		messageID := resp.Header.Get("X-Message-Id")
+		if messageID == "" {
+			return "", apperrors.NewServiceError(errors.New("missing X-Message-Id"), "sendgrid response did not include a message id")
+		}
+		return messageID, nil
+	}
+
+	var sendGridErr sendGridErrorResponse
+	_ = json.Unmarshal(payload, &sendGridErr)
+
+	details := make([]string, 0, len(sendGridErr.Errors))
+	for _, e := range sendGridErr.Errors {
+		details = append(details, e.Message)
+	}
+
+	message, known := sendGridErrors[resp.StatusCode]
+	if !known {
+		message = fmt.Sprintf("unexpected status code from sendgrid: %d", resp.StatusCode)
+	}
+
+	return "", apperrors.NewProviderError(
+		fmt.Errorf("sendgrid error %d: %s", resp.StatusCode, strings.Join(details, "; ")),
+		resp.StatusCode,
+		strconv.Itoa(resp.StatusCode),
+		message,
+		isRetryableStatus(resp.StatusCode),
+	)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HeaderAPIKey    = "X-API-Key"
+	HeaderAccountID = "X-Account-ID"
+)
+
+// Request describes a single call to an external messaging provider.
+type Request struct {
+	Method  string
+	Path    string
+	Headers map[string]string
+	Body    string
+}
+
+// Transport sends requests to an external messaging provider.
+type Transport interface {
+	Do(ctx context.Context, req Request) (*http.Response, error)
+}
+
+// AuthHeaders builds the authentication headers sent with every provider request.
+func AuthHeaders(apiKey, accountID string) map[string]string {
+	return map[string]string{
+		HeaderAPIKey:    apiKey,
+		HeaderAccountID: accountID,
+	}
+}
+
+// HTTPTransport is a Transport backed by net/http.
+type HTTPTransport struct {
+	BaseURL string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// NewHTTPTransport creates a transport that sends requests to baseURL with the given headers.
+func NewHTTPTransport(baseURL string, timeout time.Duration, headers map[string]string) *HTTPTransport {
+	return &HTTPTransport{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Headers: headers,
+		Client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (t *HTTPTransport) Do(ctx context.Context, req Request) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, t.BaseURL+req.Path, strings.NewReader(req.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	for key, value := range t.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	return t.Client.Do(httpReq)
+}
+
+// MockTransport is a mock implementation of a Transport for testing purposes.
+// Every call returns a fresh response built by Respond or, when Respond is nil,
+// from the configured status code, headers and body.
+type MockTransport struct {
+	Headers         map[string]string
+	StatusCode      int
+	ResponseHeaders map[string]string
+	ResponseBody    string
+	Respond         func(req Request) *http.Response
+
+	requests atomic.Int64
+}
+
+// NewMockTransport creates a mock transport that always answers with statusCode and body.
+func NewMockTransport(apiKey, accountID string, statusCode int, body string) *MockTransport {
+	return &MockTransport{
+		Headers:      AuthHeaders(apiKey, accountID),
+		StatusCode:   statusCode,
+		ResponseBody: body,
+	}
+}
+
+func (t *MockTransport) Do(ctx context.Context, req Request) (*http.Response, error) {
+	t.requests.Add(1)
+
+	// Simulate an HTTP request
+	fmt.Printf("Mock request: %s %s\n", req.Method, req.Path)
+	if req.Body != "" {
+		fmt.Printf("Request body: %s\n", req.Body)
+	}
+
+	if t.Respond != nil {
+		return t.Respond(req), nil
+	}
+
+	return mockResponse(t.StatusCode, t.ResponseHeaders, t.ResponseBody), nil
+}
+
+// Requests returns the number of requests the mock has received.
+func (t *MockTransport) Requests() int64 {
+	return t.requests.Load()
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// twilioErrors maps documented Twilio error codes to a short description.
+// See https://www.twilio.com/docs/api/errors
+var twilioErrors = map[int]string{
+	20003: "authentication failed: check your account SID and auth token",
+	20404: "resource not found",
+	20429: "too many requests",
+	21211: "invalid 'To' phone number",
+	21212: "invalid 'From' phone number",
+	21408: "permission to send to this region is not enabled",
+	21606: "'From' number is not capable of sending this message",
+	21610: "recipient has unsubscribed from messages",
+	21614: "'To' number is not a valid mobile number",
+	21617: "message body exceeds the maximum length",
+	21620: "invalid media URL",
+	30001: "message queue overflow",
+}
+
+// TwilioAdapter speaks Twilio's form-encoded Messages API.
+type TwilioAdapter struct {
+	AccountSID string
+	AuthToken  string
+}
+
+type twilioMessageResponse struct {
+	SID    string `json:"sid"`
+	Status string `json:"status"`
+}
+
+type twilioErrorResponse struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	MoreInfo string `json:"more_info"`
+	Status   int    `json:"status"`
+}
+
+func (a *TwilioAdapter) EncodeRequest(msg OutboundMessage) (Request, error) {
+	form := url.Values{}
+	form.Set("From", msg.From)
+	form.Set("To", msg.To)
+	form.Set("Body", msg.Body)
+	for _, attachment := range msg.Attachments {
+		form.Add("MediaUrl", attachment)
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte(a.AccountSID + ":" + a.AuthToken))
+
+	return Request{
+		Method: http.MethodPost,
+		Path:   fmt.Sprintf("/2010-04-01/Accounts/%s/Messages.json", url.PathEscape(a.AccountSID)),
+		Headers: map[string]string{
+			"Authorization": "Basic " + credentials,
+			"Content-Type":  "application/x-www-form-urlencoded",
+		},
+		Body: form.Encode(),
+	}, nil
+}
+
+func (a *TwilioAdapter) DecodeResponse(resp *http.Response) (string, error) {
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read twilio response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		var result twilioMessageResponse
+		if err := json.Unmarshal(payload, &result); err != nil {
+			return "", apperrors.NewServiceError(err, "failed to decode twilio response")
+		}
+		if result.SID == "" {
+			return "", apperrors.NewServiceError(errors.New("missing sid"), "twilio response did not include a message sid")
+		}
+		return result.SID, nil
+	}
+
+	var twilioErr twilioErrorResponse
+	_ = json.Unmarshal(payload, &twilioErr)
+
+	message, known := twilioErrors[twilioErr.Code]
+	if !known {
+		message = fmt.Sprintf("unexpected status code from twilio: %d", resp.StatusCode)
+	}
+
+	code := ""
+	if twilioErr.Code != 0 {
+		code = strconv.Itoa(twilioErr.Code)
+	}
+
+	retryable := isRetryableStatus(resp.StatusCode) || twilioErr.Code == 20429 || twilioErr.Code == 30001
+	return "", apperrors.NewProviderError(
+		fmt.Errorf("twilio error %d: %s", twilioErr.Code, twilioErr.Message),
+		resp.StatusCode,
+		code,
+		message,
+		retryable,
+	)
+}
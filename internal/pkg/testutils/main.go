@@ -5,6 +5,7 @@ import (
 	"hatchapp/internal/app/server"
 	"hatchapp/internal/pkg/repository"
 	"hatchapp/internal/pkg/service"
+	"hatchapp/internal/pkg/worker"
 	"log"
 
 	"github.com/labstack/echo/v4"
@@ -37,8 +38,34 @@ type ServiceError struct {
 	Message string `json:"message"`
 }
 
-func NewServer(emailService, textService *service.ExternalService) *echo.Echo {
+func NewServer() *echo.Echo {
 	repo, _ := repository.GetRepository()
-	e := server.Initialize(server.NewServer(repo, emailService, textService))
+	e := server.Initialize(server.NewServer(repo))
 	return e
 }
+
+// NewServerWithWebhookVerifier creates a server that authenticates provider webhooks.
+func NewServerWithWebhookVerifier(verifier *server.WebhookVerifier) *echo.Echo {
+	repo, _ := repository.GetRepository()
+	s := server.NewServer(repo)
+	s.Webhooks = verifier
+	return server.Initialize(s)
+}
+
+// NewServerWithAPIKeys creates a server that requires an API key on every non-webhook
+// route.
+func NewServerWithAPIKeys() *echo.Echo {
+	repo, _ := repository.GetRepository()
+	s := server.NewServer(repo)
+	s.RequireAPIKeys = true
+	return server.Initialize(s)
+}
+
+// NewWorker creates an outbox worker that delivers through the given providers
+// without waiting between outbox retries.
+func NewWorker(emailService, textService service.Provider) *worker.Worker {
+	repo, _ := repository.GetRepository()
+	w := worker.New(repo, emailService, textService)
+	w.RetryDelay = 0
+	return w
+}
@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hatchapp/internal/pkg/apperrors"
+	"hatchapp/internal/pkg/repository"
+	"hatchapp/internal/pkg/service"
+	"sync"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	DefaultConcurrency  = 4
+	DefaultBatchSize    = 10
+	DefaultPollInterval = time.Second
+	DefaultLease        = 2 * time.Minute
+	DefaultMaxAttempts  = 5
+	DefaultRetryDelay   = 30 * time.Second
+)
+
+// Worker delivers queued outbound messages from the outbox through the providers.
+// Delivery is at-least-once: a worker that dies after the provider accepted a message
+// but before recording it leaves the row to be sent again once its lease expires.
+type Worker struct {
+	Repo         repository.Repository
+	Providers    map[string]service.Provider // keyed by communication type
+	Concurrency  int
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration // how long a claimed row is reserved for a worker, must exceed a single send
+	MaxAttempts  int           // outbox attempts before a message is marked failed
+	RetryDelay   time.Duration // delay before the first outbox retry, doubled per attempt
+}
+
+// New creates a worker with default settings that sends text messages through
+// textService and emails through emailService.
+func New(repo repository.Repository, emailService, textService service.Provider) *Worker {
+	return &Worker{
+		Repo: repo,
+		Providers: map[string]service.Provider{
+			repository.CommunicationTypeEmail: emailService,
+			repository.CommunicationTypePhone: textService,
+		},
+		Concurrency:  DefaultConcurrency,
+		BatchSize:    DefaultBatchSize,
+		PollInterval: DefaultPollInterval,
+		Lease:        DefaultLease,
+		MaxAttempts:  DefaultMaxAttempts,
+		RetryDelay:   DefaultRetryDelay,
+	}
+}
+
+// Run polls the outbox with Concurrency goroutines until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	log.Infof("Starting %d outbox workers...", w.Concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.poll(ctx)
+		}()
+	}
+	wg.Wait()
+
+	log.Info("Outbox workers stopped")
+}
+
+func (w *Worker) poll(ctx context.Context) {
+	for {
+		processed, err := w.ProcessBatch(ctx)
+		if err != nil {
+			log.Errorf("failed to process outbox batch: %v", err)
+		}
+
+		// Keep draining while there is work, otherwise wait for the next poll.
+		if processed > 0 && err == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+// ProcessBatch claims up to BatchSize outbox rows and delivers them, returning the
+// number of rows claimed. Rows are sent one after another, so the lease on each row is
+// renewed right before it is sent; rows whose lease ran out while earlier rows were
+// being sent and that another worker claimed in the meantime are skipped.
+func (w *Worker) ProcessBatch(ctx context.Context) (int, error) {
+	items, err := w.Repo.ClaimOutbox(ctx, w.BatchSize, w.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, item := range items {
+		// Shutting down: hand the rows not sent yet back so another worker can pick them up
+		// right away.
+		if ctx.Err() != nil {
+			w.release(items[i:])
+			break
+		}
+
+		lockedUntil, err := w.Repo.RenewOutboxLease(ctx, item, w.Lease)
+		if err != nil {
+			if errors.Is(err, apperrors.DBErrorNotFound) {
+				log.Warnf("lost the lease on message %d to %s, skipping it", item.MessageID, item.To)
+			} else {
+				log.Errorf("failed to renew the lease on message %d: %v", item.MessageID, err)
+			}
+			continue
+		}
+		item.LockedUntil = lockedUntil
+
+		if err := w.deliver(ctx, item); err != nil {
+			log.Errorf("failed to record delivery of message %d: %v", item.MessageID, err)
+		}
+	}
+
+	return len(items), nil
+}
+
+// release hands claimed rows back to the outbox. It ignores the worker's context, which
+// is done by the time rows are released.
+func (w *Worker) release(items []repository.OutboxItem) {
+	for _, item := range items {
+		if err := w.Repo.ReleaseOutbox(context.Background(), item); err != nil {
+			log.Errorf("failed to release message %d to %s: %v", item.MessageID, item.To, err)
+		}
+	}
+}
+
+func (w *Worker) deliver(ctx context.Context, item repository.OutboxItem) error {
+	provider, found := w.Providers[item.CommunicationType]
+	if !found {
+		return w.Repo.FailOutbox(ctx, item, "", fmt.Sprintf("no provider for %s messages", item.CommunicationType))
+	}
+
+	providerID, err := provider.SendMessage(ctx, service.OutboundMessage{
+		From:        item.From,
+		To:          item.To,
+		Subject:     item.Subject,
+		Body:        item.Body,
+		Attachments: item.Attachments,
+	})
+	if err == nil {
+		return w.Repo.CompleteOutbox(ctx, item, providerID)
+	}
+
+	// Shutting down: the send was interrupted, so it does not count as an attempt.
+	if ctx.Err() != nil {
+		w.release([]repository.OutboxItem{item})
+		return nil
+	}
+
+	reason := err.Error()
+	errorCode := ""
+	retryable := true
+
+	var serviceErr *apperrors.ServiceError
+	if errors.As(err, &serviceErr) {
+		errorCode = serviceErr.Code
+		retryable = serviceErr.Retryable
+		if serviceErr.Err != nil {
+			reason = fmt.Sprintf("%s: %v", serviceErr.Message, serviceErr.Err)
+		}
+	}
+
+	if !retryable || item.Attempts >= w.MaxAttempts {
+		log.Warnf("giving up on message %d after %d attempts: %s", item.MessageID, item.Attempts, reason)
+		return w.Repo.FailOutbox(ctx, item, errorCode, reason)
+	}
+
+	retryAt := time.Now().Add(w.RetryDelay << (item.Attempts - 1))
+	log.Warnf("failed to send message %d, retrying at %s: %s", item.MessageID, retryAt.Format(time.RFC3339), reason)
+	return w.Repo.RetryOutbox(ctx, item, retryAt, errorCode, reason)
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS outbox;
+
+-- Postgres cannot drop a single enum value, so the type is rebuilt without 'queued'.
+UPDATE messages SET message_status = 'failed' WHERE message_status = 'queued';
+
+ALTER TYPE message_status RENAME TO message_status_old;
+CREATE TYPE message_status AS ENUM ('success', 'failed');
+ALTER TABLE messages ALTER COLUMN message_status TYPE message_status USING message_status::text::message_status;
+DROP TYPE message_status_old;
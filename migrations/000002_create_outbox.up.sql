@@ -0,0 +1,20 @@
+-- New enum values cannot be used in the transaction that adds them, so nothing below refers to 'queued'.
+ALTER TYPE message_status ADD VALUE IF NOT EXISTS 'queued';
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    recipient TEXT NOT NULL, -- e.g. "+18045551234" or "user@example.com"
+    attempts INT NOT NULL DEFAULT 0,
+    available_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    locked_until TIMESTAMP WITH TIME ZONE, -- lease held by the worker that claimed the row
+    processed_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Speeds up claiming: pending rows ordered by availability
+CREATE INDEX idx_outbox_pending ON outbox(available_at, id) WHERE processed_at IS NULL;
+
+-- Speeds up JOIN: outbox -> messages
+CREATE INDEX idx_outbox_message_id ON outbox(message_id);
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS message_status_history;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS status_updated_at,
+    DROP COLUMN IF EXISTS error_code;
+
+ALTER TABLE messages ALTER COLUMN message_status TYPE TEXT;
+
+UPDATE messages
+SET message_status = CASE
+    WHEN message_status IN ('sent', 'delivered', 'received') THEN 'success'
+    WHEN message_status IN ('queued', 'sending') THEN 'queued'
+    ELSE 'failed'
+END;
+
+DROP TYPE message_status;
+CREATE TYPE message_status AS ENUM ('success', 'failed', 'queued');
+
+ALTER TABLE messages ALTER COLUMN message_status TYPE message_status USING message_status::message_status;
@@ -0,0 +1,42 @@
+-- Replace the success/failed statuses with the full delivery lifecycle.
+-- 'success' becomes 'sent' for messages that went through the outbox and 'received' for everything else.
+ALTER TABLE messages ALTER COLUMN message_status TYPE TEXT;
+
+UPDATE messages m
+SET message_status = CASE
+    WHEN EXISTS (SELECT 1 FROM outbox o WHERE o.message_id = m.id) THEN 'sent'
+    ELSE 'received'
+END
+WHERE m.message_status = 'success';
+
+DROP TYPE message_status;
+CREATE TYPE message_status AS ENUM (
+    'queued',      -- stored, waiting for a delivery worker
+    'sending',     -- claimed by a delivery worker
+    'sent',        -- accepted by the provider
+    'delivered',   -- provider confirmed delivery to the recipient
+    'undelivered', -- provider could not deliver to the recipient
+    'failed',      -- we or the provider gave up sending
+    'received'     -- inbound message from a provider webhook
+);
+
+ALTER TABLE messages ALTER COLUMN message_status TYPE message_status USING message_status::message_status;
+
+ALTER TABLE messages
+    ADD COLUMN status_updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    ADD COLUMN error_code TEXT; -- provider error code of the latest failure
+
+CREATE TABLE IF NOT EXISTS message_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    message_status message_status NOT NULL,
+    provider_error_code TEXT,
+    error_message TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Speeds up loading the history of a message in order
+CREATE INDEX idx_message_status_history_message_id_created_at ON message_status_history(message_id, created_at);
+
+INSERT INTO message_status_history (message_id, message_status, created_at)
+SELECT id, message_status, created_at FROM messages;
@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_messages_provider_id;
+
+-- Postgres cannot drop a single enum value, so the type is rebuilt without 'read'.
+ALTER TABLE messages ALTER COLUMN message_status TYPE TEXT;
+ALTER TABLE message_status_history ALTER COLUMN message_status TYPE TEXT;
+
+UPDATE messages SET message_status = 'delivered' WHERE message_status = 'read';
+UPDATE message_status_history SET message_status = 'delivered' WHERE message_status = 'read';
+
+DROP TYPE message_status;
+CREATE TYPE message_status AS ENUM ('queued', 'sending', 'sent', 'delivered', 'undelivered', 'failed', 'received');
+
+ALTER TABLE messages ALTER COLUMN message_status TYPE message_status USING message_status::message_status;
+ALTER TABLE message_status_history ALTER COLUMN message_status TYPE message_status USING message_status::message_status;
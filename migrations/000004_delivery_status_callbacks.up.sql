@@ -0,0 +1,5 @@
+-- Recipient opened (email) or read (SMS/WhatsApp) the message
+ALTER TYPE message_status ADD VALUE IF NOT EXISTS 'read';
+
+-- Speeds up status callback lookups BY messages.provider_id
+CREATE INDEX idx_messages_provider_id ON messages(provider_id);
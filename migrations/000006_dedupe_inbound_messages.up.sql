@@ -0,0 +1,23 @@
+-- The channel a message travelled over, so provider IDs only need to be unique per provider
+ALTER TABLE messages ADD COLUMN channel communication_type;
+
+UPDATE messages m
+SET channel = comm.communication_type
+FROM communications comm
+WHERE comm.id = m.sender_id;
+
+ALTER TABLE messages ALTER COLUMN channel SET NOT NULL;
+
+-- Providers redeliver webhooks; keep the first copy of every inbound message
+DELETE FROM messages m
+USING messages original
+WHERE m.message_status = 'received'
+  AND original.message_status = 'received'
+  AND m.channel = original.channel
+  AND m.provider_id = original.provider_id
+  AND m.provider_id <> ''
+  AND m.id > original.id;
+
+CREATE UNIQUE INDEX idx_messages_inbound_provider_id
+ON messages(channel, provider_id)
+WHERE message_status = 'received' AND provider_id <> '';
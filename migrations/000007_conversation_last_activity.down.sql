@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_conversations_last_activity_at_id;
+
+ALTER TABLE conversations DROP COLUMN IF EXISTS last_activity_at;
@@ -0,0 +1,14 @@
+ALTER TABLE conversations ADD COLUMN last_activity_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE conversations c
+SET last_activity_at = COALESCE(
+    (SELECT max(m.created_at) FROM messages m WHERE m.conversation_id = c.id),
+    c.created_at,
+    now()
+);
+
+ALTER TABLE conversations ALTER COLUMN last_activity_at SET DEFAULT now();
+ALTER TABLE conversations ALTER COLUMN last_activity_at SET NOT NULL;
+
+-- Speeds up keyset pagination ORDER BY conversations.last_activity_at, id
+CREATE INDEX idx_conversations_last_activity_at_id ON conversations(last_activity_at DESC, id DESC);
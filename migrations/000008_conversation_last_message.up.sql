@@ -0,0 +1,21 @@
+ALTER TABLE conversations
+    ADD COLUMN last_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+    ADD COLUMN message_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE conversations c
+SET message_count = counts.message_count
+FROM (
+    SELECT conversation_id, count(*) AS message_count
+    FROM messages
+    GROUP BY conversation_id
+) counts
+WHERE counts.conversation_id = c.id;
+
+UPDATE conversations c
+SET last_message_id = latest.id
+FROM (
+    SELECT DISTINCT ON (conversation_id) conversation_id, id
+    FROM messages
+    ORDER BY conversation_id, created_at DESC, id DESC
+) latest
+WHERE latest.conversation_id = c.id;
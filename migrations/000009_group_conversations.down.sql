@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_conversation_memberships_communication_id;
+
+DROP TABLE IF EXISTS message_recipients;
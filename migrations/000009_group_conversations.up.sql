@@ -0,0 +1,30 @@
+-- Delivery status of an outbound message for each of its recipients
+CREATE TABLE message_recipients (
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    communication_id BIGINT NOT NULL REFERENCES communications(id),
+    message_status message_status NOT NULL,
+    provider_id TEXT,
+    error_code TEXT,
+    status_updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (message_id, communication_id)
+);
+
+-- Speeds up status callback lookups BY message_recipients.provider_id
+CREATE INDEX idx_message_recipients_provider_id ON message_recipients(provider_id);
+
+-- Speeds up finding the conversations a participant belongs to
+CREATE INDEX idx_conversation_memberships_communication_id ON conversation_memberships(communication_id);
+
+-- Outbound messages so far had a single recipient: the one of their latest outbox row
+INSERT INTO message_recipients (message_id, communication_id, message_status, provider_id, error_code, status_updated_at)
+SELECT DISTINCT ON (o.message_id)
+    o.message_id,
+    comm.id,
+    m.message_status,
+    m.provider_id,
+    m.error_code,
+    m.status_updated_at
+FROM outbox o
+JOIN messages m ON m.id = o.message_id
+JOIN communications comm ON comm.identifier = o.recipient
+ORDER BY o.message_id, o.id DESC;
@@ -0,0 +1,3 @@
+ALTER TABLE communications DROP COLUMN IF EXISTS contact_id;
+
+DROP TABLE IF EXISTS contacts;
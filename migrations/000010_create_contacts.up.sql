@@ -0,0 +1,20 @@
+CREATE TABLE contacts (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    -- Set while the contact is merged into another one; its identities then belong to that contact
+    merged_into_id BIGINT REFERENCES contacts(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Speeds up keyset pagination ORDER BY contacts.created_at, id
+CREATE INDEX idx_contacts_created_at_id ON contacts(created_at DESC, id DESC);
+
+-- Speeds up resolving the contacts merged into a contact
+CREATE INDEX idx_contacts_merged_into_id ON contacts(merged_into_id);
+
+ALTER TABLE communications ADD COLUMN contact_id BIGINT REFERENCES contacts(id) ON DELETE SET NULL;
+
+-- Speeds up JOIN: contacts -> communications
+CREATE INDEX idx_communications_contact_id ON communications(contact_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_conversation_id_direction;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS direction;
+
+DROP TYPE IF EXISTS message_direction;
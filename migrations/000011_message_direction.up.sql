@@ -0,0 +1,13 @@
+CREATE TYPE message_direction AS ENUM ('inbound', 'outbound');
+
+-- Whether a message was received from a customer or sent by us
+ALTER TABLE messages ADD COLUMN direction message_direction;
+
+-- Only inbound messages are stored as received; everything else went through the outbox
+UPDATE messages
+SET direction = CASE WHEN message_status = 'received' THEN 'inbound' ELSE 'outbound' END::message_direction;
+
+ALTER TABLE messages ALTER COLUMN direction SET NOT NULL;
+
+-- Speeds up filtering a conversation's messages by direction
+CREATE INDEX idx_messages_conversation_id_direction ON messages(conversation_id, direction, created_at);
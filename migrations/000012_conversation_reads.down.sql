@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_conversations_inbound_count;
+
+DROP TABLE IF EXISTS conversation_reads;
+
+ALTER TABLE conversations DROP COLUMN IF EXISTS inbound_count;
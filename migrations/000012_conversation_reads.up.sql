@@ -0,0 +1,30 @@
+-- Number of inbound messages ever received in the conversation, so unread counts are a
+-- subtraction instead of a count over messages
+ALTER TABLE conversations ADD COLUMN inbound_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE conversations c
+SET inbound_count = counts.inbound_count
+FROM (
+    SELECT conversation_id, count(*) AS inbound_count
+    FROM messages
+    WHERE direction = 'inbound'
+    GROUP BY conversation_id
+) counts
+WHERE counts.conversation_id = c.id;
+
+-- How far each user has read each conversation
+CREATE TABLE conversation_reads (
+    user_id TEXT NOT NULL,
+    conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    last_read_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+    -- conversations.inbound_count when the user last read the conversation
+    read_inbound_count INTEGER NOT NULL DEFAULT 0,
+    read_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, conversation_id)
+);
+
+-- Speeds up JOIN: conversations -> conversation_reads
+CREATE INDEX idx_conversation_reads_conversation_id ON conversation_reads(conversation_id);
+
+-- Speeds up totalling unread messages over the conversations that have inbound messages
+CREATE INDEX idx_conversations_inbound_count ON conversations(id) INCLUDE (inbound_count) WHERE inbound_count > 0;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_search_vector;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+
+DROP FUNCTION IF EXISTS strip_html(TEXT);
@@ -0,0 +1,19 @@
+-- Reduces an HTML email body to its text. Plain text bodies pass through unchanged.
+CREATE FUNCTION strip_html(body TEXT) RETURNS TEXT
+LANGUAGE sql IMMUTABLE PARALLEL SAFE
+AS $$
+    SELECT regexp_replace(
+        regexp_replace(
+            regexp_replace(COALESCE(body, ''), '<(script|style)[^>]*>.*?</(script|style)>', ' ', 'gi'),
+            '<[^>]*>', ' ', 'g'
+        ),
+        '&(#[0-9]+|[a-z]+);', ' ', 'gi'
+    )
+$$;
+
+ALTER TABLE messages
+    ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', strip_html(body))) STORED;
+
+-- Speeds up full-text search over message bodies
+CREATE INDEX idx_messages_search_vector ON messages USING GIN (search_vector);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_messages_failed_conversation_id;
+DROP INDEX IF EXISTS idx_communications_identifier_prefix;
+DROP INDEX IF EXISTS idx_conversations_type_created_at;
+DROP INDEX IF EXISTS idx_conversations_type_last_activity;
+DROP INDEX IF EXISTS idx_conversations_created_at_id;
+
+CREATE INDEX idx_conversations_created_at ON conversations(created_at DESC);
+
+ALTER TABLE conversations DROP COLUMN IF EXISTS communication_type;
@@ -0,0 +1,27 @@
+-- Every participant of a conversation has the same communication type
+ALTER TABLE conversations ADD COLUMN communication_type communication_type;
+
+UPDATE conversations c
+SET communication_type = participant.communication_type
+FROM (
+    SELECT DISTINCT ON (cm.conversation_id) cm.conversation_id, comm.communication_type
+    FROM conversation_memberships cm
+    JOIN communications comm ON comm.id = cm.communication_id
+    ORDER BY cm.conversation_id, comm.id
+) participant
+WHERE participant.conversation_id = c.id;
+
+-- Speeds up keyset pagination ORDER BY conversations.created_at, id in either direction
+DROP INDEX IF EXISTS idx_conversations_created_at;
+CREATE INDEX idx_conversations_created_at_id ON conversations(created_at DESC, id DESC);
+
+-- Speeds up filtering the conversation list by communication type, for both sorts
+CREATE INDEX idx_conversations_type_last_activity ON conversations(communication_type, last_activity_at DESC, id DESC);
+CREATE INDEX idx_conversations_type_created_at ON conversations(communication_type, created_at DESC, id DESC);
+
+-- Speeds up the participant prefix filter: identifier LIKE '+1555%'
+CREATE INDEX idx_communications_identifier_prefix ON communications(identifier text_pattern_ops);
+
+-- Speeds up finding conversations with failed messages
+CREATE INDEX idx_messages_failed_conversation_id ON messages(conversation_id)
+WHERE message_status IN ('failed', 'undelivered');
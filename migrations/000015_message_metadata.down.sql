@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_visible_conversation_id_created_at;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,9 @@
+ALTER TABLE messages
+    -- Internal annotations, e.g. tags, never sent to providers
+    ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}',
+    -- Set when the message is deleted; it is hidden from conversations but kept for audit
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Speeds up reading a conversation's visible messages ORDER BY messages.created_at, id
+CREATE INDEX idx_messages_visible_conversation_id_created_at ON messages(conversation_id, created_at, id)
+WHERE deleted_at IS NULL;
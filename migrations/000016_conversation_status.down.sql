@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_conversations_status_created_at;
+DROP INDEX IF EXISTS idx_conversations_status_last_activity;
+
+ALTER TABLE conversations
+    DROP COLUMN IF EXISTS status_updated_at,
+    DROP COLUMN IF EXISTS snoozed_until,
+    DROP COLUMN IF EXISTS status;
+
+DROP TYPE IF EXISTS conversation_status;
@@ -0,0 +1,15 @@
+CREATE TYPE conversation_status AS ENUM (
+    'open',     -- needs attention; new inbound messages reopen any conversation
+    'snoozed',  -- hidden until snoozed_until, then open again
+    'closed',   -- handled
+    'archived'  -- kept out of the way for good
+);
+
+ALTER TABLE conversations
+    ADD COLUMN status conversation_status NOT NULL DEFAULT 'open',
+    ADD COLUMN snoozed_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN status_updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+
+-- Speeds up listing conversations by status, for both sorts
+CREATE INDEX idx_conversations_status_last_activity ON conversations(status, last_activity_at DESC, id DESC);
+CREATE INDEX idx_conversations_status_created_at ON conversations(status, created_at DESC, id DESC);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS conversation_assignments;
+
+DROP INDEX IF EXISTS idx_conversations_assignee_last_activity;
+ALTER TABLE conversations DROP COLUMN IF EXISTS assignee_id;
+
+DROP TABLE IF EXISTS agents;
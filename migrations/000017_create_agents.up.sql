@@ -0,0 +1,33 @@
+CREATE TABLE agents (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    -- Available agents take part in the round-robin assignment of new inbound conversations
+    available BOOLEAN NOT NULL DEFAULT false,
+    -- When the agent was last assigned a conversation, so round-robin picks the agent waiting longest
+    last_assigned_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Speeds up picking the next available agent for round-robin assignment
+CREATE INDEX idx_agents_available_last_assigned_at ON agents(last_assigned_at NULLS FIRST, id) WHERE available;
+
+ALTER TABLE conversations ADD COLUMN assignee_id BIGINT REFERENCES agents(id);
+
+-- Speeds up listing an agent's (or the unassigned) conversations
+CREATE INDEX idx_conversations_assignee_last_activity ON conversations(assignee_id, last_activity_at DESC, id DESC);
+
+-- Every change of a conversation's assignee
+CREATE TABLE conversation_assignments (
+    id BIGSERIAL PRIMARY KEY,
+    conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    -- NULL when the conversation was unassigned
+    agent_id BIGINT REFERENCES agents(id),
+    -- The X-User-ID of the user who made the change, NULL for automatic assignments
+    assigned_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Speeds up reading a conversation's assignment history
+CREATE INDEX idx_conversation_assignments_conversation_id ON conversation_assignments(conversation_id, created_at, id);
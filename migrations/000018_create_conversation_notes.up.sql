@@ -0,0 +1,14 @@
+-- Internal notes agents leave on a conversation. They are kept apart from messages, so
+-- the outbox and the providers never see them.
+CREATE TABLE conversation_notes (
+    id BIGSERIAL PRIMARY KEY,
+    conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    -- The X-User-ID of the user who wrote the note
+    author TEXT NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Speeds up interleaving a conversation's notes with a page of its messages
+CREATE INDEX idx_conversation_notes_conversation_id ON conversation_notes(conversation_id, created_at, id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS message_tags;
+DROP TABLE IF EXISTS conversation_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,28 @@
+CREATE TABLE tags (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Tag names are unique regardless of case
+CREATE UNIQUE INDEX idx_tags_name ON tags(lower(name));
+
+CREATE TABLE conversation_tags (
+    conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (conversation_id, tag_id)
+);
+
+-- Speeds up filtering and counting conversations by tag
+CREATE INDEX idx_conversation_tags_tag_id ON conversation_tags(tag_id, conversation_id);
+
+CREATE TABLE message_tags (
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (message_id, tag_id)
+);
+
+-- Speeds up filtering and counting messages by tag
+CREATE INDEX idx_message_tags_tag_id ON message_tags(tag_id, message_id);
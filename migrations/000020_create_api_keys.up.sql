@@ -0,0 +1,13 @@
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- The start of the key, so it can be recognized without storing it
+    prefix TEXT NOT NULL,
+    -- SHA-256 of the key; the key itself is only shown once, when it is created
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    -- Updated at most once a minute
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,19 @@
+-- inbound_count and read_inbound_count only count messages that are not deleted
+UPDATE conversations c
+SET inbound_count = (
+    SELECT count(*)
+    FROM messages m
+    WHERE m.conversation_id = c.id AND m.direction = 'inbound' AND m.deleted_at IS NULL
+);
+
+UPDATE conversation_reads cr
+SET read_inbound_count = (
+    SELECT count(*)
+    FROM messages m, messages last_read
+    WHERE last_read.id = cr.last_read_message_id
+      AND m.conversation_id = cr.conversation_id
+      AND m.direction = 'inbound'
+      AND m.deleted_at IS NULL
+      AND (m.created_at, m.id) <= (last_read.created_at, last_read.id)
+)
+WHERE cr.last_read_message_id IS NOT NULL;
@@ -0,0 +1,3 @@
+-- The inbox user a key is issued to; requests made with the key act for this user.
+-- Keys of services that do not act for a user leave it NULL.
+ALTER TABLE api_keys ADD COLUMN user_id TEXT;
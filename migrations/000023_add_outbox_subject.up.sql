@@ -0,0 +1,3 @@
+-- The subject line of an outbound email, sent with every delivery attempt. NULL for text
+-- messages and for emails sent without one.
+ALTER TABLE outbox ADD COLUMN subject TEXT;
@@ -0,0 +1,6 @@
+ALTER TABLE idempotency_keys DROP COLUMN locked_until;
+
+DELETE FROM idempotency_keys WHERE owner <> '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (endpoint, idempotency_key);
+ALTER TABLE idempotency_keys DROP COLUMN owner;
@@ -0,0 +1,10 @@
+-- Keys are scoped to the API key that used them, so one client cannot replay or block
+-- another client's requests. Empty when the server runs without API keys.
+ALTER TABLE idempotency_keys ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (owner, endpoint, idempotency_key);
+
+-- How long the request holding an in-flight key may take. A reservation past it was left
+-- behind by a crashed server and can be taken over by a retry.
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TIMESTAMPTZ;
+UPDATE idempotency_keys SET locked_until = created_at WHERE status_code IS NULL;
@@ -0,0 +1,3 @@
+-- Speeds up the unread summary, which only counts open and snoozed conversations
+CREATE INDEX idx_conversations_unread ON conversations(id) INCLUDE (inbound_count)
+    WHERE inbound_count > 0 AND status IN ('open', 'snoozed');
@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS idx_messages_search_vector;
+ALTER TABLE messages DROP COLUMN search_vector;
+
+CREATE OR REPLACE FUNCTION strip_html(body TEXT) RETURNS TEXT
+LANGUAGE sql IMMUTABLE PARALLEL SAFE
+AS $$
+    SELECT regexp_replace(
+        regexp_replace(
+            regexp_replace(COALESCE(body, ''), '<(script|style)[^>]*>.*?</(script|style)>', ' ', 'gi'),
+            '<[^>]*>', ' ', 'g'
+        ),
+        '&(#[0-9]+|[a-z]+);', ' ', 'gi'
+    )
+$$;
+
+ALTER TABLE messages
+    ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', strip_html(body))) STORED;
+
+CREATE INDEX idx_messages_search_vector ON messages USING GIN (search_vector);
@@ -0,0 +1,30 @@
+-- strip_html removed everything from the first <script> or <style> block to the last
+-- one, as the regular expression as a whole was greedy. Each block is now removed on
+-- its own, and the stored search vectors are rebuilt with the fixed function.
+DROP INDEX IF EXISTS idx_messages_search_vector;
+ALTER TABLE messages DROP COLUMN search_vector;
+
+-- Reduces an HTML email body to its text. Plain text bodies pass through unchanged.
+-- The first quantifier decides the greediness of a whole pattern, so the block patterns
+-- start with a non-greedy one.
+CREATE OR REPLACE FUNCTION strip_html(body TEXT) RETURNS TEXT
+LANGUAGE sql IMMUTABLE PARALLEL SAFE
+AS $$
+    SELECT regexp_replace(
+        regexp_replace(
+            regexp_replace(
+                regexp_replace(COALESCE(body, ''), '<script\y.*?</script\s*>', ' ', 'gi'),
+                '<style\y.*?</style\s*>', ' ', 'gi'
+            ),
+            '<[^>]*>', ' ', 'g'
+        ),
+        '&(#[0-9]+|[a-z]+);', ' ', 'gi'
+    )
+$$;
+
+ALTER TABLE messages
+    ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', strip_html(body))) STORED;
+
+-- Speeds up full-text search over message bodies
+CREATE INDEX idx_messages_search_vector ON messages USING GIN (search_vector);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_tags_tenant_name;
+
+-- Only the default tenant's tags are unique across the deployment
+DELETE FROM tags WHERE tenant_id <> '';
+CREATE UNIQUE INDEX idx_tags_name ON tags(lower(name));
+
+ALTER TABLE tags DROP COLUMN tenant_id;
+ALTER TABLE api_keys DROP COLUMN tenant_id;
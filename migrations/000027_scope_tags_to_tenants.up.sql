@@ -0,0 +1,10 @@
+-- The tenant an API key belongs to. Keys of the same tenant share its tags. Empty for
+-- deployments serving a single tenant, and when the server runs without API keys.
+ALTER TABLE api_keys ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+-- Existing tags belong to the default tenant
+ALTER TABLE tags ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+-- Tag names are unique per tenant regardless of case
+DROP INDEX IF EXISTS idx_tags_name;
+CREATE UNIQUE INDEX idx_tags_tenant_name ON tags(tenant_id, lower(name));